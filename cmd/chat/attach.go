@@ -0,0 +1,120 @@
+package main
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "sync"
+    "time"
+    "gochat/internal/daemon"
+    "gochat/internal/tui"
+    tea "github.com/charmbracelet/bubbletea"
+)
+
+// Messages replayed from the daemon when attaching
+const attachHistory = 200
+
+// How often the peer names offered for completion are fetched again
+const peerRefresh = 5 * time.Second
+
+// peerCache keeps the daemon's peer names for completion. It is refreshed
+// in the background so the TUI never waits on the daemon.
+type peerCache struct {
+    mu sync.Mutex
+    names []string
+}
+
+func (c *peerCache) get() []string {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.names
+}
+
+// refresh polls the daemon until stop is closed or the connection is lost.
+// Completion keeps the last list if the daemon does not answer.
+func (c *peerCache) refresh(client *daemon.Client, stop <-chan struct{}) {
+    t := time.NewTicker(peerRefresh)
+    defer t.Stop()
+    for {
+        peers, err := client.Peers()
+        if errors.Is(err, daemon.ErrClientClosed) {
+            return
+        }
+        if err == nil {
+            names := make([]string, len(peers))
+            for i, p := range peers {
+                names[i] = p.Name
+            }
+            c.mu.Lock()
+            c.names = names
+            c.mu.Unlock()
+        }
+        select {
+        case <-stop:
+            return
+        case <-t.C:
+        }
+    }
+}
+
+// runAttach runs the TUI against a daemon's control socket. Quitting
+// detaches; the daemon's node stays in the mesh.
+func runAttach() error {
+    client, err := daemon.Dial(flags.Socket, attachHistory)
+    if err != nil {
+        return err
+    }
+    defer client.Close()
+    logger.Info("attached to daemon", "socket", flags.Socket)
+
+    model := tui.InitModelWithBackend(client)
+    if err := model.SetTheme(flags.Theme); err != nil {
+        return err
+    }
+    model.SetHighlights(flags.Name, flags.Highlights)
+    model.SetRaw(flags.Raw)
+    model.SetReadReceipts(flags.ReadReceipts)
+    if err := model.SetHistoryFile(filepath.Join(flags.DataDir, "input_history")); err != nil {
+        logger.Warn("could not load input history", "err", err)
+    }
+    if err := seedPresence(&model, client); err != nil {
+        logger.Warn("could not load presence from the daemon", "err", err)
+    }
+    peers := &peerCache{}
+    stop := make(chan struct{})
+    defer close(stop)
+    go peers.refresh(client, stop)
+    model.SetPeerSource(peers.get)
+    p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithReportFocus())
+    if _, err := p.Run(); err != nil {
+        return fmt.Errorf("error running TUI: %w", err)
+    }
+    logger.Info("detached from daemon")
+    return nil
+}
+
+// seedPresence shows the statuses the daemon already knows, which would
+// otherwise only appear once they change
+func seedPresence(model *tui.Model, client *daemon.Client) error {
+    status, note, err := client.Presence()
+    if err != nil {
+        return err
+    }
+    peers, err := client.Peers()
+    if err != nil {
+        return err
+    }
+    statuses := make(map[string]string, len(peers))
+    for _, p := range peers {
+        statuses[p.Name] = statusText(p.Status, p.Note)
+    }
+    model.SetPresence(statusText(status, note), statuses)
+    return nil
+}
+
+func statusText(status, note string) string {
+    if note == "" {
+        return status
+    }
+    return status + ": " + note
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "os"
+    "os/signal"
+    "syscall"
+    "gochat/internal/ircbridge"
+)
+
+// runBridge runs a headless node that relays a room to an IRC channel
+// until it receives SIGINT or SIGTERM
+func runBridge() error {
+    if !flags.IRC.Enabled() || flags.IRC.Channel == "" {
+        return errors.New("gochat bridge needs -irc-server and -irc-channel, or an [irc] section in the profile")
+    }
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+
+    n := newNode()
+    if err := n.Start(ctx); err != nil {
+        return err
+    }
+    logger.Info("bridge started", "irc", flags.IRC.Server, "channel", flags.IRC.Channel, "room", flags.IRC.Room)
+
+    err := ircbridge.New(n, flags.IRC, logger).Run(ctx)
+    cancel()
+    n.Wait()
+    logger.Info("bridge stopped")
+    return err
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+    "context"
+    "os"
+    "os/signal"
+    "syscall"
+    "gochat/internal/daemon"
+)
+
+// runDaemon runs the node without a UI until it receives SIGINT or SIGTERM
+func runDaemon() error {
+    ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer cancel()
+
+    n := newNode()
+    ln, err := daemon.Listen(flags.Socket)
+    if err != nil {
+        return err
+    }
+    defer os.Remove(flags.Socket)
+
+    if err := n.Start(ctx); err != nil {
+        ln.Close()
+        return err
+    }
+    logger.Info("daemon started", "socket", flags.Socket, "port", flags.Port)
+
+    err = daemon.NewServer(n, logger).Serve(ctx, ln)
+    cancel()
+    n.Wait()
+    logger.Info("daemon stopped")
+    return err
+}
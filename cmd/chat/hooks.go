@@ -0,0 +1,36 @@
+package main
+
+import (
+    "regexp"
+    "gochat/internal/hooks"
+    "gochat/internal/node"
+)
+
+// newNode creates the node for any mode, with the configured hooks
+// registered in a fixed order: filters first, so later hooks see clean
+// text, then link rewriting, then auto-replies
+func newNode() *node.Node {
+    n := node.New(flags, logger)
+    room := n.Room()
+    conf := flags.Hooks
+
+    if len(conf.Profanity) > 0 {
+        room.AddHook(hooks.NewProfanity(conf.Profanity))
+    }
+    if len(conf.Links) > 0 {
+        rules := make([]hooks.LinkRule, len(conf.Links))
+        for i, l := range conf.Links {
+            rules[i] = hooks.LinkRule{Prefix: l.Prefix, Replace: l.Replace}
+        }
+        room.AddHook(hooks.NewLinks(rules))
+    }
+    if len(conf.Replies) > 0 {
+        rules := make([]hooks.ReplyRule, len(conf.Replies))
+        for i, r := range conf.Replies {
+            // Patterns were checked when the config was loaded
+            rules[i] = hooks.ReplyRule{Pattern: regexp.MustCompile(r.Match), Reply: r.Reply}
+        }
+        room.AddHook(hooks.NewAutoReply(room, flags.Name, rules))
+    }
+    return n
+}
@@ -3,90 +3,157 @@ package main
 
 import (
     "context"
+    "errors"
+    "flag"
     "fmt"
-    "sync"
+    "log/slog"
     "os"
+    "path/filepath"
     "gochat/internal/config"
     "gochat/internal/util"
-    "gochat/internal/netx"
-    "gochat/internal/chat"
     "gochat/internal/tui"
     tea "github.com/charmbracelet/bubbletea"
 )
 
 var flags config.Config
+var logger *slog.Logger
 
 // Global channels for message handling
 var (
     outgoingMsgChan = make(chan string, 100)
-    incomingMsgChan = make(chan tui.Message, 100)
 )
 
+const usage = `Usage:
+  gochat [flags]          run a node with the terminal UI
+  gochat -pipe [flags]    run a node reading messages from stdin, events to stdout
+  gochat daemon [flags]   run a node headless, controlled over a Unix socket
+  gochat attach [flags]   run the terminal UI against a running daemon
+  gochat bridge [flags]   run a node headless, relaying a room to an IRC channel
+
+Run "gochat -h" for the list of flags.`
+
 func main() {
-    flags = config.Parse()
-    var room = chat.NewRoom()
-    var wg sync.WaitGroup
+    args := os.Args[1:]
+    mode := ""
+    if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+        mode, args = args[0], args[1:]
+    }
+    if mode != "" && mode != "daemon" && mode != "attach" && mode != "bridge" {
+        fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s\n", mode, usage)
+        os.Exit(2)
+    }
 
-    // Set up the TUI message channel for the chat room
-    room.SetTUIMessageChannel(incomingMsgChan)
+    var err error
+    flags, err = config.Load(args, os.Getenv)
+    if errors.Is(err, flag.ErrHelp) {
+        os.Exit(0)
+    }
+    if err == nil && flags.Pipe && mode != "" {
+        err = fmt.Errorf("-pipe cannot be combined with %s", mode)
+    }
+    if err != nil {
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(2)
+    }
+    if flags.Pipe {
+        mode = "pipe"
+    }
 
-    // Create TUI model with message channels
-    model := tui.InitModelWithChannels(outgoingMsgChan, incomingMsgChan)
-    p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseCellMotion())
-    
-    ln, err := netx.Listen(flags.Port)
+    logFile, err := util.NewRotatingFile(flags.LogFile, 10<<20, 3)
     if err != nil {
-        fmt.Fprintf(os.Stderr, "Failed to listen on port %d: %v\n", flags.Port, err)
+        fmt.Fprintf(os.Stderr, "Failed to open log file %s: %v\n", flags.LogFile, err)
         os.Exit(1)
     }
+    defer logFile.Close()
+
+    switch mode {
+    case "daemon":
+        logger = util.NewLogger(logFile, flags.LogLevel)
+        err = runDaemon()
+    case "attach":
+        logger = util.NewLogger(logFile, flags.LogLevel)
+        err = runAttach()
+    case "bridge":
+        logger = util.NewLogger(logFile, flags.LogLevel)
+        err = runBridge()
+    case "pipe":
+        logger = util.NewLogger(logFile, flags.LogLevel)
+        err = runPipe()
+    default:
+        err = runTUI(logFile)
+    }
+    if err != nil {
+        logger.Error("exiting", "err", err)
+        fmt.Fprintln(os.Stderr, err)
+        os.Exit(1)
+    }
+}
+
+func runTUI(logFile *util.RotatingFile) error {
+    // Records go to the log file at the configured level, and to the TUI log
+    // pane at every level so it can filter for itself
+    logRecords := make(chan util.LogRecord, 256)
+    logger = slog.New(util.Fanout(
+        util.NewHandler(logFile, flags.LogLevel),
+        util.NewChannelHandler(logRecords, util.Debug),
+    ))
+
+    if !flags.AwayAfterSet {
+        flags.AwayAfter = config.DefaultAwayAfter
+    }
+    n := newNode()
+    events, unsubscribe := n.Subscribe(100)
+    defer unsubscribe()
+
+    // Create TUI model with message channels
+    model := tui.InitModelWithChannels(outgoingMsgChan, events)
+    model.SetLogChannel(logRecords)
+    if err := model.SetTheme(flags.Theme); err != nil {
+        return err
+    }
+    model.SetHighlights(flags.Name, flags.Highlights)
+    model.SetRaw(flags.Raw)
+    model.SetReadReceipts(flags.ReadReceipts)
+    if err := model.SetHistoryFile(filepath.Join(flags.DataDir, "input_history")); err != nil {
+        logger.Warn("could not load input history", "err", err)
+    }
+    model.SetPeerSource(func() []string {
+        var names []string
+        for _, p := range n.Peers() {
+            names = append(names, p.Name)
+        }
+        return names
+    })
+    p := tea.NewProgram(model, tea.WithAltScreen(), tea.WithMouseCellMotion(), tea.WithReportFocus())
     
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
-    defer room.Shutdown()
 
-    wg.Add(3)
-    
-    // Start network goroutines
-    go netx.AcceptConnections(ctx, ln, flags.Name, &wg, room)
-    go netx.DailPeers(ctx, flags.Peers, flags.Name, &wg, room)
-    
-    // Start TUI
-    go func() {
-        defer wg.Done()
-        if _, err := p.Run(); err != nil {
-            fmt.Fprintf(os.Stderr, "Error starting TUI: %v\n", err)
-            cancel()
-            room.Shutdown()
-        }
-    }()
-    
+    if err := n.Start(ctx); err != nil {
+        return err
+    }
+
     // Handle messages between TUI and chat room
     go func() {
-        defer wg.Done()
         for {
             select {
             case <-ctx.Done():
                 return
             case msg := <-outgoingMsgChan:
-                // Send outgoing message to all peers
-                if msg != "" {
-                    fullMsg := fmt.Sprintf("%s: %s", flags.Name, msg)
-                    chat.Broadcast(room, fullMsg)
+                if err := n.Send(msg); err != nil {
+                    n.Notify(err.Error())
                 }
             }
         }
     }()
     
-    wg.Wait()
-    fmt.Println(util.Info, "All goroutines finished, exiting...")
-}
-
-// Helper function to send messages to TUI from other parts of the application
-func SendToTUI(from, text string) {
-    select {
-    case incomingMsgChan <- tui.Message{From: from, Text: text}:
-    default:
-        // Channel is full, drop message to prevent blocking
-        fmt.Println(util.Warning, "TUI message channel full, dropping message")
+    // Run the TUI until the user quits, then take the node down with it
+    _, err := p.Run()
+    cancel()
+    n.Wait()
+    logger.Info("all goroutines finished, exiting")
+    if err != nil {
+        return fmt.Errorf("error running TUI: %w", err)
     }
+    return nil
 }
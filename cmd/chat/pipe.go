@@ -0,0 +1,153 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "os"
+    "strings"
+    "time"
+    "gochat/internal/node"
+    "gochat/internal/tui"
+)
+
+// How long pipe mode waits for the configured peers before reading stdin,
+// and for queued messages to reach them before exiting
+const (
+    pipeConnectTimeout = 5 * time.Second
+    pipeFlushTimeout = 10 * time.Second
+)
+
+// runPipe broadcasts each line read from stdin and writes incoming events
+// to stdout until stdin is closed
+func runPipe() error {
+    n := newNode()
+    events, unsubscribe := n.Subscribe(256)
+    defer unsubscribe()
+
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    if err := n.Start(ctx); err != nil {
+        return err
+    }
+
+    // Write events until the node shuts down
+    written := make(chan struct{})
+    go func() {
+        defer close(written)
+        out := bufio.NewWriter(os.Stdout)
+        for msg := range events {
+            if err := writeEvent(out, msg, flags.Format); err != nil {
+                logger.Error("failed to write event", "err", err)
+                continue
+            }
+            out.Flush()
+        }
+    }()
+
+    // Lines typed before the peers are connected would go nowhere
+    waitForPeers(ctx, n, len(flags.Peers), pipeConnectTimeout)
+
+    // Handle messages between stdin and chat room
+    sent := make(chan struct{})
+    var undelivered []*node.UndeliveredError
+    failed := 0
+    go func() {
+        defer close(sent)
+        for msg := range outgoingMsgChan {
+            var missed *node.UndeliveredError
+            switch err := n.Send(msg); {
+            case errors.As(err, &missed):
+                undelivered = append(undelivered, missed)
+            case err != nil:
+                fmt.Fprintln(os.Stderr, err)
+                failed++
+            }
+        }
+    }()
+
+    err := readLines(os.Stdin, outgoingMsgChan)
+    close(outgoingMsgChan)
+    <-sent
+
+    flush := func() {
+        flushCtx, flushCancel := context.WithTimeout(ctx, pipeFlushTimeout)
+        defer flushCancel()
+        if ferr := n.Flush(flushCtx); ferr != nil {
+            logger.Warn("gave up waiting for messages to be delivered", "err", ferr)
+        }
+    }
+    flush()
+    if len(undelivered) > 0 {
+        // The queues have drained, so lines that missed a peer get one
+        // more try before they are reported
+        for _, u := range undelivered {
+            n.Room().Retry(u.ID)
+        }
+        flush()
+        for _, u := range undelivered {
+            if missed := n.Undelivered(u.ID); len(missed) > 0 {
+                fmt.Fprintf(os.Stderr, "not delivered to %s: %s\n", strings.Join(missed, ", "), u.Text)
+                failed++
+            }
+        }
+    }
+    if err == nil && failed > 0 {
+        err = fmt.Errorf("%d lines were not delivered to every peer", failed)
+    }
+
+    cancel()
+    n.Wait()
+    <-written
+    return err
+}
+
+// readLines sends each non-empty line of r to out
+func readLines(r io.Reader, out chan<- string) error {
+    sc := bufio.NewScanner(r)
+    sc.Buffer(make([]byte, 0, 4096), 1<<20)
+    for sc.Scan() {
+        if line := strings.TrimSpace(sc.Text()); line != "" {
+            out <- line
+        }
+    }
+    return sc.Err()
+}
+
+// writeEvent writes one event as a sender<TAB>text line or a JSON object.
+// Our own messages echoed back by the node are left out, and plain output
+// only has chat lines and notices.
+func writeEvent(w io.Writer, msg tui.Message, format string) error {
+    if msg.Self {
+        return nil
+    }
+    if format == "json" {
+        b, err := json.Marshal(msg)
+        if err != nil {
+            return err
+        }
+        _, err = fmt.Fprintf(w, "%s\n", b)
+        return err
+    }
+    if msg.Kind != tui.KindText {
+        return nil
+    }
+    // Keep one event per line
+    text := strings.ReplaceAll(msg.Text, "\n", " ")
+    _, err := fmt.Fprintf(w, "%s\t%s\n", msg.From, text)
+    return err
+}
+
+func waitForPeers(ctx context.Context, n *node.Node, want int, timeout time.Duration) {
+    deadline := time.Now().Add(timeout)
+    for len(n.Peers()) < want && time.Now().Before(deadline) {
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(50 * time.Millisecond):
+        }
+    }
+}
@@ -2,33 +2,127 @@
 package chat
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"gochat/internal/util"
+	"io"
+	"log/slog"
+	"math/rand/v2"
 	"net"
 	"strings"
 	"sync"
+	"time"
     "github.com/google/uuid"
     "gochat/internal/tui"
 )
 
+var (
+    errPeerClosed = errors.New("peer connection closed")
+    errQueueFull = errors.New("peer send queue full")
+)
+
+// How long Send waits for room in a peer's queue before giving up on it
+const sendWait = 5 * time.Second
+
 type Peer struct {
     uuid string
     Name string
+    NodeID string // from the handshake; empty for peers without an identity
+    key []byte
     Conn net.Conn
     mu sync.Mutex
+    closed bool
+    session *Session
+    log *slog.Logger
+    // Control and chat frames are queued here and written to their mux
+    // streams by writeLoop, so Send never blocks. File chunks are written
+    // straight to the bulk stream, whose flow-control window paces them.
+    control chan []byte
+    chat chan []byte
+    done chan struct{}
+    pending int // frames queued but not yet written
+    idle chan struct{} // closed while pending is zero
+    status string // presence the peer last announced, empty for online
+    statusNote string
 }
 
 type ChatRoom struct {
-    Peers []Peer
+    Peers []*Peer
     mu sync.Mutex
     // Add channel for sending messages to TUI
     tuiMsgChan chan<- tui.Message
+    peerEvents chan<- PeerEvent
+    hookMu sync.Mutex
+    hooks []Hook
+    files *transferSet
+    authors *authorLog
+    receipts *receiptLog
+    identity *Identity
+    offline *offlineStore // nil unless messages are kept for absent nodes
+    filters filterSet
+    presenceMu sync.Mutex
+    status string // the local user's presence, empty for online
+    statusNote string
+    streamMu sync.Mutex
+    streamHandlers map[string]StreamHandler
+    log *slog.Logger
+}
+
+// PeerEvent reports a peer joining or leaving the room
+type PeerEvent struct {
+    Name string
+    Addr string
+    Joined bool // false when the peer left
 }
 
+// StreamHandler serves a stream opened by a peer with Peer.OpenStream
+type StreamHandler func(peer *Peer, st *Stream)
+
 func NewRoom() *ChatRoom {
     return &ChatRoom{
-        Peers: make([]Peer, 0),
+        Peers: make([]*Peer, 0),
+        files: newTransferSet(),
+        authors: newAuthorLog(),
+        receipts: newReceiptLog(),
+        streamHandlers: make(map[string]StreamHandler),
+        log: util.Discard(),
+    }
+}
+
+// SetLogger sets where the room and its peers log. Until it is called
+// nothing is logged.
+func (cr *ChatRoom) SetLogger(l *slog.Logger) {
+    cr.log = util.OrDiscard(l)
+}
+
+func (cr *ChatRoom) Logger() *slog.Logger {
+    return cr.log
+}
+
+// HandleStream registers fn for streams peers open with the given protocol
+// name. Streams for unregistered protocols are closed straight away.
+func (cr *ChatRoom) HandleStream(proto string, fn StreamHandler) {
+    cr.streamMu.Lock()
+    defer cr.streamMu.Unlock()
+    cr.streamHandlers[proto] = fn
+}
+
+func (cr *ChatRoom) acceptStreams(peer *Peer) {
+    for {
+        st, err := peer.session.Accept()
+        if err != nil {
+            return
+        }
+        cr.streamMu.Lock()
+        fn := cr.streamHandlers[st.Protocol()]
+        cr.streamMu.Unlock()
+        if fn == nil {
+            st.Close()
+            continue
+        }
+        go fn(peer, st)
     }
 }
 
@@ -37,177 +131,494 @@ func (cr *ChatRoom) SetTUIMessageChannel(ch chan<- tui.Message) {
     cr.tuiMsgChan = ch
 }
 
-func (cr *ChatRoom) AddPeer(name string, conn net.Conn) {
+// SetDownloadDir sets where accepted file transfers are saved
+func (cr *ChatRoom) SetDownloadDir(dir string) {
+    cr.files.setDir(dir)
+}
+
+// notify forwards msg to the TUI without ever blocking the caller
+func (cr *ChatRoom) notify(msg tui.Message) {
+    if cr.tuiMsgChan == nil {
+        return
+    }
+    select {
+    case cr.tuiMsgChan <- msg:
+    default:
+        cr.log.Warn("TUI message channel full, dropping message", "from", msg.From)
+    }
+}
+
+// SetPeerEventChannel sets where join and leave events are sent, for
+// frontends that need more than the system notices
+func (cr *ChatRoom) SetPeerEventChannel(ch chan<- PeerEvent) {
+    cr.peerEvents = ch
+}
+
+func (cr *ChatRoom) peerEvent(ev PeerEvent) {
+    if cr.peerEvents == nil {
+        return
+    }
+    select {
+    case cr.peerEvents <- ev:
+    default:
+        cr.log.Warn("peer event channel full, dropping event", "peer", ev.Name)
+    }
+}
+
+func (cr *ChatRoom) systemf(format string, args ...any) {
+    cr.notify(tui.Message{From: "System", Text: fmt.Sprintf(format, args...)})
+}
+
+func (cr *ChatRoom) AddPeer(name string, conn net.Conn) *Peer {
+    return cr.addPeer(name, Envelope{}, conn)
+}
+
+// addPeer adds a peer with the node ID and key from its hello, if any
+func (cr *ChatRoom) addPeer(name string, hello Envelope, conn net.Conn) *Peer {
     cr.mu.Lock()
     defer cr.mu.Unlock()
     
-    peer := Peer{
+    peer := &Peer{
         uuid: uuid.NewString(),
         Name: name,
+        NodeID: hello.NodeID,
+        key: hello.Key,
         Conn: conn,
+        control: make(chan []byte, 64),
+        chat: make(chan []byte, 64),
+        done: make(chan struct{}),
+        idle: make(chan struct{}),
     }
+    close(peer.idle)
+    peer.log = cr.log.With("peer", name, "addr", conn.RemoteAddr())
     cr.Peers = append(cr.Peers, peer)
+    return peer
 }
 
 // Modified PeerHandler to send messages through channel instead of directly to TUI
 func PeerHandler(ctx context.Context, conn net.Conn, name string, room *ChatRoom) {
     defer conn.Close()
+    br := bufio.NewReader(conn)
 
-    // Send and receive name for initial handshake
-    if err := SendMsg(conn, name); err != nil {
-        fmt.Println(util.Error, "Failed to send name:", err)
-        return
+    // Send and receive name for initial handshake. The write runs alongside
+    // the read so unbuffered transports such as net.Pipe work too.
+    nonce := rand.Uint64() | 1
+    sent := make(chan error, 1)
+    greeting := Envelope{Type: TypeHello, From: name, Nonce: nonce}
+    var challenge []byte
+    if room.identity != nil {
+        challenge = newChallenge()
+        greeting.NodeID, greeting.Key, greeting.Challenge = room.identity.ID, room.identity.PublicKey(), challenge
     }
+    go func() {
+        sent <- WriteEnvelope(conn, greeting)
+    }()
     
-    receivedName, err := ReceiveMsg(conn)
+    hello, err := readHello(br)
     if err != nil {
-        if err.Error() == "EOF" {
-        } else {
-            fmt.Println(util.Error, "Failed to receive name:", err)
+        if !errors.Is(err, io.EOF) {
+            room.log.Error("failed to receive hello", "addr", conn.RemoteAddr(), "err", err)
+        }
+        return
+    }
+    if err := <-sent; err != nil {
+        room.log.Error("failed to send hello", "addr", conn.RemoteAddr(), "err", err)
+        return
+    }
+    if hello.Type != TypeHello || strings.TrimSpace(hello.From) == "" {
+        room.log.Error("invalid handshake", "addr", conn.RemoteAddr(), "type", hello.Type)
+        return
+    }
+    if hello.Nonce == nonce {
+        room.log.Error("handshake nonce collision", "addr", conn.RemoteAddr())
+        return
+    }
+    if hello.NodeID != "" && hello.NodeID != NodeID(hello.Key) {
+        room.log.Error("handshake node ID does not match its key", "addr", conn.RemoteAddr(), "node", hello.NodeID)
+        return
+    }
+    switch {
+    case hello.NodeID == "":
+    case room.identity == nil:
+        // Without a key of our own the peer's cannot be checked, so its
+        // identity is not trusted
+        hello.NodeID, hello.Key = "", nil
+    default:
+        // Messages kept for a node go to whoever presents its ID, so the
+        // peer must show it holds the key behind it
+        if err := room.identity.exchangeProofs(conn, br, hello, challenge); err != nil {
+            room.log.Error("handshake proof failed", "addr", conn.RemoteAddr(), "node", hello.NodeID, "err", err)
+            return
         }
+    }
+    if room.blocked(hello.NodeID, conn.RemoteAddr()) {
+        room.log.Info("refusing blocked peer", "addr", conn.RemoteAddr(), "node", hello.NodeID, "name", hello.From)
         return
     }
+    receivedName := strings.TrimSpace(hello.From)
+
+    // Everything after the hello is multiplexed
+    sess := NewSession(conn, br, nonce > hello.Nonce)
+    defer sess.Close()
 
     // Add peer to chat room
-    room.AddPeer(receivedName, conn)
+    peer := room.addPeer(receivedName, hello, conn)
+    peer.attach(sess)
+    peer.log.Info("peer connected")
+    go room.acceptStreams(peer)
 
     // Send join notification to TUI through channel
-    if room.tuiMsgChan != nil {
-        select {
-        case room.tuiMsgChan <- tui.Message{From: "System", Text: fmt.Sprintf("%s joined the chat", receivedName)}:
-        default:
-            fmt.Println(util.Warning, "TUI message channel full, dropping join notification")
+    room.systemf("%s joined the chat", receivedName)
+    room.peerEvent(PeerEvent{Name: receivedName, Addr: conn.RemoteAddr().String(), Joined: true})
+    room.notify(tui.Message{Kind: tui.KindPresence, From: receivedName, Text: StatusOnline})
+    room.runJoin(peer)
+    room.greetPresence(peer, name)
+    room.meet(peer)
+
+    // Pick up any transfers to this peer that were cut off by a disconnect
+    room.resumeTransfers(peer)
+
+    leave := func() {
+        if room.FindPeerByConn(conn) != nil {
+            room.RemovePeer(peer.uuid)
+            // Send leave notification to TUI through channel
+            room.systemf("%s left the chat", receivedName)
+            room.peerEvent(PeerEvent{Name: receivedName, Addr: conn.RemoteAddr().String()})
+            room.notify(tui.Message{Kind: tui.KindPresence, From: receivedName, Text: StatusOffline})
+            room.failUnacked(peer)
+            room.runLeave(peer)
         }
     }
 
     // Handle incoming messages with proper context handling
-    messageChan := make(chan string, 1)
+    messageChan := make(chan Envelope, 1)
     errorChan := make(chan error, 1)
     
-    // Start a goroutine to read messages
-    go func() {
-        defer close(messageChan)
-        defer close(errorChan)
-        
-        for {
-            msg, err := ReceiveMsg(conn)
-            if err != nil {
-                if err.Error() == "EOF" {
-                    errorChan <- fmt.Errorf("connection closed by peer")
-                } else {
-                    errorChan <- err
+    // Start a goroutine per stream to read messages. messageChan is closed
+    // once all of them have finished, so frames that arrived before a
+    // disconnect are still dispatched.
+    var readers sync.WaitGroup
+    for _, id := range []uint32{StreamControl, StreamChat, StreamBulk} {
+        st := sess.Stream(id)
+        readers.Add(1)
+        go func() {
+            defer readers.Done()
+            sc := newFrameScanner(st)
+            for {
+                env, err := ReadEnvelope(sc)
+                if err != nil {
+                    if errors.Is(err, io.EOF) {
+                        err = fmt.Errorf("connection closed by peer")
+                    }
+                    select {
+                    case errorChan <- err:
+                    default:
+                    }
+                    // Take the other streams down too; they still drain
+                    // what they have buffered
+                    sess.Close()
+                    return
+                }
+                select {
+                case messageChan <- env:
+                case <-ctx.Done():
+                    return
                 }
-                return
             }
-            messageChan <- msg
-        }
+        }()
+    }
+    go func() {
+        readers.Wait()
+        close(messageChan)
     }()
     
     // Handle messages and context cancellation
     for {
         select {
         case <-ctx.Done():
-            pe := room.FindPeerByConn(conn)
-            if pe != nil {
-                room.RemovePeer(pe.uuid)
-                // Send leave notification to TUI through channel
-                if room.tuiMsgChan != nil {
-                    select {
-                    case room.tuiMsgChan <- tui.Message{From: "System", Text: fmt.Sprintf("%s left the chat", receivedName)}:
-                    default:
-                        fmt.Println(util.Warning, "TUI message channel full, dropping leave notification")
-                    }
-                }
-            }
+            leave()
             return
-        case msg := <-messageChan:
-            if msg == "" {
+        case env, ok := <-messageChan:
+            if ok {
+                room.dispatch(peer, env)
                 continue
             }
-            // Clean the message
-            msg = strings.TrimSpace(msg)
-            if msg != "" {
-                // Parse the message to extract sender and content
-                // Messages are formatted as "Sender: content"
-                parts := strings.SplitN(msg, ":", 2)
-                var sender, content string
-                if len(parts) == 2 {
-                    sender = strings.TrimSpace(parts[0])
-                    content = strings.TrimSpace(parts[1])
-                } else {
-                    // Fallback if message doesn't have expected format
-                    sender = receivedName
-                    content = msg
-                }
-                
-                // Send message to TUI through channel
-                if room.tuiMsgChan != nil {
-                    select {
-                    case room.tuiMsgChan <- tui.Message{From: sender, Text: content}:
-                    default:
-                        fmt.Println(util.Warning, "TUI message channel full, dropping message from", sender)
-                    }
-                }
-            }
-        case err := <-errorChan:
-            if err != nil {
-                if err.Error() == "connection closed by peer" {
-                } else {
-                    fmt.Println(util.Error, "Connection error:", err)
-                }
-                peer := room.FindPeerByConn(conn)
-                if peer != nil {
-                    room.RemovePeer(peer.uuid)
-                    // Send leave notification to TUI through channel
-                    if room.tuiMsgChan != nil {
-                        select {
-                        case room.tuiMsgChan <- tui.Message{From: "System", Text: fmt.Sprintf("%s left the chat", receivedName)}:
-                        default:
-                            fmt.Println(util.Warning, "TUI message channel full, dropping leave notification")
-                        }
-                    }
+            select {
+            case err := <-errorChan:
+                if err.Error() != "connection closed by peer" {
+                    peer.log.Error("connection error", "err", err)
                 }
-                return
+            default:
             }
+            leave()
+            return
+        }
+    }
+}
+
+// dispatch routes a frame received from peer to the right handler
+func (cr *ChatRoom) dispatch(peer *Peer, env Envelope) {
+    // Hooks, auto-replies among them, never see ignored or muted peers;
+    // the handlers below still hide and ack what they send
+    if !cr.hidden(peer.Name, peer.NodeID, strings.TrimSpace(env.From)) && !cr.runIncoming(peer, &env) {
+        return
+    }
+    switch env.Type {
+    case TypeChat:
+        cr.receiveChat(peer.Name, peer.NodeID, env)
+        if env.ID != "" {
+            peer.Send(NewAck(env.ID))
         }
+    case TypeEdit, TypeDelete:
+        cr.handleChange(peer, env)
+    case TypeReact, TypeUnreact:
+        cr.handleReaction(peer, env)
+    case TypeAck, TypeRead:
+        cr.handleReceipt(peer, env)
+    case TypeHold:
+        cr.handleHold(peer, env)
+    case TypeForward:
+        cr.handleForward(peer, env)
+    case TypePresence:
+        cr.handlePresence(peer, env)
+    case TypeTyping:
+        cr.handleTyping(peer, env)
+    case TypeFileOffer, TypeFileAccept, TypeFileDecline, TypeFileChunk, TypeFileDone:
+        cr.handleTransfer(peer, env)
+    default:
+        peer.log.Warn("ignoring unknown frame type", "type", env.Type)
+    }
+}
+
+// receiveChat shows a chat message that came from the peer named author
+// with node ID node, directly or held by another node. A message that
+// arrives both ways is only shown once, and one from an ignored or muted
+// peer not at all.
+func (cr *ChatRoom) receiveChat(author, node string, env Envelope) {
+    // Shown as sent; a pasted block keeps its indentation and line breaks
+    text := env.Text
+    if strings.TrimSpace(text) == "" {
+        return
+    }
+    if env.ID != "" && cr.authors.has(env.ID) {
+        return
+    }
+    sender := strings.TrimSpace(env.From)
+    if sender == "" {
+        sender = author
+    }
+    room := env.Room
+    if room == DefaultRoom {
+        room = ""
     }
+    cr.authors.add(env.ID, author)
+    if cr.hidden(author, node, sender) {
+        return
+    }
+    cr.notify(tui.Message{From: sender, Text: text, ID: env.ID, Room: room, ReplyTo: env.ReplyTo, Annotations: env.Annotations})
+}
+
+// attach starts writing the peer's queued frames to its mux session
+func (p *Peer) attach(sess *Session) {
+    p.mu.Lock()
+    p.session = sess
+    p.mu.Unlock()
+
+    control := sess.Stream(StreamControl)
+    chat := sess.Stream(StreamChat)
+    control.SetPriority(true)
+    chat.SetPriority(true)
+    go p.writeLoop(control, p.control)
+    go p.writeLoop(chat, p.chat)
 }
 
-func SendMsg(conn net.Conn, msg string) error {
-    msg = strings.TrimSpace(msg) + "\n"
-    _, err := conn.Write([]byte(msg))
+// OpenStream opens a new logical stream to the peer. The peer's room hands
+// it to the handler registered for proto with HandleStream.
+func (p *Peer) OpenStream(proto string) (*Stream, error) {
+    p.mu.Lock()
+    sess := p.session
+    p.mu.Unlock()
+    if sess == nil {
+        return nil, errPeerClosed
+    }
+    return sess.Open(proto)
+}
+
+// Send queues env for delivery to the peer. If the queue is full it waits
+// up to sendWait for the writer to catch up, then fails with errQueueFull,
+// so a burst is paced rather than dropped.
+func (p *Peer) Send(env Envelope) error {
+    b, err := encodeEnvelope(env)
     if err != nil {
-        fmt.Println(util.Error, "Failed to send message:", err)
         return err
     }
+    // Changes to chat share its stream so they never overtake the message
+    // they refer to
+    queue := p.control
+    switch env.Type {
+    case TypeChat, TypeEdit, TypeDelete, TypeReact, TypeUnreact:
+        queue = p.chat
+    }
+    select {
+    case <-p.done:
+        return errPeerClosed
+    default:
+    }
+    p.track(1)
+    select {
+    case queue <- b:
+        return nil
+    case <-p.done:
+        p.track(-1)
+        return errPeerClosed
+    default:
+    }
+    t := time.NewTimer(sendWait)
+    defer t.Stop()
+    select {
+    case queue <- b:
+        return nil
+    case <-p.done:
+        p.track(-1)
+        return errPeerClosed
+    case <-t.C:
+        p.track(-1)
+        return errQueueFull
+    }
+}
+
+// track counts frames between Send and writeLoop so Flush can wait for them
+func (p *Peer) track(delta int) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if p.pending == 0 && delta > 0 {
+        p.idle = make(chan struct{})
+    }
+    p.pending += delta
+    if p.pending == 0 {
+        close(p.idle)
+    }
+}
+
+// Flush waits until every frame queued with Send has been written, the
+// peer has gone away or ctx is done
+func (p *Peer) Flush(ctx context.Context) error {
+    p.mu.Lock()
+    idle := p.idle
+    p.mu.Unlock()
+    select {
+    case <-idle:
+    case <-p.done:
+    case <-ctx.Done():
+        return ctx.Err()
+    }
     return nil
 }
 
-func ReceiveMsg(conn net.Conn) (string, error) {
-    buffer := make([]byte, 1024)
-    n, err := conn.Read(buffer)
+// sendBulk writes a frame to the bulk stream, blocking while the peer's
+// flow-control window is full. This is what paces a file transfer.
+func (p *Peer) sendBulk(env Envelope) error {
+    b, err := encodeEnvelope(env)
     if err != nil {
-        if err.Error() == "EOF" {
-            return "", err
+        return err
+    }
+    p.mu.Lock()
+    sess := p.session
+    p.mu.Unlock()
+    if sess == nil {
+        return errPeerClosed
+    }
+    _, err = sess.Stream(StreamBulk).Write(b)
+    return err
+}
+
+func (p *Peer) writeLoop(st *Stream, queue <-chan []byte) {
+    for {
+        select {
+        case <-p.done:
+            return
+        case frame := <-queue:
+            _, err := st.Write(frame)
+            p.track(-1)
+            if err != nil {
+                if !errors.Is(err, io.EOF) && !errors.Is(err, ErrSessionClosed) {
+                    p.log.Error("failed to send message", "err", err)
+                }
+                return
+            }
         }
-        return "", fmt.Errorf("read error: %w", err)
     }
-    if n == 0 {
-        return "", fmt.Errorf("connection closed")
+}
+
+func (p *Peer) close() {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if !p.closed {
+        p.closed = true
+        close(p.done)
+        if p.session != nil {
+            p.session.Close()
+        }
     }
-    msg := string(buffer[:n])
-    return msg, nil
 }
 
-func Broadcast(room *ChatRoom, msg string) {
+// Broadcast runs env through the outgoing hooks and sends it to every
+// peer. It returns the envelope as sent, and false if a hook dropped it.
+// For chat messages the outcome with each peer is kept for Receipts.
+func Broadcast(room *ChatRoom, env Envelope) (Envelope, bool) {
+    if !room.runOutgoing(&env) {
+        return env, false
+    }
+
     room.mu.Lock()
-    defer room.mu.Unlock()
+    peers := append([]*Peer(nil), room.Peers...)
+    room.mu.Unlock()
 
-    for _, p := range room.Peers {
-        p.mu.Lock()
-        _ = SendMsg(p.Conn, msg)
-        p.mu.Unlock()
+    states := make(map[string]peerReceipt, len(peers))
+    for _, p := range peers {
+        state := ReceiptSent
+        if err := p.Send(env); err != nil {
+            p.log.Warn("failed to queue message", "type", env.Type, "id", env.ID, "err", err)
+            state = ReceiptFailed
+        }
+        states[p.receiptKey()] = peerReceipt{name: p.Name, state: state}
+    }
+    if env.Type == TypeChat {
+        room.queueOffline(env, peers, states)
+        room.receipts.add(env, states)
+    }
+    return env, true
+}
+
+// SendTo runs env through the outgoing hooks and sends it to peer alone.
+// A chat message is also shown locally as a notice, since the node sent it
+// without the user typing it.
+func SendTo(room *ChatRoom, peer *Peer, env Envelope) (Envelope, bool) {
+    if !room.runOutgoing(&env) {
+        return env, false
     }
+    if err := peer.Send(env); err != nil {
+        peer.log.Warn("failed to queue message", "type", env.Type, "id", env.ID, "err", err)
+        return env, false
+    }
+    if env.Type == TypeChat {
+        room.notify(tui.Message{From: "System", Text: "To " + peer.Name + ": " + env.Text, Room: env.Room})
+    }
+    return env, true
+}
+
+// Flush waits until messages queued for every peer have been written
+func (cr *ChatRoom) Flush(ctx context.Context) error {
+    cr.mu.Lock()
+    peers := append([]*Peer(nil), cr.Peers...)
+    cr.mu.Unlock()
+
+    for _, p := range peers {
+        if err := p.Flush(ctx); err != nil {
+            return err
+        }
+    }
+    return nil
 }
 
 func (room *ChatRoom) RemovePeer(uuid string) {
@@ -216,22 +627,77 @@ func (room *ChatRoom) RemovePeer(uuid string) {
 
     for i, p := range room.Peers {
         if p.uuid == uuid {
+            p.close()
             room.Peers = append(room.Peers[:i], room.Peers[i+1:]...)
             return
         }
     }
-    fmt.Println(util.Warning, "Peer with UUID", uuid, "not found")
+    room.log.Warn("peer not found", "uuid", uuid)
 }
 
 func (cr *ChatRoom) FindPeerByConn(conn net.Conn) *Peer {
     cr.mu.Lock()
     defer cr.mu.Unlock()
     
-    for i := range cr.Peers {
-        if cr.Peers[i].Conn == conn {
-            return &cr.Peers[i]
+    for _, p := range cr.Peers {
+        if p.Conn == conn {
+            return p
+        }
+    }
+    return nil
+}
+
+func (cr *ChatRoom) FindPeerByName(name string) *Peer {
+    cr.mu.Lock()
+    defer cr.mu.Unlock()
+
+    for _, p := range cr.Peers {
+        if p.Name == name {
+            return p
+        }
+    }
+    return nil
+}
+
+// PeerInfo describes a connected peer
+type PeerInfo struct {
+    Name string `json:"name"`
+    ID string `json:"id,omitempty"` // node ID, for peers that present one
+    Addr string `json:"addr"`
+    Status string `json:"status"` // online, away or busy
+    Note string `json:"note,omitempty"` // set with the status, e.g. "back at 3"
+}
+
+// ListPeers returns the currently connected peers
+func (cr *ChatRoom) ListPeers() []PeerInfo {
+    cr.mu.Lock()
+    defer cr.mu.Unlock()
+
+    out := make([]PeerInfo, 0, len(cr.Peers))
+    for _, p := range cr.Peers {
+        info := PeerInfo{Name: p.Name, ID: p.NodeID, Status: StatusOnline}
+        if addr := p.Conn.RemoteAddr(); addr != nil {
+            info.Addr = addr.String()
         }
+        p.mu.Lock()
+        if p.status != "" {
+            info.Status, info.Note = p.status, p.statusNote
+        }
+        p.mu.Unlock()
+        out = append(out, info)
+    }
+    return out
+}
+
+// Disconnect drops the connection to the named peer. Peers see it as the
+// node leaving.
+func (cr *ChatRoom) Disconnect(name string) error {
+    p := cr.FindPeerByName(name)
+    if p == nil {
+        return fmt.Errorf("no peer named %q", name)
     }
+    p.close()
+    p.Conn.Close()
     return nil
 }
 
@@ -241,8 +707,10 @@ func (cr *ChatRoom) Shutdown() {
     
     
     for _, peer := range cr.Peers {
+        peer.close()
         peer.Conn.Close()
     }
     
     cr.Peers = cr.Peers[:0]
+    cr.files.closeAll()
 }
@@ -1,6 +1,11 @@
 package chat
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 	"net"
@@ -87,3 +92,575 @@ func TestChatRoomRemovePeer(t *testing.T) {
 		t.Errorf("Expected 0 peers after removal, got %d", len(room.Peers))
 	}
 }
+
+func TestChatRoomFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 100)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	for i := 0; i < 50; i++ {
+		Broadcast(alice, NewChat("alice", fmt.Sprintf("line %d", i)))
+	}
+	if err := alice.Flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Everything flushed must arrive even though alice hangs up at once
+	alice.Shutdown()
+
+	for i := 0; i < 50; i++ {
+		want := fmt.Sprintf("line %d", i)
+		waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Text == want })
+	}
+}
+
+func TestChatRoomCarriesRoom(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 10)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	env := NewChat("ci", "build passed")
+	env.Room = "builds"
+	env.ReplyTo = "build-42"
+	Broadcast(alice, env)
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "ci" })
+	if msg.Room != "builds" || msg.Text != "build passed" || msg.ReplyTo != "build-42" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	env = NewChat("alice", "hi")
+	env.Room = DefaultRoom
+	Broadcast(alice, env)
+	if msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" && m.Kind == tui.KindText }); msg.Room != "" {
+		t.Errorf("Expected the default room to be left empty, got %q", msg.Room)
+	}
+
+	// Line breaks and indentation in pasted text arrive untouched
+	trace := "panic: boom\r\n\tmain.go:12\n\n  \"quoted\"\n"
+	Broadcast(alice, NewChat("carol", trace))
+	if msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "carol" }); msg.Text != trace {
+		t.Errorf("Multi-line text changed on the wire: %q", msg.Text)
+	}
+}
+
+func TestChatRoomEditAndDelete(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob, mallory := NewRoom(), NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	defer mallory.Shutdown()
+	bobMsgs := make(chan tui.Message, 10)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	connectRooms(t, ctx, mallory, "mallory", bob, "bob")
+
+	env := NewChat("alice", "helo")
+	env.Room = "dev"
+	Broadcast(alice, env)
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" && m.Kind == tui.KindText })
+	if msg.ID != env.ID {
+		t.Fatalf("Expected message ID %s, got %q", env.ID, msg.ID)
+	}
+
+	// Only the peer that sent a message may change it
+	mallory.FindPeerByName("bob").Send(NewDelete("alice", env.ID))
+	mallory.FindPeerByName("bob").Send(NewChat("mallory", "done"))
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From != "System" })
+	if msg.Kind != tui.KindText || msg.From != "mallory" {
+		t.Errorf("Expected the forged delete dropped, got %+v", msg)
+	}
+
+	edit := NewEdit("alice", env.ID, "hello")
+	edit.Room = "dev"
+	Broadcast(alice, edit)
+	Broadcast(alice, NewDelete("alice", env.ID))
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" })
+	if msg.Kind != tui.KindEdit || msg.ID != env.ID || msg.Text != "hello" || msg.Room != "dev" {
+		t.Errorf("Unexpected edit %+v", msg)
+	}
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" })
+	if msg.Kind != tui.KindDelete || msg.ID != env.ID {
+		t.Errorf("Unexpected delete %+v", msg)
+	}
+}
+
+func TestChatRoomReactions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 10)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	Broadcast(alice, NewReaction("alice", "m1", ":+1:", false))
+	Broadcast(alice, NewReaction("alice", "m1", "not an emoji", false))
+	// Reactions count for the peer that sent them, whatever name they claim
+	Broadcast(alice, NewReaction("carol", "m1", "👍", true))
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" && m.Kind != tui.KindPresence })
+	if msg.Kind != tui.KindReact || msg.ID != "m1" || msg.Text != "👍" {
+		t.Errorf("Expected the short code sent as an emoji, got %+v", msg)
+	}
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" })
+	if msg.Kind != tui.KindUnreact || msg.Text != "👍" {
+		t.Errorf("Expected the malformed reaction dropped and the removal passed on, got %+v", msg)
+	}
+}
+
+func TestChatRoomPresence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 10)
+	bob.SetTUIMessageChannel(bobMsgs)
+
+	// Set before connecting, so bob hears it in the greeting
+	alice.SetPresence("alice", StatusAway, "  back\tat 3 ")
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindPresence && m.Text != StatusOnline })
+	if msg.From != "alice" || msg.Text != "away: back at 3" {
+		t.Errorf("Expected alice's status in the greeting, got %+v", msg)
+	}
+	if peers := bob.ListPeers(); len(peers) != 1 || peers[0].Status != StatusAway || peers[0].Note != "back at 3" {
+		t.Errorf("Expected the status in the peer list, got %+v", peers)
+	}
+
+	alice.SetPresence("alice", "asleep", "")
+	Broadcast(alice, NewTyping("alice", "ops"))
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindPresence })
+	if msg.Text != StatusOnline {
+		t.Errorf("Expected an unknown status taken as online, got %+v", msg)
+	}
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindTyping })
+	if msg.From != "alice" || msg.Room != "ops" {
+		t.Errorf("Unexpected typing notice %+v", msg)
+	}
+
+	alice.Shutdown()
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindPresence })
+	if msg.Text != StatusOffline {
+		t.Errorf("Expected alice offline after leaving, got %+v", msg)
+	}
+}
+
+func TestChatRoomReceipts(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	aliceMsgs := make(chan tui.Message, 10)
+	alice.SetTUIMessageChannel(aliceMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	env, _ := Broadcast(alice, NewChat("alice", "deploying now"))
+	msg := waitFor(t, aliceMsgs, func(m tui.Message) bool { return m.Kind == tui.KindReceipt })
+	if msg.ID != env.ID || msg.From != "bob" || msg.Text != ReceiptDelivered {
+		t.Errorf("Expected bob's delivery ack, got %+v", msg)
+	}
+	bob.MarkRead(env.ID)
+	msg = waitFor(t, aliceMsgs, func(m tui.Message) bool { return m.Kind == tui.KindReceipt })
+	if msg.Text != ReceiptRead {
+		t.Errorf("Expected bob's read receipt, got %+v", msg)
+	}
+	if receipts, _ := alice.Receipts(env.ID); receipts["bob"] != ReceiptRead {
+		t.Errorf("Unexpected receipts %v", receipts)
+	}
+
+	// A message bob never acknowledged fails when he leaves
+	lost := NewChat("alice", "are you there?")
+	lost.Room = "ops"
+	alice.receipts.add(lost, map[string]peerReceipt{alice.FindPeerByName("bob").receiptKey(): {name: "bob", state: ReceiptSent}})
+	bob.Shutdown()
+	msg = waitFor(t, aliceMsgs, func(m tui.Message) bool { return m.Kind == tui.KindReceipt })
+	if msg.ID != lost.ID || msg.Text != ReceiptFailed || msg.Room != "ops" {
+		t.Errorf("Expected the unacknowledged message failed, got %+v", msg)
+	}
+	if err := alice.Retry(lost.ID); err == nil || !strings.Contains(err.Error(), "bob") {
+		t.Errorf("Expected retry to fail while bob is away, got %v", err)
+	}
+}
+
+// newOfflineRoom returns a room with an identity that keeps messages for
+// absent peers
+func newOfflineRoom(t *testing.T) *ChatRoom {
+	t.Helper()
+	dir := t.TempDir()
+	id, err := LoadIdentity(dir + "/node_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	room := NewRoom()
+	room.SetIdentity(id)
+	if err := room.SetOfflineStore(dir, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	return room
+}
+
+func disconnectRooms(t *testing.T, a *ChatRoom, aName string, b *ChatRoom, bName string) {
+	t.Helper()
+	a.Disconnect(bName)
+	deadline := time.Now().Add(2 * time.Second)
+	for a.FindPeerByName(bName) != nil || b.FindPeerByName(aName) != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestChatRoomOfflineQueue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := newOfflineRoom(t), newOfflineRoom(t)
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	aliceMsgs, bobMsgs := make(chan tui.Message, 20), make(chan tui.Message, 20)
+	alice.SetTUIMessageChannel(aliceMsgs)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	if p := alice.FindPeerByName("bob"); p.NodeID != bob.identity.ID {
+		t.Fatalf("Expected bob's node ID from the handshake, got %q", p.NodeID)
+	}
+	disconnectRooms(t, alice, "alice", bob, "bob")
+
+	env, _ := Broadcast(alice, NewChat("alice", "while you were out"))
+	if receipts, _ := alice.Receipts(env.ID); receipts["bob"] != ReceiptQueued {
+		t.Errorf("Expected the message queued for bob, got %v", receipts)
+	}
+
+	// Queued messages survive a restart
+	reloaded, err := loadOfflineStore(alice.offline.dir, time.Hour)
+	if err != nil || len(reloaded.outbox) != 1 || reloaded.outbox[0].To != bob.identity.ID {
+		t.Fatalf("Expected the queue on disk, got %+v, %v", reloaded, err)
+	}
+
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindText && m.From == "alice" })
+	if msg.ID != env.ID || msg.Text != "while you were out" {
+		t.Errorf("Unexpected queued message %+v", msg)
+	}
+	msg = waitFor(t, aliceMsgs, func(m tui.Message) bool { return m.Kind == tui.KindReceipt && m.Text == ReceiptDelivered })
+	if msg.ID != env.ID || msg.From != "bob" {
+		t.Errorf("Unexpected receipt %+v", msg)
+	}
+}
+
+func TestReceiptsKeepPeersWithOneNameApart(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob, otherBob := newOfflineRoom(t), newOfflineRoom(t), newOfflineRoom(t)
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	defer otherBob.Shutdown()
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	connectRooms(t, ctx, alice, "alice", otherBob, "bob")
+
+	env, _ := Broadcast(alice, NewChat("alice", "which bob?"))
+	receipts, _ := alice.Receipts(env.ID)
+	if len(receipts) != 2 {
+		t.Fatalf("Expected a receipt per node, got %v", receipts)
+	}
+	for _, id := range []string{bob.identity.ID, otherBob.identity.ID} {
+		if state := receipts["bob ("+id[:8]+")"]; state != ReceiptSent && state != ReceiptDelivered {
+			t.Errorf("Expected bob %s told apart, got %v", id, receipts)
+		}
+	}
+}
+
+func TestChatRoomForget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := newOfflineRoom(t), newOfflineRoom(t)
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	if err := alice.Forget("bob"); err == nil {
+		t.Error("Expected a connected peer not to be forgotten")
+	}
+	disconnectRooms(t, alice, "alice", bob, "bob")
+
+	env, _ := Broadcast(alice, NewChat("alice", "still there?"))
+	if err := alice.RunCommand("/forget bob"); err != nil {
+		t.Fatal(err)
+	}
+	if receipts, _ := alice.Receipts(env.ID); receipts["bob"] != ReceiptFailed {
+		t.Errorf("Expected the queued message failed, got %v", receipts)
+	}
+	if len(alice.offline.outbox) != 0 {
+		t.Errorf("Expected the queue for bob dropped, got %+v", alice.offline.outbox)
+	}
+	env, _ = Broadcast(alice, NewChat("alice", "anyone?"))
+	if receipts, _ := alice.Receipts(env.ID); len(receipts) != 0 {
+		t.Errorf("Expected nothing queued for a forgotten node, got %v", receipts)
+	}
+	if err := alice.Forget("bob"); err == nil {
+		t.Error("Expected an error forgetting an unknown node")
+	}
+}
+
+func TestOfflineStoreForgetsStaleNodes(t *testing.T) {
+	s, err := loadOfflineStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.meet("recent", "bob", nil)
+	s.meet("stale", "carol", nil)
+	s.known["stale"] = knownPeer{Name: "carol", Seen: time.Now().Add(-forgetAfter - time.Hour)}
+	absent, err := s.absent(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := absent["recent"]; !ok || len(absent) != 1 {
+		t.Errorf("Expected only the recent node, got %v", absent)
+	}
+	if _, ok := s.knownPeer("stale"); ok {
+		t.Error("Expected the stale node forgotten")
+	}
+}
+
+func TestChatRoomHeldForAbsentPeer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob, carol := newOfflineRoom(t), newOfflineRoom(t), newOfflineRoom(t)
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	defer carol.Shutdown()
+	bobMsgs := make(chan tui.Message, 20)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	disconnectRooms(t, alice, "alice", bob, "bob")
+
+	// Carol has never met bob, but holds alice's sealed copy for him
+	connectRooms(t, ctx, alice, "alice", carol, "carol")
+	env, _ := Broadcast(alice, NewChat("alice", "meet at 3"))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		carol.offline.mu.Lock()
+		held := len(carol.offline.held)
+		carol.offline.mu.Unlock()
+		if held == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for carol to hold the message")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if held := carol.offline.held[0].Env; bytes.Contains(held.Data, []byte("meet at 3")) {
+		t.Error("Expected the held message sealed")
+	}
+
+	connectRooms(t, ctx, carol, "carol", bob, "bob")
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindText && m.From == "alice" })
+	if msg.ID != env.ID || msg.Text != "meet at 3" {
+		t.Errorf("Unexpected forwarded message %+v", msg)
+	}
+
+	// Alice's own queue delivers it again when she reconnects, but bob
+	// only shows it once
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	Broadcast(alice, NewChat("alice", "see you"))
+	msg = waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindText && m.From == "alice" })
+	if msg.Text != "see you" {
+		t.Errorf("Expected the message shown once, got %+v", msg)
+	}
+}
+
+func TestChatRoomRefusesImpersonation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := newOfflineRoom(t), newOfflineRoom(t)
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 20)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	disconnectRooms(t, alice, "alice", bob, "bob")
+	env, _ := Broadcast(alice, NewChat("alice", "secret for bob"))
+
+	// Bob's ID and public key are in every hello he sends, but without his
+	// private key the proof cannot be made
+	conn, mallory := net.Pipe()
+	go PeerHandler(ctx, conn, "alice", alice)
+	go WriteEnvelope(mallory, Envelope{Type: TypeHello, From: "bob", Nonce: 2, NodeID: bob.identity.ID, Key: bob.identity.PublicKey(), Challenge: newChallenge()})
+	br := bufio.NewReader(mallory)
+	if _, err := readHello(br); err != nil {
+		t.Fatal(err)
+	}
+	if proof, err := readHello(br); err != nil || proof.Type != TypeProof {
+		t.Fatalf("Expected alice's proof, got %+v, %v", proof, err)
+	}
+	go WriteEnvelope(mallory, Envelope{Type: TypeProof, Data: make([]byte, 32)})
+	if _, err := readHello(br); err == nil {
+		t.Error("Expected alice to hang up on a bad proof")
+	}
+	if alice.FindPeerByName("bob") != nil {
+		t.Error("Expected the impersonator refused")
+	}
+
+	// The real bob still gets what was kept for him
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindText && m.From == "alice" })
+	if msg.ID != env.ID {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+}
+
+func TestChatRoomFilters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := newOfflineRoom(t), newOfflineRoom(t)
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 20)
+	bob.SetTUIMessageChannel(bobMsgs)
+	hook := &seenHook{texts: make(chan string, 10)}
+	bob.AddHook(hook)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	// Hidden messages are still acked, so wait for each before moving on
+	say := func(text string) {
+		env, _ := Broadcast(alice, NewChat("alice", text))
+		deadline := time.Now().Add(2 * time.Second)
+		for receipts, _ := alice.Receipts(env.ID); receipts["bob"] != ReceiptDelivered; receipts, _ = alice.Receipts(env.ID) {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timeout waiting for bob to receive %q", text)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	bob.SetFilters(Filters{Ignore: []string{"alice"}})
+	say("ignored")
+	bob.SetFilters(Filters{Mute: map[string]time.Time{alice.identity.ID: time.Now().Add(time.Hour)}})
+	say("muted")
+	bob.SetFilters(Filters{Mute: map[string]time.Time{"alice": time.Now().Add(-time.Second)}})
+	say("shown")
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindText && m.From == "alice" })
+	if msg.Text != "shown" {
+		t.Errorf("Expected ignored and muted messages hidden, got %+v", msg)
+	}
+	if text := <-hook.texts; text != "shown" {
+		t.Errorf("Expected hooks not to see ignored and muted messages, got %q", text)
+	}
+
+	// Blocking drops alice now and refuses her when she comes back
+	bob.SetFilters(Filters{Block: []string{alice.identity.ID}})
+	deadline := time.Now().Add(2 * time.Second)
+	for bob.FindPeerByName("alice") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the blocked peer to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !refused(t, ctx, alice, "alice", bob, "bob") {
+		t.Error("Expected the blocked node refused at the handshake")
+	}
+	// Peers without an identity still get in unless identities are required
+	anon := NewRoom()
+	defer anon.Shutdown()
+	if refused(t, ctx, anon, "anon", bob, "bob") {
+		t.Error("Expected a peer without an identity let in")
+	}
+	bob.SetFilters(Filters{Block: []string{alice.identity.ID}, RequireIdentity: true})
+	deadline = time.Now().Add(2 * time.Second)
+	for bob.FindPeerByName("anon") != nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for the peer without an identity to be dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !refused(t, ctx, NewRoom(), "anon", bob, "bob") {
+		t.Error("Expected a peer without an identity refused when identities are required")
+	}
+
+	// Nor is anything held for a blocked node
+	bob.handleHold(&Peer{log: bob.log}, Envelope{Type: TypeHold, NodeID: "other", To: alice.identity.ID, Data: []byte("x"), Expires: time.Now().Add(time.Hour).Unix()})
+	if len(bob.offline.held) != 0 {
+		t.Error("Expected no message held for a blocked node")
+	}
+}
+
+// seenHook passes on the text of the chat frames hooks are shown
+type seenHook struct {
+	NopHook
+	texts chan string
+}
+
+func (h *seenHook) OnIncoming(_ *Peer, env *Envelope) bool {
+	if env.Type == TypeChat {
+		h.texts <- env.Text
+	}
+	return true
+}
+
+// refused reports whether b turns a away when a connects to it
+func refused(t *testing.T, ctx context.Context, a *ChatRoom, aName string, b *ChatRoom, bName string) bool {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			PeerHandler(ctx, conn, bName, b)
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go PeerHandler(ctx, conn, aName, a)
+	time.Sleep(300 * time.Millisecond)
+	return b.FindPeerByName(aName) == nil
+}
+
+func TestChatRoomBurst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 2000)
+	bob.SetTUIMessageChannel(bobMsgs)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	// Far more than a peer's queue holds; Send waits for room instead of
+	// dropping them
+	for i := 0; i < 1000; i++ {
+		env, _ := Broadcast(alice, NewChat("alice", fmt.Sprintf("line %d", i)))
+		if receipts, _ := alice.Receipts(env.ID); receipts["bob"] == ReceiptFailed {
+			t.Fatalf("Expected line %d queued, got %v", i, receipts)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		want := fmt.Sprintf("line %d", i)
+		waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Text == want })
+	}
+}
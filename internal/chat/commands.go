@@ -0,0 +1,59 @@
+package chat
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RunCommand executes a slash command typed by the local user, e.g.
+// "/send bob ./build.log". The returned error is meant to be shown to the
+// user as-is.
+func (cr *ChatRoom) RunCommand(line string) error {
+    fields := strings.Fields(line)
+    if len(fields) == 0 {
+        return nil
+    }
+
+    switch fields[0] {
+    case "/send":
+        if len(fields) < 3 {
+            return fmt.Errorf("usage: /send <peer> <path>")
+        }
+        // The path is everything after the peer name so it may contain spaces
+        rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), fields[0]))
+        path := strings.TrimSpace(strings.TrimPrefix(rest, fields[1]))
+        return cr.SendFile(fields[1], expandHome(path))
+    case "/accept":
+        if len(fields) != 2 {
+            return fmt.Errorf("usage: /accept <id>")
+        }
+        return cr.AcceptFile(fields[1])
+    case "/decline":
+        if len(fields) != 2 {
+            return fmt.Errorf("usage: /decline <id>")
+        }
+        return cr.DeclineFile(fields[1])
+    case "/forget":
+        if len(fields) != 2 {
+            return fmt.Errorf("usage: /forget <peer>")
+        }
+        return cr.Forget(fields[1])
+    default:
+        return fmt.Errorf("unknown command %s", fields[0])
+    }
+}
+
+// expandHome replaces a leading ~ with the user's home directory, as the
+// shell would and as path completion offers it
+func expandHome(path string) string {
+    if path != "~" && !strings.HasPrefix(path, "~/") {
+        return path
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return path
+    }
+    return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
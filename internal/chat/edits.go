@@ -0,0 +1,89 @@
+package chat
+
+import (
+	"strings"
+	"sync"
+
+	"gochat/internal/tui"
+)
+
+// Message IDs remembered per room so that edits and deletes can be checked
+// against the peer that sent the original
+const maxAuthors = 1000
+
+// authorLog maps recent message IDs to the peer they came from
+type authorLog struct {
+    mu sync.Mutex
+    byID map[string]string
+    order []string // oldest first, for eviction
+}
+
+func newAuthorLog() *authorLog {
+    return &authorLog{byID: make(map[string]string)}
+}
+
+func (a *authorLog) add(id, peer string) {
+    if id == "" {
+        return
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    if _, ok := a.byID[id]; ok {
+        return
+    }
+    a.byID[id] = peer
+    a.order = append(a.order, id)
+    if len(a.order) > maxAuthors {
+        delete(a.byID, a.order[0])
+        a.order = a.order[1:]
+    }
+}
+
+// has reports whether message id has been seen
+func (a *authorLog) has(id string) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    _, ok := a.byID[id]
+    return ok
+}
+
+// author returns the peer message id came from
+func (a *authorLog) author(id string) (string, bool) {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    peer, ok := a.byID[id]
+    return peer, ok
+}
+
+// sentBy reports whether message id came from peer
+func (a *authorLog) sentBy(id, peer string) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    author, ok := a.byID[id]
+    return ok && author == peer
+}
+
+// handleChange passes on an edit or delete from peer. Only the peer that
+// sent a message may change it; anything else is dropped.
+func (cr *ChatRoom) handleChange(peer *Peer, env Envelope) {
+    if !cr.authors.sentBy(env.ID, peer.Name) {
+        peer.log.Warn("ignoring change to a message the peer did not send", "type", env.Type, "id", env.ID)
+        return
+    }
+    sender := strings.TrimSpace(env.From)
+    if sender == "" {
+        sender = peer.Name
+    }
+    room := env.Room
+    if room == DefaultRoom {
+        room = ""
+    }
+    msg := tui.Message{Kind: tui.KindDelete, ID: env.ID, From: sender, Room: room}
+    if env.Type == TypeEdit {
+        if strings.TrimSpace(env.Text) == "" {
+            return
+        }
+        msg.Kind, msg.Text, msg.Annotations = tui.KindEdit, env.Text, env.Annotations
+    }
+    cr.notify(msg)
+}
@@ -0,0 +1,164 @@
+package chat
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+
+    "github.com/google/uuid"
+)
+
+// Frame types carried in Envelope.Type
+const (
+    TypeHello = "hello"
+    TypeProof = "proof"
+    TypeChat = "chat"
+    TypeEdit = "edit"
+    TypeDelete = "delete"
+    TypeReact = "react"
+    TypeUnreact = "unreact"
+    TypePresence = "presence"
+    TypeTyping = "typing"
+    TypeAck = "ack"
+    TypeRead = "read"
+    TypeHold = "hold"
+    TypeForward = "forward"
+    TypeFileOffer = "file_offer"
+    TypeFileAccept = "file_accept"
+    TypeFileDecline = "file_decline"
+    TypeFileChunk = "file_chunk"
+    TypeFileDone = "file_done"
+)
+
+// Largest frame we are willing to read from a peer. File chunks are well
+// below this even after base64 encoding.
+const maxFrameSize = 1 << 20
+
+// Envelope is a single frame on the wire. Frames are JSON objects separated
+// by newlines, so message text may safely contain any characters. After the
+// hello exchange frames travel on the mux streams of a Session rather than
+// on the raw connection.
+type Envelope struct {
+    Type string `json:"type"`
+    ID string `json:"id,omitempty"` // for edit, delete, reactions and receipts, the message concerned
+    From string `json:"from,omitempty"`
+    Text string `json:"text,omitempty"`
+    Nonce uint64 `json:"nonce,omitempty"` // hello only; decides mux stream ID parity
+    NodeID string `json:"node_id,omitempty"` // hello, hold and forward; the node that sent it, or sealed it
+    Key []byte `json:"key,omitempty"` // hello only; the node's public key
+    Challenge []byte `json:"challenge,omitempty"` // hello only; answered by the peer's proof
+    To string `json:"to,omitempty"` // hold and forward; the node a sealed message in Data is for
+    Expires int64 `json:"expires,omitempty"` // hold and forward; Unix time after which nobody need keep it
+    Room string `json:"room,omitempty"` // chat, changes to it and typing; empty for the default room
+    ReplyTo string `json:"reply_to,omitempty"` // chat only; ID of the message answered
+    Status string `json:"status,omitempty"` // presence only; Text is an optional note
+    Annotations map[string]string `json:"annotations,omitempty"` // notes added by hooks
+
+    // File transfer fields
+    Name string `json:"name,omitempty"`
+    Size int64 `json:"size,omitempty"`
+    SHA256 string `json:"sha256,omitempty"`
+    Offset int64 `json:"offset,omitempty"`
+    Data []byte `json:"data,omitempty"` // file chunks, and sealed messages for hold and forward
+}
+
+// DefaultRoom is where chat goes when no room is named
+const DefaultRoom = "general"
+
+var roomName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// ValidRoom reports whether name is usable as a room: up to 32 lowercase
+// letters, digits, - and _
+func ValidRoom(name string) bool {
+    return roomName.MatchString(name)
+}
+
+// NewChat builds a chat envelope with a fresh message ID
+func NewChat(from, text string) Envelope {
+    return Envelope{
+        Type: TypeChat,
+        ID: uuid.NewString(),
+        From: from,
+        Text: text,
+    }
+}
+
+// NewEdit builds an envelope replacing the text of message id
+func NewEdit(from, id, text string) Envelope {
+    return Envelope{Type: TypeEdit, ID: id, From: from, Text: text}
+}
+
+// NewDelete builds an envelope retracting message id
+func NewDelete(from, id string) Envelope {
+    return Envelope{Type: TypeDelete, ID: id, From: from}
+}
+
+// NewReaction builds an envelope adding emoji to message id, or taking it
+// back if remove is set
+func NewReaction(from, id, emoji string, remove bool) Envelope {
+    env := Envelope{Type: TypeReact, ID: id, From: from, Text: emoji}
+    if remove {
+        env.Type = TypeUnreact
+    }
+    return env
+}
+
+func encodeEnvelope(env Envelope) ([]byte, error) {
+    b, err := json.Marshal(env)
+    if err != nil {
+        return nil, err
+    }
+    return append(b, '\n'), nil
+}
+
+func WriteEnvelope(w io.Writer, env Envelope) error {
+    b, err := encodeEnvelope(env)
+    if err != nil {
+        return err
+    }
+    _, err = w.Write(b)
+    return err
+}
+
+func newFrameScanner(r io.Reader) *bufio.Scanner {
+    sc := bufio.NewScanner(r)
+    sc.Buffer(make([]byte, 0, 4096), maxFrameSize)
+    return sc
+}
+
+// readHello reads the handshake frame directly off the connection. It is
+// line-limited by the reader's buffer size, and leaves anything after the
+// frame in br for the mux session.
+func readHello(br *bufio.Reader) (Envelope, error) {
+    var env Envelope
+    line, err := br.ReadSlice('\n')
+    if err != nil {
+        if errors.Is(err, io.EOF) {
+            return env, io.EOF
+        }
+        return env, fmt.Errorf("read error: %w", err)
+    }
+    if err := json.Unmarshal(line, &env); err != nil {
+        return env, fmt.Errorf("malformed frame: %w", err)
+    }
+    return env, nil
+}
+
+// ReadEnvelope reads the next frame. It returns io.EOF once the peer has
+// closed the connection cleanly.
+func ReadEnvelope(sc *bufio.Scanner) (Envelope, error) {
+    var env Envelope
+    if !sc.Scan() {
+        if err := sc.Err(); err != nil {
+            return env, fmt.Errorf("read error: %w", err)
+        }
+        return env, io.EOF
+    }
+    if err := json.Unmarshal(sc.Bytes(), &env); err != nil {
+        return env, fmt.Errorf("malformed frame: %w", err)
+    }
+    return env, nil
+}
@@ -0,0 +1,107 @@
+package chat
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Filters are the peers the user does not want to hear from. Ignored and
+// muted peers are named by peer name or node ID and only hidden here;
+// blocked ones are named by node ID or address, a host or host:port, and
+// refused at the handshake.
+type Filters struct {
+    Ignore []string
+    Mute map[string]time.Time // until when
+    Block []string
+    RequireIdentity bool // refuse peers without a node ID, which no ID block can catch
+}
+
+type filterSet struct {
+    mu sync.Mutex
+    f Filters
+}
+
+func (s *filterSet) get() Filters {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.f
+}
+
+// SetFilters replaces the room's filters and drops connected peers that
+// are now blocked
+func (cr *ChatRoom) SetFilters(f Filters) {
+    cr.filters.mu.Lock()
+    cr.filters.f = f
+    cr.filters.mu.Unlock()
+
+    cr.mu.Lock()
+    peers := append([]*Peer(nil), cr.Peers...)
+    cr.mu.Unlock()
+    for _, p := range peers {
+        if cr.blocked(p.NodeID, p.Conn.RemoteAddr()) {
+            p.log.Info("dropping blocked peer")
+            p.close()
+            p.Conn.Close()
+        }
+    }
+}
+
+// hidden reports whether frames from any of names, peer names or node IDs,
+// should be kept from the user
+func (cr *ChatRoom) hidden(names ...string) bool {
+    f := cr.filters.get()
+    now := time.Now()
+    for _, name := range names {
+        if name == "" {
+            continue
+        }
+        if slices.Contains(f.Ignore, name) {
+            return true
+        }
+        if until, ok := f.Mute[name]; ok && now.Before(until) {
+            return true
+        }
+    }
+    return false
+}
+
+// blocked reports whether the node with ID id, or connecting from addr,
+// is blocked. Either may be empty or nil.
+func (cr *ChatRoom) blocked(id string, addr net.Addr) bool {
+    f := cr.filters.get()
+    if id == "" && f.RequireIdentity {
+        return true
+    }
+    var hostPort, host string
+    if addr != nil {
+        hostPort = addr.String()
+        host, _, _ = net.SplitHostPort(hostPort)
+    }
+    for _, b := range f.Block {
+        switch {
+        case id != "" && strings.EqualFold(b, id):
+            return true
+        case hostPort != "" && (b == hostPort || b == host):
+            return true
+        }
+    }
+    return false
+}
+
+// LookupPeer finds the node ID and address of the connected peer called
+// name, or failing that the node ID of one met before
+func (cr *ChatRoom) LookupPeer(name string) (id, addr string, ok bool) {
+    if p := cr.FindPeerByName(name); p != nil {
+        if a := p.Conn.RemoteAddr(); a != nil {
+            addr = a.String()
+        }
+        return p.NodeID, addr, true
+    }
+    if cr.offline == nil {
+        return "", "", false
+    }
+    return cr.offline.nodeByName(name)
+}
@@ -0,0 +1,78 @@
+package chat
+
+// Hook lets code outside PeerHandler see and change traffic. OnIncoming and
+// OnOutgoing may rewrite env in place, annotate it with Annotate, or
+// return false to drop it; later hooks then never see it. Hooks run in the
+// order they were added and must be safe for concurrent use, since every
+// peer is handled on its own goroutine.
+type Hook interface {
+    // OnIncoming is called for every frame received from peer, unless the
+    // user ignores or mutes it
+    OnIncoming(peer *Peer, env *Envelope) bool
+    // OnOutgoing is called for every message passed to Broadcast
+    OnOutgoing(env *Envelope) bool
+    OnJoin(peer *Peer)
+    OnLeave(peer *Peer)
+}
+
+// NopHook implements Hook doing nothing. Embed it to implement only the
+// methods you need.
+type NopHook struct{}
+
+func (NopHook) OnIncoming(*Peer, *Envelope) bool { return true }
+func (NopHook) OnOutgoing(*Envelope) bool { return true }
+func (NopHook) OnJoin(*Peer) {}
+func (NopHook) OnLeave(*Peer) {}
+
+// AddHook appends h to the room's hook chain
+func (cr *ChatRoom) AddHook(h Hook) {
+    cr.hookMu.Lock()
+    defer cr.hookMu.Unlock()
+    // Copy so running chains never see the slice change under them
+    cr.hooks = append(cr.hooks[:len(cr.hooks):len(cr.hooks)], h)
+}
+
+func (cr *ChatRoom) hookChain() []Hook {
+    cr.hookMu.Lock()
+    defer cr.hookMu.Unlock()
+    return cr.hooks
+}
+
+func (cr *ChatRoom) runIncoming(peer *Peer, env *Envelope) bool {
+    for _, h := range cr.hookChain() {
+        if !h.OnIncoming(peer, env) {
+            return false
+        }
+    }
+    return true
+}
+
+func (cr *ChatRoom) runOutgoing(env *Envelope) bool {
+    for _, h := range cr.hookChain() {
+        if !h.OnOutgoing(env) {
+            return false
+        }
+    }
+    return true
+}
+
+func (cr *ChatRoom) runJoin(peer *Peer) {
+    for _, h := range cr.hookChain() {
+        h.OnJoin(peer)
+    }
+}
+
+func (cr *ChatRoom) runLeave(peer *Peer) {
+    for _, h := range cr.hookChain() {
+        h.OnLeave(peer)
+    }
+}
+
+// Annotate attaches a note to the envelope. Annotations travel with it to
+// peers and are passed on to the TUI with the message.
+func (env *Envelope) Annotate(key, value string) {
+    if env.Annotations == nil {
+        env.Annotations = make(map[string]string)
+    }
+    env.Annotations[key] = value
+}
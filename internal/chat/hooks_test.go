@@ -0,0 +1,106 @@
+package chat
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	"gochat/internal/tui"
+)
+
+// recordHook appends its tag to chat text and records what it saw
+type recordHook struct {
+	NopHook
+	tag  string
+	drop string // text that makes it drop the envelope
+
+	mu     sync.Mutex
+	joined []string
+	left   []string
+}
+
+func (h *recordHook) OnIncoming(peer *Peer, env *Envelope) bool {
+	return h.handle(env)
+}
+
+func (h *recordHook) OnOutgoing(env *Envelope) bool {
+	return h.handle(env)
+}
+
+func (h *recordHook) handle(env *Envelope) bool {
+	if env.Type != TypeChat {
+		return true
+	}
+	if h.drop != "" && strings.Contains(env.Text, h.drop) {
+		return false
+	}
+	env.Text += " " + h.tag
+	env.Annotate("seen_by", env.Annotations["seen_by"]+h.tag)
+	return true
+}
+
+func (h *recordHook) OnJoin(peer *Peer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.joined = append(h.joined, peer.Name)
+}
+
+func (h *recordHook) OnLeave(peer *Peer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.left = append(h.left, peer.Name)
+}
+
+func TestHookChainOrderAndDrop(t *testing.T) {
+	room := NewRoom()
+	room.AddHook(&recordHook{tag: "a", drop: "secret"})
+	room.AddHook(&recordHook{tag: "b"})
+
+	env, ok := Broadcast(room, NewChat("alice", "hi"))
+	if !ok || env.Text != "hi a b" || env.Annotations["seen_by"] != "ab" {
+		t.Errorf("Unexpected result %v %+v", ok, env)
+	}
+	if _, ok := Broadcast(room, NewChat("alice", "the secret")); ok {
+		t.Error("Expected the first hook to drop the message")
+	}
+
+	in := NewChat("bob", "hello")
+	if !room.runIncoming(nil, &in) || in.Text != "hello a b" {
+		t.Errorf("Unexpected incoming result %+v", in)
+	}
+
+	// Non-chat frames pass through untouched
+	offer := Envelope{Type: TypeFileOffer, Name: "x"}
+	if !room.runIncoming(nil, &offer) || offer.Annotations != nil {
+		t.Errorf("Unexpected offer result %+v", offer)
+	}
+}
+
+func TestHooksSeeTraffic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer bob.Shutdown()
+	bobMsgs := make(chan tui.Message, 10)
+	bob.SetTUIMessageChannel(bobMsgs)
+	hook := &recordHook{tag: "[bob]", drop: "spam"}
+	bob.AddHook(hook)
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	Broadcast(alice, NewChat("alice", "buy spam"))
+	Broadcast(alice, NewChat("alice", "hello"))
+	msg := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.From == "alice" && m.Kind == tui.KindText })
+	if msg.Text != "hello [bob]" || msg.Annotations["seen_by"] != "[bob]" {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+
+	alice.Shutdown()
+	waitFor(t, bobMsgs, func(m tui.Message) bool { return strings.Contains(m.Text, "left") })
+	hook.mu.Lock()
+	defer hook.mu.Unlock()
+	if len(hook.joined) != 1 || hook.joined[0] != "alice" || len(hook.left) != 1 {
+		t.Errorf("Unexpected join/leave calls %v %v", hook.joined, hook.left)
+	}
+}
@@ -0,0 +1,186 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Bytes of randomness in a handshake challenge
+const challengeSize = 16
+
+// Identity is a node's long-lived X25519 key pair. Its ID, derived from the
+// public key, names the node across restarts and address changes, and the
+// key lets peers seal messages that only this node can open.
+type Identity struct {
+    ID string
+    key *ecdh.PrivateKey
+}
+
+// LoadIdentity reads the node key kept at path, creating one the first time
+func LoadIdentity(path string) (*Identity, error) {
+    b, err := os.ReadFile(path)
+    if errors.Is(err, os.ErrNotExist) {
+        key, err := ecdh.X25519().GenerateKey(rand.Reader)
+        if err != nil {
+            return nil, err
+        }
+        if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+            return nil, err
+        }
+        if err := os.WriteFile(path, key.Bytes(), 0o600); err != nil {
+            return nil, err
+        }
+        return newIdentity(key), nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    key, err := ecdh.X25519().NewPrivateKey(b)
+    if err != nil {
+        return nil, fmt.Errorf("bad node key in %s: %w", path, err)
+    }
+    return newIdentity(key), nil
+}
+
+func newIdentity(key *ecdh.PrivateKey) *Identity {
+    return &Identity{ID: NodeID(key.PublicKey().Bytes()), key: key}
+}
+
+// PublicKey returns the key peers seal messages to
+func (id *Identity) PublicKey() []byte {
+    return id.key.PublicKey().Bytes()
+}
+
+// NodeID derives the ID of the node with public key pub
+func NodeID(pub []byte) string {
+    sum := sha256.Sum256(pub)
+    return hex.EncodeToString(sum[:16])
+}
+
+// newChallenge returns fresh random bytes for a hello, which the peer must
+// answer to show it holds the key it claims
+func newChallenge() []byte {
+    b := make([]byte, challengeSize)
+    rand.Read(b)
+    return b
+}
+
+// exchangeProofs has both ends of a handshake show they hold the private
+// keys their hellos name. Each sends a MAC over both challenges, keyed by
+// the secret the two key pairs share, which only a holder of either
+// private key can compute, and checks the other's. Knowing a node's public
+// key and ID is not enough to pass as it.
+func (id *Identity) exchangeProofs(w io.Writer, br *bufio.Reader, hello Envelope, challenge []byte) error {
+    if len(hello.Challenge) != challengeSize {
+        return errors.New("hello has no challenge")
+    }
+    ours, err := id.handshakeMAC(hello.Key, id.ID, challenge, hello.Challenge)
+    if err != nil {
+        return err
+    }
+    want, err := id.handshakeMAC(hello.Key, hello.NodeID, hello.Challenge, challenge)
+    if err != nil {
+        return err
+    }
+    sent := make(chan error, 1)
+    go func() {
+        sent <- WriteEnvelope(w, Envelope{Type: TypeProof, Data: ours})
+    }()
+    proof, err := readHello(br)
+    if err != nil {
+        return err
+    }
+    if err := <-sent; err != nil {
+        return err
+    }
+    if proof.Type != TypeProof || !hmac.Equal(proof.Data, want) {
+        return errors.New("peer did not prove it holds its node key")
+    }
+    return nil
+}
+
+// handshakeMAC is the proof the node with ID sender gives in a handshake
+// with the node with public key other: its own challenge first, then the
+// peer's
+func (id *Identity) handshakeMAC(other []byte, sender string, first, second []byte) ([]byte, error) {
+    pub, err := ecdh.X25519().NewPublicKey(other)
+    if err != nil {
+        return nil, err
+    }
+    secret, err := id.key.ECDH(pub)
+    if err != nil {
+        return nil, err
+    }
+    key, err := hkdf.Key(sha256.New, secret, nil, "gochat handshake", 32)
+    if err != nil {
+        return nil, err
+    }
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(sender))
+    mac.Write(first)
+    mac.Write(second)
+    return mac.Sum(nil), nil
+}
+
+// seal encrypts plain so that only the node with public key to can open
+// it, and only with the key of the node that sealed it, which proves the
+// sender
+func (id *Identity) seal(to []byte, plain []byte) ([]byte, error) {
+    aead, err := id.pairCipher(to)
+    if err != nil {
+        return nil, err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return nil, err
+    }
+    return aead.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open decrypts a message sealed for this node by the node with public
+// key from
+func (id *Identity) open(from []byte, sealed []byte) ([]byte, error) {
+    aead, err := id.pairCipher(from)
+    if err != nil {
+        return nil, err
+    }
+    if len(sealed) < aead.NonceSize() {
+        return nil, errors.New("sealed message too short")
+    }
+    nonce, box := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+    return aead.Open(nil, nonce, box, nil)
+}
+
+// pairCipher derives the cipher shared by this node and the node with
+// public key other. Both ends derive the same one.
+func (id *Identity) pairCipher(other []byte) (cipher.AEAD, error) {
+    pub, err := ecdh.X25519().NewPublicKey(other)
+    if err != nil {
+        return nil, err
+    }
+    secret, err := id.key.ECDH(pub)
+    if err != nil {
+        return nil, err
+    }
+    key, err := hkdf.Key(sha256.New, secret, nil, "gochat sealed message", 32)
+    if err != nil {
+        return nil, err
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
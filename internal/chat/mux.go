@@ -0,0 +1,515 @@
+package chat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Mux frame types
+const (
+    frameData byte = iota
+    frameWindow // payload is a uint32 window increment
+    frameOpen // payload is the protocol name of the new stream
+    frameClose
+    framePing
+    framePong
+)
+
+// Well-known streams that exist on every session without being opened
+const (
+    StreamControl uint32 = 1 // handshake follow-ups, offers, acks
+    StreamChat uint32 = 2 // chat messages
+    StreamBulk uint32 = 3 // file chunks and other large payloads
+)
+
+const (
+    firstDynamicStream = 16
+    muxHeaderSize = 9 // type(1) + stream(4) + length(4)
+    maxFramePayload = 16 * 1024
+    initialWindow = 256 * 1024
+    acceptBacklog = 16
+    pingInterval = 15 * time.Second
+    idleTimeout = 3 * pingInterval
+)
+
+var (
+    ErrSessionClosed = errors.New("mux session closed")
+    ErrStreamClosed = errors.New("mux stream closed")
+    errIdleTimeout = errors.New("mux session timed out")
+)
+
+type outFrame struct {
+    buf []byte
+    done chan error
+}
+
+// Session multiplexes independent logical streams over one connection.
+// Each stream has its own flow-control window, so a slow reader on one
+// stream never holds up another. Frames from high-priority streams and
+// session keepalives are always written ahead of normal ones, and normal
+// writers take turns one frame at a time.
+type Session struct {
+    conn io.WriteCloser
+    r io.Reader
+    mu sync.Mutex
+    streams map[uint32]*Stream
+    nextID uint32
+    accept chan *Stream
+    urgent chan outFrame
+    normal chan outFrame
+    closed chan struct{}
+    closeOnce sync.Once
+    err error
+    lastRecv atomic.Int64
+}
+
+// NewSession starts a session on conn, reading from r (which may be a
+// buffered reader wrapping conn). The two ends of a connection must pass
+// opposite values for initiator so dynamically opened stream IDs never
+// collide.
+func NewSession(conn io.WriteCloser, r io.Reader, initiator bool) *Session {
+    s := &Session{
+        conn: conn,
+        r: r,
+        streams: make(map[uint32]*Stream),
+        nextID: firstDynamicStream,
+        accept: make(chan *Stream, acceptBacklog),
+        urgent: make(chan outFrame),
+        normal: make(chan outFrame),
+        closed: make(chan struct{}),
+    }
+    if initiator {
+        s.nextID++
+    }
+    s.lastRecv.Store(time.Now().UnixNano())
+    go s.readLoop()
+    go s.writeLoop()
+    go s.keepalive()
+    return s
+}
+
+// Stream returns one of the well-known streams, creating it on first use
+func (s *Session) Stream(id uint32) *Stream {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    return s.streamLocked(id, "")
+}
+
+func (s *Session) streamLocked(id uint32, proto string) *Stream {
+    st, ok := s.streams[id]
+    if !ok {
+        st = newStream(s, id, proto)
+        s.streams[id] = st
+    }
+    return st
+}
+
+// Open starts a new stream for the given protocol. The remote side receives
+// it from Accept.
+func (s *Session) Open(proto string) (*Stream, error) {
+    s.mu.Lock()
+    id := s.nextID
+    s.nextID += 2
+    st := s.streamLocked(id, proto)
+    s.mu.Unlock()
+
+    if err := s.writeFrame(frameOpen, id, []byte(proto), true); err != nil {
+        return nil, err
+    }
+    return st, nil
+}
+
+// Accept waits for the remote side to open a stream
+func (s *Session) Accept() (*Stream, error) {
+    select {
+    case st := <-s.accept:
+        return st, nil
+    case <-s.closed:
+        return nil, s.Err()
+    }
+}
+
+// Done is closed once the session has shut down
+func (s *Session) Done() <-chan struct{} {
+    return s.closed
+}
+
+// Err reports why the session shut down
+func (s *Session) Err() error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if s.err == nil {
+        return ErrSessionClosed
+    }
+    return s.err
+}
+
+func (s *Session) Close() error {
+    s.closeWithErr(ErrSessionClosed)
+    return nil
+}
+
+func (s *Session) closeWithErr(err error) {
+    s.closeOnce.Do(func() {
+        s.mu.Lock()
+        s.err = err
+        streams := make([]*Stream, 0, len(s.streams))
+        for _, st := range s.streams {
+            streams = append(streams, st)
+        }
+        s.mu.Unlock()
+
+        close(s.closed)
+        s.conn.Close()
+        for _, st := range streams {
+            st.wake()
+        }
+    })
+}
+
+// writeFrame queues a frame and waits until it has been written
+func (s *Session) writeFrame(typ byte, id uint32, payload []byte, urgent bool) error {
+    buf := make([]byte, muxHeaderSize+len(payload))
+    buf[0] = typ
+    binary.BigEndian.PutUint32(buf[1:5], id)
+    binary.BigEndian.PutUint32(buf[5:9], uint32(len(payload)))
+    copy(buf[muxHeaderSize:], payload)
+
+    f := outFrame{buf: buf, done: make(chan error, 1)}
+    queue := s.normal
+    if urgent {
+        queue = s.urgent
+    }
+    select {
+    case queue <- f:
+    case <-s.closed:
+        return s.Err()
+    }
+    select {
+    case err := <-f.done:
+        return err
+    case <-s.closed:
+        return s.Err()
+    }
+}
+
+func (s *Session) writeLoop() {
+    for {
+        var f outFrame
+        select {
+        case f = <-s.urgent:
+        default:
+            // Blocked senders on an unbuffered channel are served in
+            // order, so normal streams get one frame each in turn.
+            select {
+            case f = <-s.urgent:
+            case f = <-s.normal:
+            case <-s.closed:
+                return
+            }
+        }
+        _, err := s.conn.Write(f.buf)
+        f.done <- err
+        if err != nil {
+            s.closeWithErr(err)
+            return
+        }
+    }
+}
+
+func (s *Session) readLoop() {
+    header := make([]byte, muxHeaderSize)
+    for {
+        if _, err := io.ReadFull(s.r, header); err != nil {
+            s.closeWithErr(err)
+            return
+        }
+        typ := header[0]
+        id := binary.BigEndian.Uint32(header[1:5])
+        n := binary.BigEndian.Uint32(header[5:9])
+        if n > maxFramePayload {
+            s.closeWithErr(fmt.Errorf("mux frame of %d bytes exceeds limit", n))
+            return
+        }
+        payload := make([]byte, n)
+        if _, err := io.ReadFull(s.r, payload); err != nil {
+            s.closeWithErr(err)
+            return
+        }
+        s.lastRecv.Store(time.Now().UnixNano())
+
+        if err := s.handleFrame(typ, id, payload); err != nil {
+            s.closeWithErr(err)
+            return
+        }
+    }
+}
+
+func (s *Session) handleFrame(typ byte, id uint32, payload []byte) error {
+    switch typ {
+    case framePing:
+        go s.writeFrame(framePong, 0, nil, true)
+        return nil
+    case framePong:
+        return nil
+    case frameOpen:
+        s.mu.Lock()
+        _, exists := s.streams[id]
+        st := s.streamLocked(id, string(payload))
+        s.mu.Unlock()
+        if exists {
+            return nil
+        }
+        select {
+        case s.accept <- st:
+        default:
+            // Nobody is accepting streams; refuse it
+            st.Close()
+        }
+        return nil
+    }
+
+    s.mu.Lock()
+    st, ok := s.streams[id]
+    if !ok && id < firstDynamicStream {
+        st = s.streamLocked(id, "")
+        ok = true
+    }
+    s.mu.Unlock()
+    if !ok {
+        // Frames for a stream we already dropped
+        return nil
+    }
+
+    switch typ {
+    case frameData:
+        return st.receive(payload)
+    case frameWindow:
+        if len(payload) != 4 {
+            return fmt.Errorf("malformed window update")
+        }
+        st.grow(binary.BigEndian.Uint32(payload))
+    case frameClose:
+        st.remoteClose()
+    default:
+        return fmt.Errorf("unknown mux frame type %d", typ)
+    }
+    return nil
+}
+
+func (s *Session) keepalive() {
+    ticker := time.NewTicker(pingInterval)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-s.closed:
+            return
+        case <-ticker.C:
+            if time.Since(time.Unix(0, s.lastRecv.Load())) > idleTimeout {
+                s.closeWithErr(errIdleTimeout)
+                return
+            }
+            go s.writeFrame(framePing, 0, nil, true)
+        }
+    }
+}
+
+func (s *Session) forget(id uint32) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.streams, id)
+}
+
+// Stream is one logical, flow-controlled byte stream within a Session.
+// Concurrent Writes are serialised, so each Write lands contiguously.
+type Stream struct {
+    id uint32
+    proto string
+    sess *Session
+    writeMu sync.Mutex
+    mu sync.Mutex
+    cond *sync.Cond
+    buf bytes.Buffer
+    unacked uint32 // bytes read since the last window update we sent
+    recvWindow uint32 // bytes the remote may still send us
+    sendWindow uint32 // bytes we may still send the remote
+    localClosed bool
+    remoteClosed bool
+    priority bool
+}
+
+func newStream(s *Session, id uint32, proto string) *Stream {
+    st := &Stream{
+        id: id,
+        proto: proto,
+        sess: s,
+        recvWindow: initialWindow,
+        sendWindow: initialWindow,
+    }
+    st.cond = sync.NewCond(&st.mu)
+    return st
+}
+
+func (st *Stream) ID() uint32 {
+    return st.id
+}
+
+// Protocol is the name the opener gave the stream, empty for well-known ones
+func (st *Stream) Protocol() string {
+    return st.proto
+}
+
+// SetPriority makes the stream's frames jump ahead of normal streams. Use
+// it for small, latency-sensitive traffic only.
+func (st *Stream) SetPriority(high bool) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    st.priority = high
+}
+
+func (st *Stream) Read(p []byte) (int, error) {
+    st.mu.Lock()
+    for st.buf.Len() == 0 {
+        if st.remoteClosed {
+            st.mu.Unlock()
+            return 0, io.EOF
+        }
+        if st.isSessionClosed() {
+            st.mu.Unlock()
+            return 0, st.sessionErr()
+        }
+        st.cond.Wait()
+    }
+    n, _ := st.buf.Read(p)
+    st.unacked += uint32(n)
+    var grant uint32
+    if st.unacked >= initialWindow/2 {
+        grant = st.unacked
+        st.unacked = 0
+        st.recvWindow += grant
+    }
+    st.mu.Unlock()
+
+    if grant > 0 {
+        var b [4]byte
+        binary.BigEndian.PutUint32(b[:], grant)
+        go st.sess.writeFrame(frameWindow, st.id, b[:], true)
+    }
+    return n, nil
+}
+
+// Write sends p, blocking while the remote's receive window is exhausted
+func (st *Stream) Write(p []byte) (int, error) {
+    st.writeMu.Lock()
+    defer st.writeMu.Unlock()
+
+    written := 0
+    for len(p) > 0 {
+        st.mu.Lock()
+        for st.sendWindow == 0 && !st.localClosed && !st.isSessionClosed() {
+            st.cond.Wait()
+        }
+        if st.localClosed {
+            st.mu.Unlock()
+            return written, ErrStreamClosed
+        }
+        if st.isSessionClosed() {
+            st.mu.Unlock()
+            return written, st.sessionErr()
+        }
+        n := min(uint32(len(p)), st.sendWindow, maxFramePayload)
+        st.sendWindow -= n
+        urgent := st.priority
+        st.mu.Unlock()
+
+        if err := st.sess.writeFrame(frameData, st.id, p[:n], urgent); err != nil {
+            return written, err
+        }
+        written += int(n)
+        p = p[n:]
+    }
+    return written, nil
+}
+
+// Close ends our side of the stream. Reads continue until the remote
+// closes its side too.
+func (st *Stream) Close() error {
+    st.mu.Lock()
+    if st.localClosed {
+        st.mu.Unlock()
+        return nil
+    }
+    st.localClosed = true
+    done := st.remoteClosed
+    st.cond.Broadcast()
+    st.mu.Unlock()
+
+    if done {
+        st.sess.forget(st.id)
+    }
+    err := st.sess.writeFrame(frameClose, st.id, nil, true)
+    if errors.Is(err, ErrSessionClosed) {
+        return nil
+    }
+    return err
+}
+
+func (st *Stream) receive(p []byte) error {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    if uint32(len(p)) > st.recvWindow {
+        return fmt.Errorf("stream %d overran its flow-control window", st.id)
+    }
+    st.recvWindow -= uint32(len(p))
+    st.buf.Write(p)
+    st.cond.Broadcast()
+    return nil
+}
+
+func (st *Stream) grow(n uint32) {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    st.sendWindow += n
+    st.cond.Broadcast()
+}
+
+func (st *Stream) remoteClose() {
+    st.mu.Lock()
+    st.remoteClosed = true
+    done := st.localClosed
+    st.cond.Broadcast()
+    st.mu.Unlock()
+    if done {
+        st.sess.forget(st.id)
+    }
+}
+
+func (st *Stream) wake() {
+    st.mu.Lock()
+    defer st.mu.Unlock()
+    st.cond.Broadcast()
+}
+
+func (st *Stream) isSessionClosed() bool {
+    select {
+    case <-st.sess.closed:
+        return true
+    default:
+        return false
+    }
+}
+
+// sessionErr maps a clean disconnect to io.EOF for readers
+func (st *Stream) sessionErr() error {
+    err := st.sess.Err()
+    if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+        return io.EOF
+    }
+    return err
+}
@@ -0,0 +1,140 @@
+package chat
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func newSessionPair(t *testing.T) (*Session, *Session) {
+	t.Helper()
+	a, b := net.Pipe()
+	sa := NewSession(a, a, true)
+	sb := NewSession(b, b, false)
+	t.Cleanup(func() {
+		sa.Close()
+		sb.Close()
+	})
+	return sa, sb
+}
+
+func TestMuxWellKnownStream(t *testing.T) {
+	sa, sb := newSessionPair(t)
+
+	msg := []byte("hello over the chat stream")
+	go sa.Stream(StreamChat).Write(msg)
+
+	got := make([]byte, len(msg))
+	if _, err := io.ReadFull(sb.Stream(StreamChat), got); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, msg) {
+		t.Errorf("Expected %q, got %q", msg, got)
+	}
+}
+
+func TestMuxBlockedStreamDoesNotStallOthers(t *testing.T) {
+	sa, sb := newSessionPair(t)
+
+	// Nobody reads the bulk stream, so this write fills the window and stalls
+	bulkDone := make(chan struct{})
+	go func() {
+		defer close(bulkDone)
+		sa.Stream(StreamBulk).Write(make([]byte, 4*initialWindow))
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	go sa.Stream(StreamChat).Write([]byte("ping"))
+
+	got := make([]byte, 4)
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.ReadFull(sb.Stream(StreamChat), got)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Chat stream was held up by the bulk stream")
+	}
+
+	select {
+	case <-bulkDone:
+		t.Fatal("Bulk write finished without the receiver opening its window")
+	default:
+	}
+
+	// Draining the bulk stream lets the writer finish
+	if _, err := io.CopyN(io.Discard, sb.Stream(StreamBulk), 4*initialWindow); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-bulkDone:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Bulk write did not complete after the window reopened")
+	}
+}
+
+func TestMuxOpenAccept(t *testing.T) {
+	sa, sb := newSessionPair(t)
+
+	accepted := make(chan *Stream, 1)
+	go func() {
+		st, err := sb.Accept()
+		if err == nil {
+			accepted <- st
+		}
+	}()
+
+	st, err := sa.Open("echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	st.Close()
+
+	var remote *Stream
+	select {
+	case remote = <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for stream")
+	}
+	if remote.Protocol() != "echo" {
+		t.Errorf("Expected protocol 'echo', got %q", remote.Protocol())
+	}
+	got, err := io.ReadAll(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "abc" {
+		t.Errorf("Expected 'abc', got %q", got)
+	}
+}
+
+func TestMuxSessionClose(t *testing.T) {
+	sa, sb := newSessionPair(t)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := sb.Stream(StreamControl).Read(make([]byte, 1))
+		readErr <- err
+	}()
+	sa.Close()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Error("Expected read error after remote close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Read did not return after session closed")
+	}
+	<-sb.Done()
+}
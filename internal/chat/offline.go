@@ -0,0 +1,452 @@
+package chat
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Limits on messages kept for nodes that are away
+const (
+    maxPendingPerNode = 200 // queued or held for any one node
+    maxHeld = 2000 // held for other nodes, all of them together
+)
+
+// Known nodes not seen for this long are forgotten, so messages stop
+// being queued for them
+const forgetAfter = 30 * 24 * time.Hour
+
+// knownPeer is a node this one has met, remembered so that messages can
+// wait for it and messages it sealed can be opened
+type knownPeer struct {
+    Name string `json:"name"`
+    Key []byte `json:"key"`
+    Seen time.Time `json:"seen"`
+}
+
+// pending is a frame waiting for an absent node: one of our own chat
+// messages, or a sealed forward another node asked us to hold
+type pending struct {
+    To string `json:"to"`
+    Env Envelope `json:"env"`
+    Expires time.Time `json:"expires"`
+}
+
+// offlineStore keeps known nodes, our queue for them and what we hold for
+// others in files in a directory, so none of it is lost on restart
+type offlineStore struct {
+    mu sync.Mutex
+    dir string
+    ttl time.Duration
+    known map[string]knownPeer // by node ID
+    outbox []pending
+    held []pending
+}
+
+const (
+    knownFile = "known_peers.json"
+    outboxFile = "outbox.json"
+    heldFile = "held.json"
+)
+
+func loadOfflineStore(dir string, ttl time.Duration) (*offlineStore, error) {
+    s := &offlineStore{dir: dir, ttl: ttl, known: make(map[string]knownPeer)}
+    for name, v := range map[string]any{knownFile: &s.known, outboxFile: &s.outbox, heldFile: &s.held} {
+        b, err := os.ReadFile(filepath.Join(dir, name))
+        if errors.Is(err, os.ErrNotExist) {
+            continue
+        }
+        if err != nil {
+            return nil, err
+        }
+        if err := json.Unmarshal(b, v); err != nil {
+            return nil, err
+        }
+    }
+    // Nodes saved before last seen times were kept count from now
+    now := time.Now()
+    for id, p := range s.known {
+        if p.Seen.IsZero() {
+            p.Seen = now
+            s.known[id] = p
+        }
+    }
+    return s, nil
+}
+
+// save writes v to the named file, replacing it whole
+func (s *offlineStore) save(name string, v any) error {
+    b, err := json.Marshal(v)
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(s.dir, 0o700); err != nil {
+        return err
+    }
+    path := filepath.Join(s.dir, name)
+    if err := os.WriteFile(path+".tmp", b, 0o600); err != nil {
+        return err
+    }
+    return os.Rename(path+".tmp", path)
+}
+
+// meet records a node seen in a handshake
+func (s *offlineStore) meet(id, name string, key []byte) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.known[id] = knownPeer{Name: name, Key: key, Seen: time.Now()}
+    return s.save(knownFile, s.known)
+}
+
+// forget drops a known node and our queue for it
+func (s *offlineStore) forget(id string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    delete(s.known, id)
+    s.outbox, _ = takePending(s.outbox, id)
+    return errors.Join(s.save(knownFile, s.known), s.save(outboxFile, s.outbox))
+}
+
+func (s *offlineStore) knownPeer(id string) (knownPeer, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    p, ok := s.known[id]
+    return p, ok
+}
+
+// nodeByName returns the ID of a known node called name
+func (s *offlineStore) nodeByName(name string) (id, addr string, ok bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    for id, p := range s.known {
+        if p.Name == name {
+            return id, "", true
+        }
+    }
+    return "", "", false
+}
+
+// absent returns the known nodes that are not in connected, first
+// forgetting those not seen for forgetAfter
+func (s *offlineStore) absent(connected map[string]bool) (map[string]knownPeer, error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var err error
+    stale := time.Now().Add(-forgetAfter)
+    for id, p := range s.known {
+        if !connected[id] && p.Seen.Before(stale) {
+            delete(s.known, id)
+            err = s.save(knownFile, s.known)
+        }
+    }
+    out := make(map[string]knownPeer)
+    for id, p := range s.known {
+        if !connected[id] {
+            out[id] = p
+        }
+    }
+    return out, err
+}
+
+// queue adds one of our messages for each node in to, dropping the oldest
+// for one past the limit
+func (s *offlineStore) queue(env Envelope, to ...string) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    expires := time.Now().Add(s.ttl)
+    for _, id := range to {
+        s.outbox = addPending(s.outbox, pending{To: id, Env: env, Expires: expires}, maxPendingPerNode)
+    }
+    return s.save(outboxFile, s.outbox)
+}
+
+// hold keeps a sealed forward for node to until expires, or the longest
+// this node is willing to, whichever comes first
+func (s *offlineStore) hold(to string, env Envelope, expires time.Time) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    if limit := time.Now().Add(s.ttl); expires.After(limit) {
+        expires = limit
+    }
+    s.held = addPending(s.held, pending{To: to, Env: env, Expires: expires}, maxPendingPerNode)
+    if over := len(s.held) - maxHeld; over > 0 {
+        s.held = s.held[over:]
+    }
+    return s.save(heldFile, s.held)
+}
+
+// take removes and returns what is waiting for node to, our own messages
+// first
+func (s *offlineStore) take(to string) (queued, held []pending, err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    s.outbox, queued = takePending(s.outbox, to)
+    s.held, held = takePending(s.held, to)
+    if len(queued) > 0 {
+        err = s.save(outboxFile, s.outbox)
+    }
+    if len(held) > 0 {
+        err = errors.Join(err, s.save(heldFile, s.held))
+    }
+    return queued, held, err
+}
+
+// putBack returns entries take handed out that could not be sent, ahead
+// of anything added since
+func (s *offlineStore) putBack(queued, held []pending) error {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    var err error
+    if len(queued) > 0 {
+        s.outbox = append(slices.Clone(queued), s.outbox...)
+        err = s.save(outboxFile, s.outbox)
+    }
+    if len(held) > 0 {
+        s.held = append(slices.Clone(held), s.held...)
+        err = errors.Join(err, s.save(heldFile, s.held))
+    }
+    return err
+}
+
+// addPending appends p to list, first dropping expired entries and then
+// the oldest for the same node while it has limit or more
+func addPending(list []pending, p pending, limit int) []pending {
+    now := time.Now()
+    count := 0
+    for _, old := range list {
+        if old.To == p.To && now.Before(old.Expires) {
+            count++
+        }
+    }
+    out := list[:0]
+    for _, old := range list {
+        if !now.Before(old.Expires) {
+            continue
+        }
+        if old.To == p.To && count >= limit {
+            count--
+            continue
+        }
+        out = append(out, old)
+    }
+    return append(out, p)
+}
+
+// takePending splits the unexpired entries for node to off list
+func takePending(list []pending, to string) (rest, taken []pending) {
+    now := time.Now()
+    out := list[:0]
+    for _, p := range list {
+        switch {
+        case !now.Before(p.Expires):
+        case p.To == to:
+            taken = append(taken, p)
+        default:
+            out = append(out, p)
+        }
+    }
+    return out, taken
+}
+
+// SetIdentity sets the key pair the room presents in handshakes. Without
+// one peers cannot recognise this node when it comes back, and nothing is
+// queued or held for it.
+func (cr *ChatRoom) SetIdentity(id *Identity) {
+    cr.identity = id
+}
+
+// SetOfflineStore keeps known nodes, messages queued for them while they
+// are away and sealed messages held for other nodes in dir. Nothing waits
+// longer than ttl.
+func (cr *ChatRoom) SetOfflineStore(dir string, ttl time.Duration) error {
+    s, err := loadOfflineStore(dir, ttl)
+    if err != nil {
+        return err
+    }
+    cr.offline = s
+    return nil
+}
+
+// meet remembers a peer with an identity and hands it whatever has been
+// waiting for it
+func (cr *ChatRoom) meet(peer *Peer) {
+    if cr.offline == nil || peer.NodeID == "" {
+        return
+    }
+    if err := cr.offline.meet(peer.NodeID, peer.Name, peer.key); err != nil {
+        peer.log.Warn("failed to save known peers", "err", err)
+    }
+    queued, held, err := cr.offline.take(peer.NodeID)
+    if err != nil {
+        peer.log.Warn("failed to save offline queue", "err", err)
+    }
+    // Once a send fails the peer is gone or stuck, so the rest are kept
+    // for next time
+    sent := 0
+    for ; sent < len(queued); sent++ {
+        if peer.Send(queued[sent].Env) != nil {
+            break
+        }
+        cr.setReceipt(queued[sent].Env.ID, peer.receiptKey(), ReceiptSent)
+    }
+    forwarded := 0
+    if sent == len(queued) {
+        for forwarded < len(held) && peer.Send(held[forwarded].Env) == nil {
+            forwarded++
+        }
+    }
+    if err := cr.offline.putBack(queued[sent:], held[forwarded:]); err != nil {
+        peer.log.Warn("failed to save offline queue", "err", err)
+    }
+    if sent+forwarded > 0 {
+        peer.log.Info("delivered messages kept while the peer was away", "queued", sent, "held", forwarded)
+    }
+    if kept := len(queued) - sent + len(held) - forwarded; kept > 0 {
+        peer.log.Warn("kept messages the peer did not take for next time", "count", kept)
+    }
+}
+
+// queueOffline keeps a chat message for every known node that is not
+// connected, and asks the connected peers to hold a sealed copy in case
+// they see it first. states gets the queued nodes' receipts.
+func (cr *ChatRoom) queueOffline(env Envelope, peers []*Peer, states map[string]peerReceipt) {
+    if cr.offline == nil || cr.identity == nil {
+        return
+    }
+    connected := make(map[string]bool, len(peers))
+    for _, p := range peers {
+        connected[p.NodeID] = true
+    }
+    absent, err := cr.offline.absent(connected)
+    if err != nil {
+        cr.log.Warn("failed to save known peers", "err", err)
+    }
+    for id := range absent {
+        if cr.blocked(id, nil) {
+            delete(absent, id)
+        }
+    }
+    if len(absent) == 0 {
+        return
+    }
+    plain, err := json.Marshal(env)
+    if err != nil {
+        return
+    }
+    if err := cr.offline.queue(env, slices.Collect(maps.Keys(absent))...); err != nil {
+        cr.log.Warn("failed to save offline queue", "err", err)
+    }
+    // Holds only help the message along, as it is queued here anyway, so a
+    // peer that cannot take one is not asked again
+    holders := slices.Clone(peers)
+    expires := time.Now().Add(cr.offline.ttl)
+    for id, known := range absent {
+        states[id] = peerReceipt{name: known.Name, state: ReceiptQueued}
+        sealed, err := cr.identity.seal(known.Key, plain)
+        if err != nil {
+            cr.log.Warn("failed to seal message", "to", known.Name, "err", err)
+            continue
+        }
+        hold := Envelope{Type: TypeHold, ID: env.ID, NodeID: cr.identity.ID, To: id, Data: sealed, Expires: expires.Unix()}
+        holders = slices.DeleteFunc(holders, func(p *Peer) bool {
+            if err := p.Send(hold); err != nil {
+                p.log.Warn("could not ask the peer to hold a message", "to", known.Name, "err", err)
+                return true
+            }
+            return false
+        })
+    }
+}
+
+// Forget stops queueing messages for a known node that is away, dropping
+// what is queued for it already
+func (cr *ChatRoom) Forget(name string) error {
+    if cr.offline == nil {
+        return fmt.Errorf("no known nodes are kept")
+    }
+    id, _, ok := cr.offline.nodeByName(name)
+    if !ok {
+        return fmt.Errorf("no known node %s", name)
+    }
+    if cr.FindPeerByNodeID(id) != nil {
+        return fmt.Errorf("%s is connected", name)
+    }
+    if err := cr.offline.forget(id); err != nil {
+        return err
+    }
+    cr.failReceipts(id, ReceiptQueued)
+    cr.systemf("Forgot %s, nothing more waits for them", name)
+    return nil
+}
+
+// handleHold keeps a sealed message for an absent node, or passes it on
+// straight away if that node is connected here
+func (cr *ChatRoom) handleHold(peer *Peer, env Envelope) {
+    if cr.offline == nil || cr.identity == nil || env.To == "" || env.NodeID == "" || len(env.Data) == 0 {
+        return
+    }
+    if cr.blocked(env.NodeID, nil) || cr.blocked(env.To, nil) {
+        peer.log.Info("not holding a message to or from a blocked node", "from", env.NodeID, "to", env.To)
+        return
+    }
+    env.Type = TypeForward
+    if env.To == cr.identity.ID {
+        cr.handleForward(peer, env)
+        return
+    }
+    if to := cr.FindPeerByNodeID(env.To); to != nil {
+        to.Send(env)
+        return
+    }
+    if err := cr.offline.hold(env.To, env, time.Unix(env.Expires, 0)); err != nil {
+        peer.log.Warn("failed to save held messages", "err", err)
+    }
+}
+
+// handleForward opens a message sealed for this node by another, which
+// may have been held by a third, and shows it as if it came directly
+func (cr *ChatRoom) handleForward(peer *Peer, env Envelope) {
+    if cr.offline == nil || cr.identity == nil || env.To != cr.identity.ID {
+        return
+    }
+    if cr.blocked(env.NodeID, nil) {
+        return
+    }
+    sender, ok := cr.offline.knownPeer(env.NodeID)
+    if !ok {
+        peer.log.Warn("dropping forwarded message from an unknown node", "node", env.NodeID)
+        return
+    }
+    plain, err := cr.identity.open(sender.Key, env.Data)
+    var msg Envelope
+    if err == nil {
+        err = json.Unmarshal(plain, &msg)
+    }
+    if err != nil || msg.Type != TypeChat || msg.ID == "" {
+        peer.log.Warn("dropping forwarded message that does not open", "node", env.NodeID, "err", err)
+        return
+    }
+    cr.receiveChat(sender.Name, env.NodeID, msg)
+    if from := cr.FindPeerByNodeID(env.NodeID); from != nil {
+        from.Send(NewAck(msg.ID))
+    }
+}
+
+// FindPeerByNodeID returns the connected peer with node ID id
+func (cr *ChatRoom) FindPeerByNodeID(id string) *Peer {
+    cr.mu.Lock()
+    defer cr.mu.Unlock()
+
+    for _, p := range cr.Peers {
+        if p.NodeID == id {
+            return p
+        }
+    }
+    return nil
+}
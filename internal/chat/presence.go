@@ -0,0 +1,104 @@
+package chat
+
+import (
+	"strings"
+
+	"gochat/internal/tui"
+)
+
+// Presence states. A status a peer sends that is not one of these is taken
+// as online.
+const (
+    StatusOnline = "online"
+    StatusAway = "away"
+    StatusBusy = "busy"
+    StatusOffline = "offline" // only ever reported locally, when a peer leaves
+)
+
+// Longest presence note kept, in runes
+const maxStatusNote = 64
+
+// NewPresence builds an envelope announcing status, with an optional note
+// such as "back at 3"
+func NewPresence(from, status, note string) Envelope {
+    return Envelope{Type: TypePresence, From: from, Status: status, Text: note}
+}
+
+// NewTyping builds an envelope saying from is writing in room
+func NewTyping(from, room string) Envelope {
+    return Envelope{Type: TypeTyping, From: from, Room: room}
+}
+
+// cleanStatus makes a status and note received from a peer safe to show
+func cleanStatus(status, note string) (string, string) {
+    switch status {
+    case StatusAway, StatusBusy:
+    default:
+        status = StatusOnline
+    }
+    note = strings.Join(strings.Fields(note), " ")
+    if r := []rune(note); len(r) > maxStatusNote {
+        note = string(r[:maxStatusNote])
+    }
+    return status, note
+}
+
+// presenceText is how a status and note are passed to the TUI
+func presenceText(status, note string) string {
+    if note == "" {
+        return status
+    }
+    return status + ": " + note
+}
+
+// SetPresence sets the local user's status and note and tells every peer.
+// Peers that connect later are told when they join.
+func (cr *ChatRoom) SetPresence(from, status, note string) {
+    status, note = cleanStatus(status, note)
+    cr.presenceMu.Lock()
+    cr.status, cr.statusNote = status, note
+    cr.presenceMu.Unlock()
+    Broadcast(cr, NewPresence(from, status, note))
+}
+
+// Presence returns the local user's status and note
+func (cr *ChatRoom) Presence() (string, string) {
+    cr.presenceMu.Lock()
+    defer cr.presenceMu.Unlock()
+    if cr.status == "" {
+        return StatusOnline, ""
+    }
+    return cr.status, cr.statusNote
+}
+
+// greetPresence tells a peer that just joined our status, unless there is
+// nothing to tell
+func (cr *ChatRoom) greetPresence(peer *Peer, from string) {
+    status, note := cr.Presence()
+    if status == StatusOnline && note == "" {
+        return
+    }
+    env := NewPresence(from, status, note)
+    if cr.runOutgoing(&env) {
+        peer.Send(env)
+    }
+}
+
+func (cr *ChatRoom) handlePresence(peer *Peer, env Envelope) {
+    status, note := cleanStatus(env.Status, env.Text)
+    peer.mu.Lock()
+    peer.status, peer.statusNote = status, note
+    peer.mu.Unlock()
+    cr.notify(tui.Message{Kind: tui.KindPresence, From: peer.Name, Text: presenceText(status, note)})
+}
+
+func (cr *ChatRoom) handleTyping(peer *Peer, env Envelope) {
+    if cr.hidden(peer.Name, peer.NodeID) {
+        return
+    }
+    room := env.Room
+    if room == DefaultRoom {
+        room = ""
+    }
+    cr.notify(tui.Message{Kind: tui.KindTyping, From: peer.Name, Room: room})
+}
@@ -0,0 +1,75 @@
+package chat
+
+import (
+	"strings"
+	"unicode"
+
+	"gochat/internal/tui"
+)
+
+// Longest reaction accepted, in bytes. Enough for an emoji with skin tone
+// and joiners, or a short code nobody has a picture for.
+const maxReaction = 32
+
+// shortCodes are the :codes: turned into emoji when reacting
+var shortCodes = map[string]string{
+    ":+1:": "👍",
+    ":thumbsup:": "👍",
+    ":-1:": "👎",
+    ":thumbsdown:": "👎",
+    ":heart:": "❤️",
+    ":joy:": "😂",
+    ":laughing:": "😆",
+    ":smile:": "😄",
+    ":tada:": "🎉",
+    ":open_mouth:": "😮",
+    ":eyes:": "👀",
+    ":cry:": "😢",
+    ":fire:": "🔥",
+    ":rocket:": "🚀",
+    ":pray:": "🙏",
+    ":ok_hand:": "👌",
+    ":clap:": "👏",
+    ":100:": "💯",
+    ":white_check_mark:": "✅",
+    ":x:": "❌",
+    ":thinking:": "🤔",
+}
+
+// Reaction turns a short code such as :+1: into its emoji. Anything else
+// is returned as it is, and ok is false if it cannot be a reaction.
+func Reaction(code string) (emoji string, ok bool) {
+    code = strings.TrimSpace(code)
+    if e, found := shortCodes[strings.ToLower(code)]; found {
+        return e, true
+    }
+    if code == "" || len(code) > maxReaction || strings.IndexFunc(code, unicode.IsSpace) >= 0 {
+        return code, false
+    }
+    return code, true
+}
+
+// handleReaction passes on a reaction from peer. Anyone may react to any
+// message. Reactions are counted by sender, so taking one back only undoes
+// that sender's; the sender is always the peer the frame came from, never
+// the name it claims, so no one can react or unreact for someone else.
+func (cr *ChatRoom) handleReaction(peer *Peer, env Envelope) {
+    emoji, ok := Reaction(env.Text)
+    if !ok || env.ID == "" {
+        peer.log.Warn("ignoring malformed reaction", "id", env.ID)
+        return
+    }
+    sender := peer.Name
+    if cr.hidden(peer.Name, peer.NodeID) {
+        return
+    }
+    room := env.Room
+    if room == DefaultRoom {
+        room = ""
+    }
+    kind := tui.KindReact
+    if env.Type == TypeUnreact {
+        kind = tui.KindUnreact
+    }
+    cr.notify(tui.Message{Kind: kind, ID: env.ID, From: sender, Text: emoji, Room: room})
+}
@@ -0,0 +1,254 @@
+package chat
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+
+	"gochat/internal/tui"
+)
+
+// Receipt states of a message sent to one peer, in the order they advance
+const (
+    ReceiptQueued = "queued" // kept until the peer, which is away, comes back
+    ReceiptSent = "sent" // queued for the peer, not acknowledged yet
+    ReceiptDelivered = "delivered" // the peer acknowledged it
+    ReceiptRead = "read" // the peer's user has had it on screen
+    ReceiptFailed = "failed" // could not be queued, or the peer left before acknowledging
+)
+
+var receiptRank = map[string]int{ReceiptFailed: 0, ReceiptQueued: 1, ReceiptSent: 2, ReceiptDelivered: 3, ReceiptRead: 4}
+
+// Messages sent by this node whose receipts are remembered
+const maxReceipts = 1000
+
+// NewAck builds an envelope acknowledging delivery of message id
+func NewAck(id string) Envelope {
+    return Envelope{Type: TypeAck, ID: id}
+}
+
+// NewRead builds an envelope saying message id has been read
+func NewRead(id string) Envelope {
+    return Envelope{Type: TypeRead, ID: id}
+}
+
+// sentMessage is a chat message this node sent and how far it got with
+// each peer
+type sentMessage struct {
+    env Envelope // as sent, for retrying
+    room string // env.Room as the TUI names it
+    peers map[string]peerReceipt // by receiptKey, so peers sharing a name stay apart
+}
+
+// peerReceipt is how far a message got with one peer
+type peerReceipt struct {
+    name string
+    state string
+}
+
+// label names the peer with key for the user: by name, unless another
+// peer the message went to has the same one
+func (m *sentMessage) label(key string) string {
+    name := m.peers[key].name
+    for k, r := range m.peers {
+        if k != key && r.name == name {
+            short := key
+            if _, _, err := net.SplitHostPort(key); err != nil && len(short) > 8 {
+                short = short[:8]
+            }
+            return name + " (" + short + ")"
+        }
+    }
+    return name
+}
+
+// receiptChange is a receipt that moved, as the TUI is told of it
+type receiptChange struct {
+    id, room, peer string
+}
+
+// receiptLog tracks receipts for recent messages sent by this node
+type receiptLog struct {
+    mu sync.Mutex
+    byID map[string]*sentMessage
+    order []string // oldest first, for eviction
+}
+
+func newReceiptLog() *receiptLog {
+    return &receiptLog{byID: make(map[string]*sentMessage)}
+}
+
+func (l *receiptLog) add(env Envelope, peers map[string]peerReceipt) {
+    if env.ID == "" {
+        return
+    }
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    if _, ok := l.byID[env.ID]; !ok {
+        l.order = append(l.order, env.ID)
+    }
+    room := env.Room
+    if room == DefaultRoom {
+        room = ""
+    }
+    l.byID[env.ID] = &sentMessage{env: env, room: room, peers: peers}
+    if len(l.order) > maxReceipts {
+        delete(l.byID, l.order[0])
+        l.order = l.order[1:]
+    }
+}
+
+// set moves the receipt for id of the peer with key to state. Receipts only
+// advance, except that a failed one may start over; ok is false if nothing
+// changed.
+func (l *receiptLog) set(id, key, state string) (c receiptChange, ok bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    m, found := l.byID[id]
+    if !found {
+        return c, false
+    }
+    old, known := m.peers[key]
+    if !known || (old.state != ReceiptFailed && receiptRank[state] <= receiptRank[old.state]) {
+        return c, false
+    }
+    m.peers[key] = peerReceipt{name: old.name, state: state}
+    return receiptChange{id: id, room: m.room, peer: m.label(key)}, true
+}
+
+// fail marks every message whose receipt for the peer with key is in
+// state as failed, and returns what changed
+func (l *receiptLog) fail(key, state string) []receiptChange {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    var out []receiptChange
+    for id, m := range l.byID {
+        if r, ok := m.peers[key]; ok && r.state == state {
+            m.peers[key] = peerReceipt{name: r.name, state: ReceiptFailed}
+            out = append(out, receiptChange{id: id, room: m.room, peer: m.label(key)})
+        }
+    }
+    return out
+}
+
+func (l *receiptLog) get(id string) (*sentMessage, bool) {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    m, ok := l.byID[id]
+    if !ok {
+        return nil, false
+    }
+    return &sentMessage{env: m.env, room: m.room, peers: maps.Clone(m.peers)}, true
+}
+
+// Receipts returns how far message id, sent by this node, got with each
+// peer it was sent to, by peer name. ok is false for messages it does not
+// know.
+func (cr *ChatRoom) Receipts(id string) (receipts map[string]string, ok bool) {
+    m, ok := cr.receipts.get(id)
+    if !ok {
+        return nil, false
+    }
+    receipts = make(map[string]string, len(m.peers))
+    for key, r := range m.peers {
+        receipts[m.label(key)] = r.state
+    }
+    return receipts, true
+}
+
+// Retry sends message id again to the peers it failed to reach that are
+// connected now
+func (cr *ChatRoom) Retry(id string) error {
+    m, ok := cr.receipts.get(id)
+    if !ok {
+        return fmt.Errorf("no message %s of yours to retry", id)
+    }
+    var missing []string
+    for key, r := range m.peers {
+        if r.state != ReceiptFailed {
+            continue
+        }
+        peer := cr.findPeerByReceiptKey(key)
+        if peer == nil || peer.Send(m.env) != nil {
+            missing = append(missing, m.label(key))
+            continue
+        }
+        cr.setReceipt(id, key, ReceiptSent)
+    }
+    if len(missing) > 0 {
+        slices.Sort(missing)
+        return fmt.Errorf("could not send to %s", strings.Join(missing, ", "))
+    }
+    return nil
+}
+
+// MarkRead tells whoever sent message id that the local user has read it
+func (cr *ChatRoom) MarkRead(id string) {
+    author, ok := cr.authors.author(id)
+    if !ok {
+        return
+    }
+    if peer := cr.FindPeerByName(author); peer != nil {
+        peer.Send(NewRead(id))
+    }
+}
+
+// receiptKey tells peers apart in receipts: by node ID, or failing that
+// by the address they connect from
+func (p *Peer) receiptKey() string {
+    if p.NodeID != "" {
+        return p.NodeID
+    }
+    if a := p.Conn.RemoteAddr(); a != nil {
+        if _, _, err := net.SplitHostPort(a.String()); err == nil {
+            return a.String()
+        }
+    }
+    // In-memory connections have no address to go by
+    return p.uuid
+}
+
+func (cr *ChatRoom) findPeerByReceiptKey(key string) *Peer {
+    cr.mu.Lock()
+    defer cr.mu.Unlock()
+    for _, p := range cr.Peers {
+        if p.receiptKey() == key {
+            return p
+        }
+    }
+    return nil
+}
+
+// setReceipt records a receipt for the peer with key and tells the TUI if
+// it changed anything
+func (cr *ChatRoom) setReceipt(id, key, state string) {
+    c, ok := cr.receipts.set(id, key, state)
+    if !ok {
+        return
+    }
+    cr.notify(tui.Message{Kind: tui.KindReceipt, ID: c.id, From: c.peer, Text: state, Room: c.room})
+}
+
+// failReceipts marks messages whose receipt for the peer with key is in
+// state as failed
+func (cr *ChatRoom) failReceipts(key, state string) {
+    for _, c := range cr.receipts.fail(key, state) {
+        cr.notify(tui.Message{Kind: tui.KindReceipt, ID: c.id, From: c.peer, Text: ReceiptFailed, Room: c.room})
+    }
+}
+
+func (cr *ChatRoom) handleReceipt(peer *Peer, env Envelope) {
+    state := ReceiptDelivered
+    if env.Type == TypeRead {
+        state = ReceiptRead
+    }
+    cr.setReceipt(env.ID, peer.receiptKey(), state)
+}
+
+// failUnacked marks messages a departing peer never acknowledged as failed
+func (cr *ChatRoom) failUnacked(peer *Peer) {
+    cr.failReceipts(peer.receiptKey(), ReceiptSent)
+}
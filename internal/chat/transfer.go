@@ -0,0 +1,536 @@
+package chat
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+    "github.com/google/uuid"
+    "gochat/internal/tui"
+)
+
+// Size of each file_chunk frame. Small enough that a chat frame queued
+// behind a chunk is never held up for long.
+const chunkSize = 32 * 1024
+
+type transfer struct {
+    mu sync.Mutex
+    id string
+    peer string // name of the remote peer
+    name string // file name as offered
+    size int64
+    sum string // hex SHA-256 of the whole file
+    incoming bool
+    // Outgoing: the source file. Incoming: the partial file in the
+    // downloads directory, set once accepted.
+    path string
+    accepted bool
+    received int64
+    file *os.File
+    gen int // bumped on every accept so a stale sender goroutine stops
+    lastPct int
+}
+
+func (t *transfer) offer() Envelope {
+    return Envelope{Type: TypeFileOffer, ID: t.id, Name: t.name, Size: t.size, SHA256: t.sum}
+}
+
+type transferSet struct {
+    mu sync.Mutex
+    dir string
+    byID map[string]*transfer
+}
+
+func newTransferSet() *transferSet {
+    return &transferSet{
+        dir: "downloads",
+        byID: make(map[string]*transfer),
+    }
+}
+
+func (ts *transferSet) setDir(dir string) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    ts.dir = dir
+}
+
+func (ts *transferSet) downloadDir() string {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    return ts.dir
+}
+
+func (ts *transferSet) add(t *transfer) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    ts.byID[t.id] = t
+}
+
+func (ts *transferSet) get(id string) *transfer {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    return ts.byID[id]
+}
+
+func (ts *transferSet) remove(id string) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+    delete(ts.byID, id)
+}
+
+// lookup finds a transfer by its full ID or a unique prefix of it, so users
+// can type the short form shown in the TUI.
+func (ts *transferSet) lookup(prefix string) (*transfer, error) {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+
+    if t, ok := ts.byID[prefix]; ok {
+        return t, nil
+    }
+    var found *transfer
+    for id, t := range ts.byID {
+        if strings.HasPrefix(id, prefix) {
+            if found != nil {
+                return nil, fmt.Errorf("transfer ID %q is ambiguous", prefix)
+            }
+            found = t
+        }
+    }
+    if found == nil {
+        return nil, fmt.Errorf("no transfer with ID %q", prefix)
+    }
+    return found, nil
+}
+
+// matching returns all transfers for which keep reports true
+func (ts *transferSet) matching(keep func(*transfer) bool) []*transfer {
+    ts.mu.Lock()
+    defer ts.mu.Unlock()
+
+    var out []*transfer
+    for _, t := range ts.byID {
+        if keep(t) {
+            out = append(out, t)
+        }
+    }
+    return out
+}
+
+func (ts *transferSet) closeAll() {
+    for _, t := range ts.matching(func(*transfer) bool { return true }) {
+        t.mu.Lock()
+        if t.file != nil {
+            t.file.Close()
+            t.file = nil
+        }
+        t.mu.Unlock()
+    }
+}
+
+// SendFile offers the file at path to the named peer. Nothing is sent until
+// the peer accepts the offer.
+func (cr *ChatRoom) SendFile(peerName, path string) error {
+    peer := cr.FindPeerByName(peerName)
+    if peer == nil {
+        return fmt.Errorf("no peer named %q", peerName)
+    }
+    sum, size, err := hashFile(path)
+    if err != nil {
+        return err
+    }
+
+    t := &transfer{
+        id: uuid.NewString(),
+        peer: peerName,
+        name: filepath.Base(path),
+        size: size,
+        sum: sum,
+        path: path,
+        lastPct: -1,
+    }
+    cr.files.add(t)
+    if err := peer.Send(t.offer()); err != nil {
+        cr.files.remove(t.id)
+        return fmt.Errorf("failed to offer %s to %s: %w", t.name, peerName, err)
+    }
+    cr.log.Info("file offered", "transfer", t.id, "peer", peerName, "path", path, "size", size)
+    cr.systemf("Offered %s (%s) to %s", t.name, formatSize(size), peerName)
+    return nil
+}
+
+// AcceptFile accepts a pending incoming offer by ID or ID prefix
+func (cr *ChatRoom) AcceptFile(id string) error {
+    t, err := cr.files.lookup(id)
+    if err != nil {
+        return err
+    }
+    if !t.incoming {
+        return fmt.Errorf("transfer %s is not an incoming offer", shortID(t.id))
+    }
+    peer := cr.FindPeerByName(t.peer)
+    if peer == nil {
+        return fmt.Errorf("%s is no longer connected", t.peer)
+    }
+    return cr.acceptTransfer(peer, t)
+}
+
+// DeclineFile turns down a pending incoming offer by ID or ID prefix
+func (cr *ChatRoom) DeclineFile(id string) error {
+    t, err := cr.files.lookup(id)
+    if err != nil {
+        return err
+    }
+    if !t.incoming {
+        return fmt.Errorf("transfer %s is not an incoming offer", shortID(t.id))
+    }
+    t.mu.Lock()
+    accepted := t.accepted
+    t.mu.Unlock()
+    if accepted {
+        return fmt.Errorf("transfer %s is already in progress", shortID(t.id))
+    }
+
+    cr.files.remove(t.id)
+    cr.clearProgress(t)
+    if peer := cr.FindPeerByName(t.peer); peer != nil {
+        _ = peer.Send(Envelope{Type: TypeFileDecline, ID: t.id})
+    }
+    cr.systemf("Declined %s from %s", t.name, t.peer)
+    return nil
+}
+
+// acceptTransfer opens (or reopens) the partial file and asks the sender to
+// start streaming from wherever we got to.
+func (cr *ChatRoom) acceptTransfer(peer *Peer, t *transfer) error {
+    dir := cr.files.downloadDir()
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return fmt.Errorf("cannot create downloads directory: %w", err)
+    }
+
+    t.mu.Lock()
+    if t.file == nil {
+        t.path = partPath(dir, t.id)
+        f, err := os.OpenFile(t.path, os.O_CREATE|os.O_WRONLY, 0o644)
+        if err != nil {
+            t.mu.Unlock()
+            return fmt.Errorf("cannot create %s: %w", t.path, err)
+        }
+        t.file = f
+    }
+    offset := int64(0)
+    if info, err := t.file.Stat(); err == nil && info.Size() <= t.size {
+        offset = info.Size()
+    }
+    t.received = offset
+    t.accepted = true
+    t.mu.Unlock()
+
+    if err := peer.Send(Envelope{Type: TypeFileAccept, ID: t.id, Offset: offset}); err != nil {
+        return fmt.Errorf("failed to accept %s: %w", t.name, err)
+    }
+    cr.reportProgress(t, offset)
+    return nil
+}
+
+// resumeTransfers re-offers everything still owed to a peer that has just
+// (re)connected. The receiver answers with the offset it already has.
+func (cr *ChatRoom) resumeTransfers(peer *Peer) {
+    pending := cr.files.matching(func(t *transfer) bool {
+        return !t.incoming && t.peer == peer.Name
+    })
+    for _, t := range pending {
+        _ = peer.Send(t.offer())
+    }
+}
+
+func (cr *ChatRoom) handleTransfer(peer *Peer, env Envelope) {
+    if _, err := uuid.Parse(env.ID); err != nil {
+        peer.log.Warn("ignoring transfer frame with bad ID", "type", env.Type, "id", env.ID)
+        return
+    }
+
+    if env.Type == TypeFileOffer {
+        cr.handleOffer(peer, env)
+        return
+    }
+
+    t := cr.files.get(env.ID)
+    if t == nil || t.peer != peer.Name {
+        return
+    }
+    switch env.Type {
+    case TypeFileAccept:
+        if t.incoming {
+            return
+        }
+        offset := env.Offset
+        if offset < 0 || offset > t.size {
+            offset = 0
+        }
+        t.mu.Lock()
+        t.gen++
+        gen := t.gen
+        t.mu.Unlock()
+        go cr.streamFile(peer, t, offset, gen)
+    case TypeFileDecline:
+        if t.incoming {
+            return
+        }
+        cr.files.remove(t.id)
+        cr.clearProgress(t)
+        cr.systemf("%s declined %s", peer.Name, t.name)
+    case TypeFileChunk:
+        if t.incoming {
+            cr.writeChunk(t, env)
+        }
+    case TypeFileDone:
+        if t.incoming {
+            cr.finishTransfer(t)
+        }
+    }
+}
+
+func (cr *ChatRoom) handleOffer(peer *Peer, env Envelope) {
+    name := filepath.Base(env.Name)
+    if name == "." || name == ".." || name == string(filepath.Separator) || env.Size < 0 || len(env.SHA256) != sha256.Size*2 {
+        peer.log.Warn("ignoring invalid file offer", "name", env.Name, "size", env.Size)
+        return
+    }
+
+    t := cr.files.get(env.ID)
+    if t != nil && (!t.incoming || t.peer != peer.Name) {
+        return
+    }
+    if t == nil {
+        t = &transfer{
+            id: env.ID,
+            peer: peer.Name,
+            name: name,
+            size: env.Size,
+            sum: env.SHA256,
+            incoming: true,
+            lastPct: -1,
+        }
+        // A partial file on disk means we accepted this before a restart
+        if _, err := os.Stat(partPath(cr.files.downloadDir(), t.id)); err == nil {
+            t.accepted = true
+        }
+        cr.files.add(t)
+    }
+
+    t.mu.Lock()
+    accepted := t.accepted
+    t.mu.Unlock()
+    if accepted {
+        if err := cr.acceptTransfer(peer, t); err != nil {
+            cr.systemf("Cannot resume %s: %v", t.name, err)
+        }
+        return
+    }
+
+    cr.notify(tui.Message{
+        Kind: tui.KindOffer,
+        ID: t.id,
+        From: peer.Name,
+        Text: fmt.Sprintf("%s (%s)", t.name, formatSize(t.size)),
+    })
+    cr.systemf("%s offers %s (%s). Type /accept %s or /decline %s", peer.Name, t.name, formatSize(t.size), shortID(t.id), shortID(t.id))
+}
+
+func (cr *ChatRoom) streamFile(peer *Peer, t *transfer, offset int64, gen int) {
+    f, err := os.Open(t.path)
+    if err != nil {
+        cr.systemf("Cannot read %s: %v", t.path, err)
+        return
+    }
+    defer f.Close()
+    if _, err := f.Seek(offset, io.SeekStart); err != nil {
+        cr.systemf("Cannot read %s: %v", t.path, err)
+        return
+    }
+
+    buf := make([]byte, chunkSize)
+    pos := offset
+    for {
+        t.mu.Lock()
+        stale := t.gen != gen
+        t.mu.Unlock()
+        if stale {
+            return
+        }
+
+        n, err := f.Read(buf)
+        if n > 0 {
+            if sendErr := peer.sendBulk(Envelope{Type: TypeFileChunk, ID: t.id, Offset: pos, Data: buf[:n]}); sendErr != nil {
+                // The peer went away. resumeTransfers re-offers when it returns.
+                cr.notifyProgress(t, fmt.Sprintf("⏸ %s to %s paused at %s", t.name, t.peer, formatSize(pos)))
+                return
+            }
+            pos += int64(n)
+            cr.reportProgress(t, pos)
+        }
+        if errors.Is(err, io.EOF) {
+            break
+        }
+        if err != nil {
+            cr.systemf("Cannot read %s: %v", t.path, err)
+            return
+        }
+    }
+
+    if err := peer.sendBulk(Envelope{Type: TypeFileDone, ID: t.id, SHA256: t.sum}); err != nil {
+        return
+    }
+    cr.files.remove(t.id)
+    cr.clearProgress(t)
+    cr.log.Info("file sent", "transfer", t.id, "peer", t.peer)
+    cr.systemf("Sent %s to %s", t.name, t.peer)
+}
+
+func (cr *ChatRoom) writeChunk(t *transfer, env Envelope) {
+    t.mu.Lock()
+    if !t.accepted || t.file == nil {
+        t.mu.Unlock()
+        return
+    }
+    if env.Offset < 0 || env.Offset+int64(len(env.Data)) > t.size {
+        t.mu.Unlock()
+        cr.log.Warn("ignoring out of range chunk", "transfer", t.id, "offset", env.Offset)
+        return
+    }
+    _, err := t.file.WriteAt(env.Data, env.Offset)
+    if err == nil {
+        t.received = env.Offset + int64(len(env.Data))
+    }
+    received := t.received
+    t.mu.Unlock()
+
+    if err != nil {
+        cr.systemf("Failed to write %s: %v", t.name, err)
+        return
+    }
+    cr.reportProgress(t, received)
+}
+
+// finishTransfer checks the SHA-256 of the completed file and moves it to
+// its final name in the downloads directory.
+func (cr *ChatRoom) finishTransfer(t *transfer) {
+    cr.files.remove(t.id)
+    cr.clearProgress(t)
+
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if t.file == nil {
+        return
+    }
+    t.file.Close()
+    t.file = nil
+
+    sum, size, err := hashFile(t.path)
+    if err != nil || sum != t.sum || size != t.size {
+        os.Remove(t.path)
+        cr.log.Warn("file failed integrity check", "transfer", t.id, "peer", t.peer, "want", t.sum, "got", sum)
+        cr.systemf("Transfer of %s from %s failed the integrity check, file discarded", t.name, t.peer)
+        return
+    }
+    dest := uniquePath(filepath.Dir(t.path), t.name)
+    if err := os.Rename(t.path, dest); err != nil {
+        cr.systemf("Failed to save %s: %v", t.name, err)
+        return
+    }
+    cr.log.Info("file received", "transfer", t.id, "peer", t.peer, "path", dest)
+    cr.systemf("Received %s from %s, saved to %s", t.name, t.peer, dest)
+}
+
+func (cr *ChatRoom) reportProgress(t *transfer, done int64) {
+    pct := 100
+    if t.size > 0 {
+        pct = int(done * 100 / t.size)
+    }
+    t.mu.Lock()
+    changed := pct != t.lastPct
+    t.lastPct = pct
+    t.mu.Unlock()
+    if !changed {
+        return
+    }
+
+    arrow, dir := "↑", "to"
+    if t.incoming {
+        arrow, dir = "↓", "from"
+    }
+    cr.notifyProgress(t, fmt.Sprintf("%s %s %s %s %3d%% (%s / %s)", arrow, t.name, dir, t.peer, pct, formatSize(done), formatSize(t.size)))
+}
+
+func (cr *ChatRoom) notifyProgress(t *transfer, text string) {
+    cr.notify(tui.Message{Kind: tui.KindProgress, ID: t.id, From: t.peer, Text: text})
+}
+
+func (cr *ChatRoom) clearProgress(t *transfer) {
+    cr.notifyProgress(t, "")
+}
+
+func hashFile(path string) (string, int64, error) {
+    f, err := os.Open(path)
+    if err != nil {
+        return "", 0, err
+    }
+    defer f.Close()
+
+    info, err := f.Stat()
+    if err != nil {
+        return "", 0, err
+    }
+    if !info.Mode().IsRegular() {
+        return "", 0, fmt.Errorf("%s is not a regular file", path)
+    }
+    h := sha256.New()
+    n, err := io.Copy(h, f)
+    if err != nil {
+        return "", 0, err
+    }
+    return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+func partPath(dir, id string) string {
+    return filepath.Join(dir, "."+id+".part")
+}
+
+// uniquePath returns dir/name, or "name (n).ext" if that is already taken
+func uniquePath(dir, name string) string {
+    p := filepath.Join(dir, name)
+    ext := filepath.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    for i := 1; ; i++ {
+        if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+            return p
+        }
+        p = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", base, i, ext))
+    }
+}
+
+func shortID(id string) string {
+    if len(id) > 8 {
+        return id[:8]
+    }
+    return id
+}
+
+func formatSize(n int64) string {
+    const unit = 1024
+    if n < unit {
+        return fmt.Sprintf("%d B", n)
+    }
+    div, exp := int64(unit), 0
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,149 @@
+package chat
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gochat/internal/tui"
+)
+
+// connectRooms links two rooms over a loopback TCP connection
+func connectRooms(t *testing.T, ctx context.Context, a *ChatRoom, aName string, b *ChatRoom, bName string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		PeerHandler(ctx, conn, bName, b)
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go PeerHandler(ctx, conn, aName, a)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for a.FindPeerByName(bName) == nil || b.FindPeerByName(aName) == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("Timeout waiting for handshake")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// waitFor reads messages until one satisfies match
+func waitFor(t *testing.T, ch <-chan tui.Message, match func(tui.Message) bool) tui.Message {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-ch:
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatal("Timeout waiting for message")
+		}
+	}
+}
+
+func TestFileTransfer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	aliceMsgs := make(chan tui.Message, 1000)
+	bobMsgs := make(chan tui.Message, 1000)
+	alice.SetTUIMessageChannel(aliceMsgs)
+	bob.SetTUIMessageChannel(bobMsgs)
+	downloads := t.TempDir()
+	bob.SetDownloadDir(downloads)
+
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	// Several chunks worth of data so the transfer is actually chunked
+	content := bytes.Repeat([]byte("0123456789abcdef"), chunkSize/4)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	src := filepath.Join(home, "build.log")
+	if err := os.WriteFile(src, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths under ~ as completion inserts them
+	if err := alice.RunCommand("/send bob ~/build.log"); err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+	offer := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindOffer })
+	if offer.From != "alice" || !strings.Contains(offer.Text, "build.log") {
+		t.Fatalf("unexpected offer: %+v", offer)
+	}
+
+	// Pretend half the file arrived before a disconnect so accept resumes
+	half := content[:len(content)/2]
+	if err := os.WriteFile(partPath(downloads, offer.ID), half, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bob.RunCommand("/accept " + offer.ID[:8]); err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	waitFor(t, bobMsgs, func(m tui.Message) bool { return strings.HasPrefix(m.Text, "Received build.log") })
+
+	got, err := os.ReadFile(filepath.Join(downloads, "build.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Received file differs from source (%d vs %d bytes)", len(got), len(content))
+	}
+}
+
+func TestFileTransferDecline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	alice, bob := NewRoom(), NewRoom()
+	defer alice.Shutdown()
+	defer bob.Shutdown()
+	aliceMsgs := make(chan tui.Message, 100)
+	bobMsgs := make(chan tui.Message, 100)
+	alice.SetTUIMessageChannel(aliceMsgs)
+	bob.SetTUIMessageChannel(bobMsgs)
+	bob.SetDownloadDir(t.TempDir())
+
+	connectRooms(t, ctx, alice, "alice", bob, "bob")
+
+	src := filepath.Join(t.TempDir(), "notes.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.SendFile("bob", src); err != nil {
+		t.Fatal(err)
+	}
+	offer := waitFor(t, bobMsgs, func(m tui.Message) bool { return m.Kind == tui.KindOffer })
+	if err := bob.DeclineFile(offer.ID); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, aliceMsgs, func(m tui.Message) bool { return m.Text == "bob declined notes.txt" })
+
+	if err := alice.SendFile("carol", src); err == nil {
+		t.Error("Expected error sending to unknown peer")
+	}
+}
@@ -1,41 +1,666 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"gochat/internal/tui"
+	"gochat/internal/util"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
 ) 
 
+// Idle time before the status turns to away in the TUI, if not set. Other
+// modes have no user to be idle, so they never turn away unless told to.
+const DefaultAwayAfter = 10 * time.Minute
+
 type Config struct { 
     Port int; // server port which the server will listen on
     Peers []string; // list of peer addresses host:port
     Name string; // name of the node
+    Downloads string; // directory where received files are saved
+    LogLevel util.LogLevel; // records below this level are dropped
+    LogFile string; // where the log is written, rotated by size
+    Profile string; // config file profile in use, empty if none
+    Theme string; // TUI colour scheme
+    DataDir string; // where node state such as history is kept
+    TLS TLS; // certificate settings, TLS is off unless a cert is set
+    Socket string; // control socket of the daemon
+    Pipe bool; // line-oriented stdin/stdout mode instead of the TUI
+    Format string; // pipe output format, plain or json
+    Webhooks []Webhook; // outgoing notifications, config file only
+    HTTP HTTP; // incoming HTTP endpoint, off unless Listen is set
+    IRC IRC; // IRC channel relayed by gochat bridge
+    Hooks Hooks; // built-in message filters, config file only
+    Scripts string; // directory of Lua automation scripts
+    Highlights []string; // words that make a chat line stand out in the TUI
+    Raw bool; // show chat text as typed instead of rendering Markdown
+    AwayAfter time.Duration; // idle time before the status turns to away, 0 for never
+    AwayAfterSet bool; // AwayAfter was given rather than left to the mode's default
+    ReadReceipts bool; // tell senders when their messages have been on screen
+    OfflineTTL time.Duration; // how long messages wait for absent peers, 0 to not keep them
+    Ignore []string; // peer names or node IDs whose messages are hidden
+    Mute map[string]time.Time; // peer names or node IDs hidden until a time, config file only
+    Block []string; // node IDs or addresses refused at the handshake
+    RequireIdentity bool; // refuse peers that connect without a node ID
+    File string; // config file the profile comes from, and where /ignore and friends save to
+}
+
+type TLS struct {
+    Cert string `toml:"cert"` // PEM certificate presented to peers
+    Key string `toml:"key"` // PEM private key for Cert
+    CA string `toml:"ca"` // PEM bundle peers must chain to; system roots if empty
+}
+
+func (t TLS) Enabled() bool {
+    return t.Cert != ""
+}
+
+// HTTP is the optional local endpoint other tools post messages through
+type HTTP struct {
+    Listen string `toml:"listen"` // host:port, empty to disable
+    Token string `toml:"token"` // bearer token every request must carry
+    Name string `toml:"name"` // sender name for posted messages
+}
+
+func (h HTTP) Enabled() bool {
+    return h.Listen != ""
+}
+
+// IRC is the channel a bridge relays to and from a gochat room
+type IRC struct {
+    Server string `toml:"server"` // host:port
+    TLS bool `toml:"tls"`
+    Nick string `toml:"nick"` // defaults to the node name
+    Password string `toml:"password"` // server password, sent with PASS
+    Channel string `toml:"channel"`
+    Room string `toml:"room"` // gochat room to relay, general by default
+}
+
+func (i IRC) Enabled() bool {
+    return i.Server != ""
+}
+
+// Hooks configures the built-in message hooks
+type Hooks struct {
+    Profanity []string `toml:"profanity"` // words masked in chat both ways
+    Links []LinkRule `toml:"links"` // rewrites applied to outgoing links
+    Replies []ReplyRule `toml:"replies"` // automatic answers to incoming chat
+}
+
+type LinkRule struct {
+    Prefix string `toml:"prefix"`
+    Replace string `toml:"replace"`
+}
+
+type ReplyRule struct {
+    Match string `toml:"match"` // regular expression
+    Reply string `toml:"reply"`
+}
+
+// Webhook events a rule can fire on
+const (
+    EventMention = "mention" // a message mentions one of Mentions with @
+    EventKeyword = "keyword" // a message contains one of Keywords
+    EventJoin = "join"
+    EventLeave = "leave"
+)
+
+// Webhook POSTs a JSON payload to URL for each matching event
+type Webhook struct {
+    URL string `toml:"url"`
+    Events []string `toml:"events"`
+    Keywords []string `toml:"keywords"` // matched as whole words, ignoring case
+    Mentions []string `toml:"mentions"` // names to watch for; the node's own name if empty
+}
+
+// File is the layout of config.toml. Each profile is a complete set of
+// settings, picked with -profile; unset fields keep their defaults.
+//
+//    default_profile = "work"
+//
+//    [profiles.work]
+//    name = "alice"
+//    port = 9001
+//    peers = ["10.0.0.7:9000"]
+//
+//    [profiles.work.tls]
+//    cert = "~/.config/gochat/alice.pem"
+//    key = "~/.config/gochat/alice-key.pem"
+//
+//    [[profiles.work.webhooks]]
+//    url = "https://hooks.example.com/gochat"
+//    events = ["mention", "keyword"]
+//    keywords = ["outage", "deploy"]
+type File struct {
+    DefaultProfile string `toml:"default_profile"`
+    Profiles map[string]Profile `toml:"profiles"`
+}
+
+type Profile struct {
+    Name string `toml:"name"`
+    Port int `toml:"port"`
+    Peers []string `toml:"peers"`
+    Theme string `toml:"theme"`
+    Highlights []string `toml:"highlights"`
+    Raw bool `toml:"raw"`
+    AwayAfter string `toml:"away_after"`
+    ReadReceipts *bool `toml:"read_receipts"` // unset means on
+    OfflineTTL string `toml:"offline_ttl"`
+    Ignore []string `toml:"ignore"`
+    Mute map[string]time.Time `toml:"mute"`
+    Block []string `toml:"block"`
+    RequireIdentity bool `toml:"require_identity"`
+    DataDir string `toml:"data_dir"`
+    Downloads string `toml:"downloads"`
+    LogLevel string `toml:"log_level"`
+    LogFile string `toml:"log_file"`
+    Socket string `toml:"socket"`
+    Scripts string `toml:"scripts"`
+    TLS TLS `toml:"tls"`
+    HTTP HTTP `toml:"http"`
+    IRC IRC `toml:"irc"`
+    Hooks Hooks `toml:"hooks"`
+    Webhooks []Webhook `toml:"webhooks"`
+}
+
+// values flattens the profile into flag-name keyed settings
+func (p Profile) values() map[string]string {
+    v := map[string]string{
+        "name": p.Name,
+        "peers": strings.Join(p.Peers, ","),
+        "theme": p.Theme,
+        "highlight": strings.Join(p.Highlights, ","),
+        "ignore": strings.Join(p.Ignore, ","),
+        "block": strings.Join(p.Block, ","),
+        "data-dir": p.DataDir,
+        "downloads": p.Downloads,
+        "log-level": p.LogLevel,
+        "log-file": p.LogFile,
+        "socket": p.Socket,
+        "scripts": p.Scripts,
+        "away-after": p.AwayAfter,
+        "offline-ttl": p.OfflineTTL,
+        "tls-cert": p.TLS.Cert,
+        "tls-key": p.TLS.Key,
+        "tls-ca": p.TLS.CA,
+        "http-listen": p.HTTP.Listen,
+        "http-token": p.HTTP.Token,
+        "http-name": p.HTTP.Name,
+        "irc-server": p.IRC.Server,
+        "irc-nick": p.IRC.Nick,
+        "irc-password": p.IRC.Password,
+        "irc-channel": p.IRC.Channel,
+        "irc-room": p.IRC.Room,
+    }
+    if p.IRC.TLS {
+        v["irc-tls"] = "true"
+    }
+    if p.Raw {
+        v["raw"] = "true"
+    }
+    if p.RequireIdentity {
+        v["require-identity"] = "true"
+    }
+    if p.ReadReceipts != nil {
+        v["read-receipts"] = strconv.FormatBool(*p.ReadReceipts)
+    }
+    if p.Port != 0 {
+        v["port"] = strconv.Itoa(p.Port)
+    }
+    return v
+}
+
+// Parse reads settings from the command line, the environment and the
+// config file. flag.ErrHelp is returned if -h was given.
+func Parse() (Config, error) {
+    return Load(os.Args[1:], os.Getenv)
+}
+
+// Load resolves settings with flags taking precedence over GOCHAT_*
+// environment variables, which take precedence over the config file.
+func Load(args []string, getenv func(string) string) (Config, error) {
+    fs := flag.NewFlagSet("gochat", flag.ContinueOnError)
+    fs.String("config", "", "Config file (default $XDG_CONFIG_HOME/gochat/config.toml)")
+    fs.String("profile", "", "Profile from the config file to use")
+    fs.String("port", "9000", "Port to listen on")
+    fs.String("peers", "", "Comma separated peer list")
+    fs.String("name", "", "Your chat name")
+    fs.String("downloads", "", "Directory for received files (default ~/Downloads/gochat)")
+    fs.String("log-level", "info", "Log level: debug, info, warning or error")
+    fs.String("log-file", "", "Log file (default $XDG_STATE_HOME/gochat/<name>.log)")
+    fs.String("theme", "default", "Colour theme: "+strings.Join(tui.ThemeNames(), ", "))
+    fs.String("highlight", "", "Comma separated words to highlight in chat, besides @name")
+    fs.String("ignore", "", "Comma separated peer names or node IDs whose messages are hidden")
+    fs.String("block", "", "Comma separated node IDs or addresses whose connections are refused")
+    fs.Bool("require-identity", false, "Refuse peers that connect without a node ID, such as bots and nodes with no data directory")
+    fs.Bool("raw", false, "Show chat as raw text instead of rendering Markdown")
+    fs.String("away-after", "", "Idle time before your status turns to away, 0 for never (default 10m in the TUI, never in other modes)")
+    fs.Bool("read-receipts", true, "Tell senders when their messages have been on screen")
+    fs.String("offline-ttl", "72h", "How long messages wait for peers that are away, ours and those held for others; 0 to not keep them")
+    fs.String("data-dir", "", "Directory for node state (default $XDG_DATA_HOME/gochat/<name>)")
+    fs.String("tls-cert", "", "PEM certificate; enables TLS")
+    fs.String("tls-key", "", "PEM private key for -tls-cert")
+    fs.String("tls-ca", "", "PEM CA bundle peers must chain to")
+    fs.String("socket", "", "Daemon control socket (default $XDG_RUNTIME_DIR/gochat/<name>.sock)")
+    fs.String("scripts", "", "Directory of Lua scripts (default $XDG_CONFIG_HOME/gochat/scripts)")
+    fs.String("http-listen", "", "Address for the incoming HTTP endpoint, e.g. 127.0.0.1:8080 (off by default)")
+    fs.String("http-token", "", "Bearer token required by the HTTP endpoint")
+    fs.String("http-name", "", "Sender name for messages posted over HTTP (default <name>-bot)")
+    fs.String("irc-server", "", "IRC server host:port for gochat bridge")
+    fs.Bool("irc-tls", false, "Connect to the IRC server over TLS")
+    fs.String("irc-nick", "", "IRC nick of the bridge (default the chat name)")
+    fs.String("irc-password", "", "IRC server password")
+    fs.String("irc-channel", "", "IRC channel to bridge, e.g. #team")
+    fs.String("irc-room", "general", "Room relayed to the IRC channel")
+    fs.Bool("pipe", false, "Read messages from stdin and write events to stdout instead of running the TUI")
+    fs.String("format", "plain", "Pipe output format: plain (sender<TAB>text) or json")
+
+    if err := fs.Parse(args); err != nil {
+        return Config{}, err
+    }
+    if fs.NArg() > 0 {
+        return Config{}, fmt.Errorf("unexpected argument %q", fs.Arg(0))
+    }
+
+    // Start from the defaults and layer file, environment and flags on top
+    values := make(map[string]string)
+    fs.VisitAll(func(f *flag.Flag) {
+        values[f.Name] = f.DefValue
+    })
+    explicit := make(map[string]string)
+    fs.VisitAll(func(f *flag.Flag) {
+        if env := getenv(envName(f.Name)); env != "" {
+            explicit[f.Name] = env
+        }
+    })
+    fs.Visit(func(f *flag.Flag) {
+        explicit[f.Name] = f.Value.String()
+    })
+
+    path, pathGiven := explicit["config"], true
+    if path == "" {
+        path, pathGiven = defaultConfigPath(getenv), false
+    }
+    profile, err := loadProfile(path, pathGiven, explicit["profile"])
+    if err != nil {
+        return Config{}, err
+    }
+    var webhooks []Webhook
+    var hooks Hooks
+    if profile != nil {
+        webhooks, hooks = profile.Webhooks, profile.Hooks
+        for k, v := range profile.values() {
+            if v != "" {
+                values[k] = v
+            }
+        }
+        values["profile"] = profile.name
+    }
+    for k, v := range explicit {
+        values[k] = v
+    }
+
+    cfg, err := build(values, webhooks, hooks, getenv)
+    if err != nil {
+        return cfg, err
+    }
+    cfg.File = path
+    if profile != nil {
+        cfg.Mute = profile.Mute
+    }
+    return cfg, nil
+}
+
+type namedProfile struct {
+    Profile
+    name string
+}
+
+// loadProfile picks the profile to use from the config file at path. A
+// missing file is fine unless the user pointed us at it explicitly.
+func loadProfile(path string, required bool, name string) (*namedProfile, error) {
+    var file File
+    md, err := toml.DecodeFile(path, &file)
+    if errors.Is(err, os.ErrNotExist) && !required {
+        if name != "" {
+            return nil, fmt.Errorf("profile %q requested but there is no config file at %s", name, path)
+        }
+        return nil, nil
+    }
+    if err != nil {
+        return nil, fmt.Errorf("config file %s: %w", path, err)
+    }
+    if undecoded := md.Undecoded(); len(undecoded) > 0 {
+        return nil, fmt.Errorf("config file %s: unknown setting %q", path, undecoded[0].String())
+    }
+
+    if name == "" {
+        name = file.DefaultProfile
+        if name == "" {
+            if _, ok := file.Profiles["default"]; !ok {
+                return nil, nil
+            }
+            name = "default"
+        }
+    }
+    p, ok := file.Profiles[name]
+    if !ok {
+        known := make([]string, 0, len(file.Profiles))
+        for k := range file.Profiles {
+            known = append(known, k)
+        }
+        slices.Sort(known)
+        return nil, fmt.Errorf("config file %s has no profile %q (have: %s)", path, name, strings.Join(known, ", "))
+    }
+    return &namedProfile{Profile: p, name: name}, nil
 }
 
-func Parse() Config {
+// build validates the merged settings and turns them into a Config
+func build(v map[string]string, webhooks []Webhook, hooks Hooks, getenv func(string) string) (Config, error) {
+    var errs []error
+
+    name := strings.TrimSpace(v["name"])
+    switch {
+    case name == "":
+        errs = append(errs, fmt.Errorf("a chat name is required: use -name, GOCHAT_NAME or name in a config profile"))
+    case strings.ContainsAny(name, "\r\n\t"):
+        errs = append(errs, fmt.Errorf("name %q must not contain tabs or line breaks", name))
+    case len(name) > 32:
+        errs = append(errs, fmt.Errorf("name %q is longer than 32 characters", name))
+    }
+
+    port, err := strconv.Atoi(v["port"])
+    if err != nil || port < 1 || port > 65535 {
+        errs = append(errs, fmt.Errorf("port %q must be a number between 1 and 65535", v["port"]))
+    }
+
+    peers := SplitPeers(v["peers"])
+    for _, p := range peers {
+        if _, portStr, err := net.SplitHostPort(p); err != nil {
+            errs = append(errs, fmt.Errorf("peer %q is not a host:port address", p))
+        } else if n, err := strconv.Atoi(portStr); err != nil || n < 1 || n > 65535 {
+            errs = append(errs, fmt.Errorf("peer %q has an invalid port", p))
+        }
+    }
+
+    level, err := util.ParseLevel(v["log-level"])
+    if err != nil {
+        errs = append(errs, err)
+    }
+
+    if !slices.Contains(tui.ThemeNames(), v["theme"]) {
+        errs = append(errs, fmt.Errorf("unknown theme %q (want one of %s)", v["theme"], strings.Join(tui.ThemeNames(), ", ")))
+    }
+
+    tls := TLS{
+        Cert: expandHome(v["tls-cert"]),
+        Key: expandHome(v["tls-key"]),
+        CA: expandHome(v["tls-ca"]),
+    }
+    if (tls.Cert == "") != (tls.Key == "") {
+        errs = append(errs, fmt.Errorf("TLS needs both a certificate and a key"))
+    }
+    if tls.CA != "" && tls.Cert == "" {
+        errs = append(errs, fmt.Errorf("a TLS CA bundle was given without a certificate"))
+    }
+    for _, f := range []string{tls.Cert, tls.Key, tls.CA} {
+        if f == "" {
+            continue
+        }
+        if _, err := os.Stat(f); err != nil {
+            errs = append(errs, fmt.Errorf("TLS file %s: %w", f, err))
+        }
+    }
+
+    raw, err := strconv.ParseBool(v["raw"])
+    if err != nil {
+        errs = append(errs, fmt.Errorf("raw %q must be true or false", v["raw"]))
+    }
+
+    var awayAfter time.Duration
+    if v["away-after"] != "" {
+        awayAfter, err = time.ParseDuration(v["away-after"])
+        if err != nil || awayAfter < 0 {
+            errs = append(errs, fmt.Errorf("away-after %q must be a duration such as 10m, or 0", v["away-after"]))
+        }
+    }
+
+    offlineTTL, err := time.ParseDuration(v["offline-ttl"])
+    if err != nil || offlineTTL < 0 {
+        errs = append(errs, fmt.Errorf("offline-ttl %q must be a duration such as 72h, or 0", v["offline-ttl"]))
+    }
+
+    readReceipts, err := strconv.ParseBool(v["read-receipts"])
+    if err != nil {
+        errs = append(errs, fmt.Errorf("read-receipts %q must be true or false", v["read-receipts"]))
+    }
+
+    requireIdentity, err := strconv.ParseBool(v["require-identity"])
+    if err != nil {
+        errs = append(errs, fmt.Errorf("require-identity %q must be true or false", v["require-identity"]))
+    }
+
+    pipe, err := strconv.ParseBool(v["pipe"])
+    if err != nil {
+        errs = append(errs, fmt.Errorf("pipe %q must be true or false", v["pipe"]))
+    }
+    if v["format"] != "plain" && v["format"] != "json" {
+        errs = append(errs, fmt.Errorf("unknown format %q (want plain or json)", v["format"]))
+    }
+
+    httpConf := HTTP{
+        Listen: v["http-listen"],
+        Token: v["http-token"],
+        Name: strings.TrimSpace(v["http-name"]),
+    }
+    if httpConf.Enabled() {
+        if _, _, err := net.SplitHostPort(httpConf.Listen); err != nil {
+            errs = append(errs, fmt.Errorf("http listen address %q is not host:port", httpConf.Listen))
+        }
+        if len(httpConf.Token) < 16 {
+            errs = append(errs, fmt.Errorf("the HTTP endpoint needs a token of at least 16 characters: use -http-token or GOCHAT_HTTP_TOKEN"))
+        }
+    }
+    if httpConf.Name == "" {
+        httpConf.Name = name + "-bot"
+    }
+
+    ircConf := IRC{
+        Server: v["irc-server"],
+        Nick: strings.TrimSpace(v["irc-nick"]),
+        Password: v["irc-password"],
+        Channel: v["irc-channel"],
+        Room: v["irc-room"],
+    }
+    if ircConf.TLS, err = strconv.ParseBool(v["irc-tls"]); err != nil {
+        errs = append(errs, fmt.Errorf("irc-tls %q must be true or false", v["irc-tls"]))
+    }
+    if ircConf.Enabled() {
+        if _, _, err := net.SplitHostPort(ircConf.Server); err != nil {
+            errs = append(errs, fmt.Errorf("IRC server %q is not host:port", ircConf.Server))
+        }
+        if !strings.HasPrefix(ircConf.Channel, "#") && !strings.HasPrefix(ircConf.Channel, "&") {
+            errs = append(errs, fmt.Errorf("IRC channel %q must start with # or &", ircConf.Channel))
+        }
+        if strings.ContainsAny(ircConf.Channel, " ,\x07") {
+            errs = append(errs, fmt.Errorf("IRC channel %q contains a space, comma or bell", ircConf.Channel))
+        }
+        if ircConf.Nick == "" {
+            ircConf.Nick = name
+        }
+        if strings.ContainsAny(ircConf.Nick, " ,*?!@") {
+            errs = append(errs, fmt.Errorf("IRC nick %q contains characters IRC does not allow", ircConf.Nick))
+        }
+    }
 
-    port := flag.Int("port", 9000, "Port to listen on")
-    peers := flag.String("peers", "", "Comma separated peer list")
-    name := flag.String("name", "", "Your chat name")
+    for i, w := range webhooks {
+        errs = append(errs, checkWebhook(i, w)...)
+    }
+    for _, w := range hooks.Profanity {
+        if strings.TrimSpace(w) == "" {
+            errs = append(errs, fmt.Errorf("hooks: empty profanity word"))
+        }
+    }
+    for i, l := range hooks.Links {
+        if l.Prefix == "" {
+            errs = append(errs, fmt.Errorf("hooks: link rule %d has no prefix", i+1))
+        }
+    }
+    for i, r := range hooks.Replies {
+        if _, err := regexp.Compile(r.Match); err != nil || r.Match == "" {
+            errs = append(errs, fmt.Errorf("hooks: reply rule %d: match %q is not a valid regular expression", i+1, r.Match))
+        }
+        if strings.TrimSpace(r.Reply) == "" {
+            errs = append(errs, fmt.Errorf("hooks: reply rule %d has no reply", i+1))
+        }
+    }
 
-    flag.Parse()
-    if *name == "" {
-       flag.Usage();
-        os.Exit(1);
+    if len(errs) > 0 {
+        return Config{}, errors.Join(errs...)
     }
 
-    return Config {
-        Port: *port,
-        Peers: SplitPeers(*peers),
-        Name: *name,
+    cfg := Config{
+        Port: port,
+        Peers: peers,
+        Name: name,
+        Downloads: expandHome(v["downloads"]),
+        LogLevel: level,
+        LogFile: expandHome(v["log-file"]),
+        Profile: v["profile"],
+        Theme: v["theme"],
+        DataDir: expandHome(v["data-dir"]),
+        TLS: tls,
+        Socket: expandHome(v["socket"]),
+        Pipe: pipe,
+        Format: v["format"],
+        Webhooks: webhooks,
+        HTTP: httpConf,
+        IRC: ircConf,
+        Hooks: hooks,
+        Scripts: expandHome(v["scripts"]),
+        Highlights: splitList(v["highlight"]),
+        Ignore: splitList(v["ignore"]),
+        Block: splitList(v["block"]),
+        RequireIdentity: requireIdentity,
+        Raw: raw,
+        AwayAfter: awayAfter,
+        AwayAfterSet: v["away-after"] != "",
+        ReadReceipts: readReceipts,
+        OfflineTTL: offlineTTL,
+    }
+    if cfg.Downloads == "" {
+        cfg.Downloads = defaultDownloads()
+    }
+    if cfg.LogFile == "" {
+        cfg.LogFile = filepath.Join(xdgDir(getenv, "XDG_STATE_HOME", ".local/state"), name+".log")
+    }
+    if cfg.DataDir == "" {
+        cfg.DataDir = filepath.Join(xdgDir(getenv, "XDG_DATA_HOME", ".local/share"), name)
     }
+    if cfg.Scripts == "" {
+        cfg.Scripts = filepath.Join(xdgDir(getenv, "XDG_CONFIG_HOME", ".config"), "scripts")
+    }
+    if cfg.Socket == "" {
+        // The runtime dir is the right home for sockets but is not always set
+        if dir := getenv("XDG_RUNTIME_DIR"); dir != "" {
+            cfg.Socket = filepath.Join(dir, "gochat", name+".sock")
+        } else {
+            cfg.Socket = filepath.Join(cfg.DataDir, "control.sock")
+        }
+    }
+    return cfg, nil
+}
 
+func checkWebhook(i int, w Webhook) []error {
+    var errs []error
+    u, err := url.Parse(w.URL)
+    if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+        errs = append(errs, fmt.Errorf("webhook %d: url %q must be an http or https URL", i+1, w.URL))
+    }
+    if len(w.Events) == 0 {
+        errs = append(errs, fmt.Errorf("webhook %d: no events given (want any of mention, keyword, join, leave)", i+1))
+    }
+    for _, e := range w.Events {
+        switch e {
+        case EventMention, EventJoin, EventLeave:
+        case EventKeyword:
+            if len(w.Keywords) == 0 {
+                errs = append(errs, fmt.Errorf("webhook %d: keyword event needs keywords", i+1))
+            }
+        default:
+            errs = append(errs, fmt.Errorf("webhook %d: unknown event %q", i+1, e))
+        }
+    }
+    return errs
 }
 
 func SplitPeers(peers string) []string {
-    if peers == "" {
+    return splitList(peers)
+}
+
+// splitList splits a comma separated setting, dropping empty items
+func splitList(list string) []string {
+    if list == "" {
         return nil
     }
 
-    return strings.Split(peers, ",");
+    var out []string
+    for _, p := range strings.Split(list, ",") {
+        if p = strings.TrimSpace(p); p != "" {
+            out = append(out, p)
+        }
+    }
+    return out
+}
+
+// envName maps a flag name to its environment variable, e.g. log-level to
+// GOCHAT_LOG_LEVEL
+func envName(flagName string) string {
+    return "GOCHAT_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+func defaultConfigPath(getenv func(string) string) string {
+    return filepath.Join(xdgDir(getenv, "XDG_CONFIG_HOME", ".config"), "config.toml")
+}
+
+// xdgDir returns the gochat directory under an XDG base directory, falling
+// back to fallback under the home directory as the spec says
+func xdgDir(getenv func(string) string, env, fallback string) string {
+    if dir := getenv(env); dir != "" {
+        return filepath.Join(dir, "gochat")
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return filepath.Join(os.TempDir(), "gochat")
+    }
+    return filepath.Join(home, fallback, "gochat")
+}
+
+func expandHome(path string) string {
+    if path != "~" && !strings.HasPrefix(path, "~/") {
+        return path
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return path
+    }
+    return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
+func defaultDownloads() string {
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "downloads"
+    }
+    return filepath.Join(home, "Downloads", "gochat")
 }
@@ -0,0 +1,305 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+default_profile = "home"
+
+[profiles.home]
+name = "alice"
+port = 9100
+peers = ["127.0.0.1:9200", "127.0.0.1:9300"]
+theme = "light"
+
+[profiles.work]
+name = "alice-work"
+port = 9400
+log_level = "debug"
+`
+
+// testEnv builds a getenv func from pairs, pointing XDG dirs into dir
+func testEnv(dir string, pairs ...string) func(string) string {
+	env := map[string]string{
+		"XDG_CONFIG_HOME": dir,
+		"XDG_STATE_HOME":  filepath.Join(dir, "state"),
+		"XDG_DATA_HOME":   filepath.Join(dir, "data"),
+	}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		env[pairs[i]] = pairs[i+1]
+	}
+	return func(k string) string { return env[k] }
+}
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "gochat"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "gochat", "config.toml"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoadFlagsOnly(t *testing.T) {
+	cfg, err := Load([]string{"-name", "bob", "-port", "9002", "-peers", "127.0.0.1:9001"}, testEnv(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Name != "bob" || cfg.Port != 9002 || len(cfg.Peers) != 1 || cfg.Theme != "default" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if cfg.Profile != "" {
+		t.Errorf("Expected no profile without a config file, got %q", cfg.Profile)
+	}
+	if cfg.AwayAfter != 0 || cfg.AwayAfterSet {
+		t.Errorf("Expected away-after left to the mode, got %v", cfg.AwayAfter)
+	}
+	if !strings.HasSuffix(cfg.DataDir, filepath.Join("data", "gochat", "bob")) {
+		t.Errorf("Expected XDG data dir, got %s", cfg.DataDir)
+	}
+}
+
+func TestLoadFilterLists(t *testing.T) {
+	args := []string{"-name", "bob", "-ignore", "carol, dave", "-block", "10.0.0.5,ab12cd"}
+	cfg, err := Load(args, testEnv(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Ignore) != 2 || cfg.Ignore[1] != "dave" {
+		t.Errorf("Unexpected ignore list %q", cfg.Ignore)
+	}
+	if len(cfg.Block) != 2 || cfg.Block[0] != "10.0.0.5" {
+		t.Errorf("Unexpected block list %q", cfg.Block)
+	}
+	if cfg.RequireIdentity {
+		t.Error("Expected peers without an identity allowed by default")
+	}
+	cfg, err = Load(append(args, "-require-identity"), testEnv(t.TempDir()))
+	if err != nil || !cfg.RequireIdentity {
+		t.Errorf("Expected identities required, got %v, %v", cfg.RequireIdentity, err)
+	}
+}
+
+func TestSaveFilters(t *testing.T) {
+	dir := writeConfig(t, `default_profile = "home"
+
+[profiles.home]
+# who to leave out
+name = "alice"
+ignore = [
+  "carol",
+]
+block = ["10.0.0.5"]
+
+[profiles.work]
+name = "alice-work"
+`)
+	path := filepath.Join(dir, "gochat", "config.toml")
+	until := time.Date(2030, 1, 2, 15, 4, 5, 0, time.UTC)
+	if err := SaveFilters(path, "home", []string{"dave"}, nil, map[string]time.Time{"bob": until}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(nil, testEnv(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Ignore) != 1 || cfg.Ignore[0] != "dave" || len(cfg.Block) != 0 || !cfg.Mute["bob"].Equal(until) {
+		t.Errorf("Unexpected lists %q %q %v", cfg.Ignore, cfg.Block, cfg.Mute)
+	}
+	if cfg.File != path {
+		t.Errorf("Expected the config file recorded, got %q", cfg.File)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), "# who to leave out") || !strings.Contains(string(b), `name = "alice-work"`) {
+		t.Errorf("Expected the rest of the file kept:\n%s", b)
+	}
+
+	// Without a config file one is started with a default profile
+	dir = t.TempDir()
+	path = filepath.Join(dir, "gochat", "config.toml")
+	if err := SaveFilters(path, "default", nil, []string{"ab12"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = Load([]string{"-name", "bob"}, testEnv(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "default" || len(cfg.Block) != 1 || cfg.Block[0] != "ab12" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+}
+
+func TestLoadDefaultProfile(t *testing.T) {
+	dir := writeConfig(t, testConfig)
+	cfg, err := Load(nil, testEnv(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "home" || cfg.Name != "alice" || cfg.Port != 9100 || cfg.Theme != "light" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if len(cfg.Peers) != 2 {
+		t.Errorf("Expected 2 peers, got %v", cfg.Peers)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	dir := writeConfig(t, testConfig)
+
+	// Environment beats the file, flags beat the environment
+	env := testEnv(dir, "GOCHAT_PROFILE", "work", "GOCHAT_PORT", "9500", "GOCHAT_NAME", "from-env")
+	cfg, err := Load([]string{"-name", "from-flag"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Profile != "work" {
+		t.Errorf("Expected profile from environment, got %q", cfg.Profile)
+	}
+	if cfg.Port != 9500 {
+		t.Errorf("Expected port from environment, got %d", cfg.Port)
+	}
+	if cfg.Name != "from-flag" {
+		t.Errorf("Expected name from flag, got %q", cfg.Name)
+	}
+	if cfg.LogLevel.String() != "DEBUG:" {
+		t.Errorf("Expected log level from profile, got %v", cfg.LogLevel)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	dir := writeConfig(t, testConfig)
+	cases := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"-name", "Bob Smith"}, ""},
+		{[]string{"-profile", "missing"}, `no profile "missing"`},
+		{[]string{"-name", "x", "-port", "70000"}, "between 1 and 65535"},
+		{[]string{"-name", "x", "-peers", "nohost"}, "not a host:port"},
+		{[]string{"-name", "x", "-theme", "neon"}, `unknown theme "neon"`},
+		{[]string{"-name", "x", "-tls-cert", "cert.pem"}, "both a certificate and a key"},
+		{[]string{"-name", "x", "-log-level", "loud"}, "unknown log level"},
+		{[]string{"-name", "x", "-away-after", "-5m"}, "away-after"},
+		{[]string{"-name", "x", "-offline-ttl", "forever"}, "offline-ttl"},
+		{[]string{"-name", "x", "-pipe", "-format", "xml"}, `unknown format "xml"`},
+		{[]string{"-name", "x", "-http-listen", "127.0.0.1:8080"}, "token of at least 16"},
+		{[]string{"-name", "x", "-http-listen", "8080", "-http-token", "0123456789abcdef"}, "not host:port"},
+		{[]string{"-name", "x", "-irc-server", "irc.test:6667", "-irc-channel", "team"}, "must start with # or &"},
+		{[]string{"-name", "Bob Smith", "-irc-server", "irc.test:6667", "-irc-channel", "#team"}, "IRC nick"},
+	}
+	for _, c := range cases {
+		env := testEnv(t.TempDir())
+		if c.want == "" || strings.Contains(c.want, "profile") {
+			env = testEnv(dir)
+		}
+		_, err := Load(c.args, env)
+		if c.want == "" {
+			if err != nil {
+				t.Errorf("Load(%v) unexpected error: %v", c.args, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("Load(%v) error = %v, want it to mention %q", c.args, err, c.want)
+		}
+	}
+
+	if _, err := Load(nil, testEnv(t.TempDir())); err == nil || !strings.Contains(err.Error(), "name is required") {
+		t.Errorf("Expected missing name error, got %v", err)
+	}
+	if _, err := Load([]string{"-h"}, testEnv(t.TempDir())); !errors.Is(err, flag.ErrHelp) {
+		t.Errorf("Expected flag.ErrHelp, got %v", err)
+	}
+}
+
+func TestLoadRejectsUnknownSettings(t *testing.T) {
+	dir := writeConfig(t, "[profiles.default]\nname = \"a\"\nprot = 1\n")
+	_, err := Load(nil, testEnv(dir))
+	if err == nil || !strings.Contains(err.Error(), "profiles.default.prot") {
+		t.Errorf("Expected unknown setting error, got %v", err)
+	}
+}
+
+func TestLoadWebhooks(t *testing.T) {
+	dir := writeConfig(t, `
+[profiles.default]
+name = "alice"
+
+[[profiles.default.webhooks]]
+url = "https://hooks.example.com/a"
+events = ["mention", "keyword"]
+keywords = ["deploy"]
+`)
+	cfg, err := Load(nil, testEnv(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Webhooks) != 1 || cfg.Webhooks[0].Keywords[0] != "deploy" {
+		t.Errorf("Unexpected webhooks %+v", cfg.Webhooks)
+	}
+
+	dir = writeConfig(t, `
+[profiles.default]
+name = "alice"
+
+[[profiles.default.webhooks]]
+url = "ftp://example.com"
+events = ["keyword", "shout"]
+`)
+	_, err = Load(nil, testEnv(dir))
+	for _, want := range []string{"http or https URL", "keyword event needs keywords", `unknown event "shout"`} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error mentioning %q, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadHooks(t *testing.T) {
+	dir := writeConfig(t, `
+[profiles.default]
+name = "alice"
+
+[profiles.default.hooks]
+profanity = ["darn"]
+
+[[profiles.default.hooks.links]]
+prefix = "jira/"
+replace = "https://jira.example.com/browse/"
+
+[[profiles.default.hooks.replies]]
+match = "(?i)^!ping$"
+reply = "pong"
+`)
+	cfg, err := Load(nil, testEnv(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Hooks.Profanity) != 1 || len(cfg.Hooks.Links) != 1 || cfg.Hooks.Replies[0].Reply != "pong" {
+		t.Errorf("Unexpected hooks %+v", cfg.Hooks)
+	}
+
+	dir = writeConfig(t, `
+[profiles.default]
+name = "alice"
+
+[[profiles.default.hooks.replies]]
+match = "(unclosed"
+`)
+	_, err = Load(nil, testEnv(dir))
+	for _, want := range []string{"not a valid regular expression", "has no reply"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error mentioning %q, got %v", want, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// Profile names that can stand in a table header without quotes
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SaveFilters writes the ignore, mute and block lists into profile in the
+// config file at path, creating either if need be. Only those settings are
+// rewritten; comments and everything else stay as the user wrote them.
+func SaveFilters(path, profile string, ignore, block []string, mute map[string]time.Time) error {
+    b, err := os.ReadFile(path)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        return err
+    }
+    var lines []string
+    if text := strings.TrimRight(string(b), "\n"); text != "" {
+        lines = strings.Split(text, "\n")
+    }
+
+    key := profile
+    if !bareKey.MatchString(key) {
+        q, _ := json.Marshal(profile)
+        key = string(q)
+    }
+    header := "[profiles." + key + "]"
+    start := slices.IndexFunc(lines, func(l string) bool { return strings.TrimSpace(l) == header })
+    if start < 0 {
+        if len(lines) > 0 {
+            lines = append(lines, "")
+        }
+        lines = append(lines, header)
+        start = len(lines) - 1
+    }
+
+    // The profile's own settings run up to the next table header
+    var kept []string
+    i := start + 1
+    for ; i < len(lines); i++ {
+        line := strings.TrimSpace(lines[i])
+        if strings.HasPrefix(line, "[") {
+            break
+        }
+        if !isSetting(line, "ignore", "mute", "block") {
+            kept = append(kept, lines[i])
+            continue
+        }
+        // Skip the rest of an array written over several lines
+        for depth := strings.Count(line, "[") - strings.Count(line, "]"); depth > 0 && i+1 < len(lines); {
+            i++
+            depth += strings.Count(lines[i], "[") - strings.Count(lines[i], "]")
+        }
+    }
+    out := append(slices.Clone(lines[:start+1]), filterSettings(ignore, block, mute)...)
+    out = append(append(out, kept...), lines[i:]...)
+    text := strings.Join(out, "\n") + "\n"
+
+    var check File
+    if _, err := toml.Decode(text, &check); err != nil {
+        return fmt.Errorf("could not update config file %s: %w", path, err)
+    }
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return err
+    }
+    if err := os.WriteFile(path+".tmp", []byte(text), 0o600); err != nil {
+        return err
+    }
+    return os.Rename(path+".tmp", path)
+}
+
+// isSetting reports whether line sets one of keys
+func isSetting(line string, keys ...string) bool {
+    for _, k := range keys {
+        if rest, ok := strings.CutPrefix(line, k); ok && strings.HasPrefix(strings.TrimSpace(rest), "=") {
+            return true
+        }
+    }
+    return false
+}
+
+// filterSettings renders the lists as TOML, leaving out empty ones. JSON
+// strings are valid TOML strings.
+func filterSettings(ignore, block []string, mute map[string]time.Time) []string {
+    var out []string
+    if len(ignore) > 0 {
+        b, _ := json.Marshal(ignore)
+        out = append(out, "ignore = "+string(b))
+    }
+    if len(mute) > 0 {
+        var entries []string
+        for _, name := range slices.Sorted(maps.Keys(mute)) {
+            q, _ := json.Marshal(name)
+            entries = append(entries, string(q)+" = "+mute[name].UTC().Format(time.RFC3339))
+        }
+        out = append(out, "mute = { "+strings.Join(entries, ", ")+" }")
+    }
+    if len(block) > 0 {
+        b, _ := json.Marshal(block)
+        out = append(out, "block = "+string(b))
+    }
+    return out
+}
@@ -0,0 +1,216 @@
+package daemon
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	"gochat/internal/chat"
+	"gochat/internal/tui"
+)
+
+// ErrClientClosed is returned by calls on a client whose connection is gone
+var ErrClientClosed = errors.New("connection to daemon closed")
+
+// Client is a connection to a daemon's control socket. It implements
+// tui.Backend, so the TUI can attach to a daemon as it would to a node in
+// the same process.
+type Client struct {
+    conn net.Conn
+    sc *bufio.Scanner
+    events chan tui.Message
+    done chan struct{}
+    closeOnce sync.Once
+
+    mu sync.Mutex
+    enc *json.Encoder
+    nextID int64
+    pending map[int64]chan incoming
+    closed bool
+}
+
+// incoming is anything the daemon writes: a response or a notification
+type incoming struct {
+    ID *int64 `json:"id"`
+    Result json.RawMessage `json:"result"`
+    Error *Error `json:"error"`
+    Method string `json:"method"`
+    Params json.RawMessage `json:"params"`
+}
+
+// Dial connects to the daemon at path and subscribes to its events. Up to
+// history recent messages are delivered on Incoming ahead of live events.
+func Dial(path string, history int) (*Client, error) {
+    conn, err := net.Dial("unix", path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to attach to daemon: %w", err)
+    }
+    c := &Client{
+        conn: conn,
+        sc: bufio.NewScanner(conn),
+        enc: json.NewEncoder(conn),
+        done: make(chan struct{}),
+        pending: make(map[int64]chan incoming),
+    }
+    c.sc.Buffer(make([]byte, 0, 4096), maxRequestSize)
+
+    // Subscribe before the read loop starts so the history is queued
+    // before any live event
+    if err := c.enc.Encode(request{JSONRPC: "2.0", ID: json.RawMessage("0"), Method: "subscribe", Params: mustMarshal(SubscribeParams{History: history})}); err != nil {
+        conn.Close()
+        return nil, err
+    }
+    var resp incoming
+    if err := c.readOne(&resp); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("subscribe failed: %w", err)
+    }
+    if resp.Error != nil {
+        conn.Close()
+        return nil, fmt.Errorf("subscribe failed: %w", resp.Error)
+    }
+    var result SubscribeResult
+    if err := json.Unmarshal(resp.Result, &result); err != nil {
+        conn.Close()
+        return nil, fmt.Errorf("subscribe failed: %w", err)
+    }
+
+    c.events = make(chan tui.Message, len(result.History)+256)
+    for _, msg := range result.History {
+        c.events <- msg
+    }
+    c.nextID = 1
+    go c.readLoop()
+    return c, nil
+}
+
+func (c *Client) readOne(v *incoming) error {
+    if !c.sc.Scan() {
+        if err := c.sc.Err(); err != nil {
+            return err
+        }
+        return ErrClientClosed
+    }
+    return json.Unmarshal(c.sc.Bytes(), v)
+}
+
+func (c *Client) readLoop() {
+    defer c.shutdown()
+    for {
+        var msg incoming
+        if err := c.readOne(&msg); err != nil {
+            return
+        }
+        if msg.Method == "event" {
+            var ev tui.Message
+            if err := json.Unmarshal(msg.Params, &ev); err != nil {
+                continue
+            }
+            select {
+            case c.events <- ev:
+            case <-c.done:
+                return
+            }
+            continue
+        }
+        if msg.ID == nil {
+            continue
+        }
+        c.mu.Lock()
+        ch, ok := c.pending[*msg.ID]
+        delete(c.pending, *msg.ID)
+        c.mu.Unlock()
+        if ok {
+            ch <- msg
+        }
+    }
+}
+
+// shutdown fails outstanding calls and closes the event channel
+func (c *Client) shutdown() {
+    c.mu.Lock()
+    c.closed = true
+    for id, ch := range c.pending {
+        delete(c.pending, id)
+        close(ch)
+    }
+    c.mu.Unlock()
+    c.conn.Close()
+    close(c.events)
+}
+
+// Call invokes method with params and decodes the result into result,
+// which may be nil
+func (c *Client) Call(method string, params, result any) error {
+    c.mu.Lock()
+    if c.closed {
+        c.mu.Unlock()
+        return ErrClientClosed
+    }
+    id := c.nextID
+    c.nextID++
+    ch := make(chan incoming, 1)
+    c.pending[id] = ch
+    err := c.enc.Encode(request{JSONRPC: "2.0", ID: json.RawMessage(fmt.Sprint(id)), Method: method, Params: mustMarshal(params)})
+    c.mu.Unlock()
+    if err != nil {
+        return err
+    }
+
+    resp, ok := <-ch
+    if !ok {
+        return ErrClientClosed
+    }
+    if resp.Error != nil {
+        return resp.Error
+    }
+    if result == nil {
+        return nil
+    }
+    return json.Unmarshal(resp.Result, result)
+}
+
+// Send runs a slash command or broadcasts text on the daemon's node
+func (c *Client) Send(text string) error {
+    return c.Call("send", SendParams{Text: text}, nil)
+}
+
+// Peers lists the peers the daemon's node is connected to
+func (c *Client) Peers() ([]chat.PeerInfo, error) {
+    var peers []chat.PeerInfo
+    err := c.Call("peers", nil, &peers)
+    return peers, err
+}
+
+// Presence returns the status of the daemon's user and its note
+func (c *Client) Presence() (status, note string, err error) {
+    var result PresenceResult
+    err = c.Call("presence", nil, &result)
+    return result.Status, result.Note, err
+}
+
+// Incoming carries the history asked for in Dial, then live events. It is
+// closed when the connection to the daemon is lost.
+func (c *Client) Incoming() <-chan tui.Message {
+    return c.events
+}
+
+// Close detaches from the daemon. The node keeps running.
+func (c *Client) Close() error {
+    c.closeOnce.Do(func() { close(c.done) })
+    return c.conn.Close()
+}
+
+func mustMarshal(v any) json.RawMessage {
+    if v == nil {
+        return nil
+    }
+    b, err := json.Marshal(v)
+    if err != nil {
+        panic(err)
+    }
+    return b
+}
@@ -0,0 +1,109 @@
+// Package daemon exposes a running node over a Unix domain socket so
+// clients can attach to it and detach again while it stays in the mesh.
+//
+// The socket speaks JSON-RPC 2.0, one JSON object per line in each
+// direction. Methods:
+//
+//    send       {"text": "hi"}            -> {}
+//               Runs a slash command or broadcasts the text to all peers.
+//    peers      {}                        -> [{"name": "bob", "addr": "10.0.0.2:9000"}]
+//    presence   {}                        -> {"status": "away", "note": "idle"}
+//    connect    {"addr": "10.0.0.3:9000"} -> {}
+//    disconnect {"name": "bob"}           -> {}
+//    history    {"limit": 100}            -> [message, ...]
+//    subscribe  {"history": 100}          -> {"history": [message, ...]}
+//               After the reply the daemon sends "event" notifications
+//               whose params are messages. The optional history is
+//               returned atomically with the subscription, so no event is
+//               lost or repeated in between.
+//
+// A message looks like
+//
+//    {"from": "alice", "text": "hi", "kind": "text", "id": "7f3a...",
+//     "self": true, "reply_to": "c01d...",
+//     "reactions": [{"emoji": "👍", "from": ["bob"]}],
+//     "receipts": {"bob": "read", "carol": "delivered"}}
+//
+// kind is "text", "progress", "offer", "edit", "delete", "react",
+// "unreact", "presence", "typing" or "receipt". Chat lines carry their
+// id, which edits, deletes, reactions, receipts and replies (reply_to)
+// refer to. "self" marks messages sent through this node, by any client,
+// and only those have receipts: queued, sent, delivered, read or failed
+// for each peer.
+//
+// Errors use the standard JSON-RPC codes, with -32000 for failures
+// reported by the node itself (unknown peer, bad command and so on).
+package daemon
+
+import (
+	"encoding/json"
+
+	"gochat/internal/tui"
+)
+
+const (
+    codeParseError = -32700
+    codeInvalidRequest = -32600
+    codeMethodNotFound = -32601
+    codeInvalidParams = -32602
+    codeNodeError = -32000
+)
+
+type request struct {
+    JSONRPC string `json:"jsonrpc"`
+    ID json.RawMessage `json:"id,omitempty"`
+    Method string `json:"method"`
+    Params json.RawMessage `json:"params,omitempty"`
+}
+
+type response struct {
+    JSONRPC string `json:"jsonrpc"`
+    ID json.RawMessage `json:"id"`
+    Result any `json:"result,omitempty"`
+    Error *Error `json:"error,omitempty"`
+}
+
+type notification struct {
+    JSONRPC string `json:"jsonrpc"`
+    Method string `json:"method"`
+    Params any `json:"params"`
+}
+
+// Error is a JSON-RPC error object
+type Error struct {
+    Code int `json:"code"`
+    Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+    return e.Message
+}
+
+type SendParams struct {
+    Text string `json:"text"`
+}
+
+type ConnectParams struct {
+    Addr string `json:"addr"`
+}
+
+type DisconnectParams struct {
+    Name string `json:"name"`
+}
+
+type HistoryParams struct {
+    Limit int `json:"limit"`
+}
+
+type PresenceResult struct {
+    Status string `json:"status"`
+    Note string `json:"note,omitempty"`
+}
+
+type SubscribeParams struct {
+    History int `json:"history"`
+}
+
+type SubscribeResult struct {
+    History []tui.Message `json:"history"`
+}
@@ -0,0 +1,235 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gochat/internal/config"
+	"gochat/internal/node"
+	"gochat/internal/tui"
+)
+
+// startDaemon runs a node and its control socket for the duration of the test
+func startDaemon(t *testing.T, name string) (*node.Node, string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n := node.New(config.Config{Name: name, Port: 0, Downloads: t.TempDir()}, nil)
+	if err := n.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	ln, err := Listen(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		NewServer(n, nil).Serve(ctx, ln)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		n.Wait()
+	})
+	return n, path
+}
+
+type rawClient struct {
+	conn net.Conn
+	sc   *bufio.Scanner
+}
+
+func dialRaw(t *testing.T, path string) *rawClient {
+	t.Helper()
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &rawClient{conn: conn, sc: bufio.NewScanner(conn)}
+}
+
+func (c *rawClient) roundTrip(t *testing.T, line string) map[string]any {
+	t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	return c.next(t)
+}
+
+func (c *rawClient) next(t *testing.T) map[string]any {
+	t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if !c.sc.Scan() {
+		t.Fatalf("No reply from daemon: %v", c.sc.Err())
+	}
+	var out map[string]any
+	if err := json.Unmarshal(c.sc.Bytes(), &out); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestDaemonRPC(t *testing.T) {
+	_, path := startDaemon(t, "alice")
+	c := dialRaw(t, path)
+
+	resp := c.roundTrip(t, `{"jsonrpc":"2.0","id":1,"method":"peers"}`)
+	if peers, ok := resp["result"].([]any); !ok || len(peers) != 0 {
+		t.Errorf("Expected empty peer list, got %v", resp)
+	}
+
+	resp = c.roundTrip(t, `{"jsonrpc":"2.0","id":2,"method":"subscribe"}`)
+	if resp["error"] != nil {
+		t.Fatalf("subscribe failed: %v", resp)
+	}
+
+	if _, err := c.conn.Write([]byte(`{"jsonrpc":"2.0","id":3,"method":"send","params":{"text":"hello mesh"}}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	// The reply and the echoed event may arrive in either order
+	var sawReply, sawEvent bool
+	for !sawReply || !sawEvent {
+		msg := c.next(t)
+		switch {
+		case msg["method"] == "event":
+			params := msg["params"].(map[string]any)
+			if params["text"] != "hello mesh" || params["self"] != true || params["from"] != "alice" {
+				t.Errorf("Unexpected event %v", params)
+			}
+			sawEvent = true
+		case msg["id"] == float64(3):
+			sawReply = true
+		}
+	}
+
+	resp = c.roundTrip(t, `{"jsonrpc":"2.0","id":4,"method":"disconnect","params":{"name":"nobody"}}`)
+	if e, ok := resp["error"].(map[string]any); !ok || e["code"] != float64(codeNodeError) {
+		t.Errorf("Expected node error, got %v", resp)
+	}
+
+	resp = c.roundTrip(t, `{"jsonrpc":"2.0","id":5,"method":"fly"}`)
+	if e, ok := resp["error"].(map[string]any); !ok || e["code"] != float64(codeMethodNotFound) {
+		t.Errorf("Expected method not found, got %v", resp)
+	}
+
+	resp = c.roundTrip(t, `not json`)
+	if e, ok := resp["error"].(map[string]any); !ok || e["code"] != float64(codeParseError) {
+		t.Errorf("Expected parse error, got %v", resp)
+	}
+}
+
+func TestDaemonSocketInUse(t *testing.T) {
+	_, path := startDaemon(t, "alice")
+	if _, err := Listen(path); err == nil {
+		t.Error("Expected error listening on a live socket")
+	}
+}
+
+func TestClientAttachHistory(t *testing.T) {
+	n, path := startDaemon(t, "alice")
+	n.Notify("before anyone attached")
+
+	first, err := Dial(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	if err := first.Send("first post"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second client sees both earlier messages as history, then live ones
+	second, err := Dial(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if err := second.Send("second post"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"before anyone attached", "first post", "second post"}
+	for _, text := range want {
+		if msg := nextMessage(t, second); msg.Text != text {
+			t.Errorf("Expected %q, got %q", text, msg.Text)
+		}
+	}
+	for _, text := range want {
+		if msg := nextMessage(t, first); msg.Text != text {
+			t.Errorf("Expected %q, got %q", text, msg.Text)
+		}
+	}
+
+	peers, err := first.Peers()
+	if err != nil || len(peers) != 0 {
+		t.Errorf("Expected no peers, got %v, %v", peers, err)
+	}
+	if status, note, err := first.Presence(); err != nil || status != "online" || note != "" {
+		t.Errorf("Expected the user online, got %q %q, %v", status, note, err)
+	}
+
+	// Detaching one client leaves the other attached
+	second.Close()
+	if err := first.Send("still here"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextMessage(t, first); msg.Text != "still here" || !msg.Self {
+		t.Errorf("Unexpected message %+v", msg)
+	}
+}
+
+func nextMessage(t *testing.T, c *Client) tui.Message {
+	t.Helper()
+	select {
+	case msg := <-c.Incoming():
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for message")
+		return tui.Message{}
+	}
+}
+
+func TestHistoryKeepsThreadsAndReactions(t *testing.T) {
+	_, path := startDaemon(t, "alice")
+	first, err := Dial(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	if err := first.Send("root"); err != nil {
+		t.Fatal(err)
+	}
+	root := nextMessage(t, first)
+	if err := first.Send("/reply " + root.ID + " answer"); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Send("/react " + root.ID + " :+1:"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := nextMessage(t, first); msg.ReplyTo != root.ID {
+		t.Errorf("Expected a reply to %s, got %+v", root.ID, msg)
+	}
+	nextMessage(t, first)
+
+	second, err := Dial(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	got := nextMessage(t, second)
+	if len(got.Reactions) != 1 || got.Reactions[0].Emoji != "👍" || !got.Reactions[0].Self {
+		t.Errorf("Expected the reaction in history, got %+v", got)
+	}
+	if got := nextMessage(t, second); got.Text != "answer" || got.ReplyTo != root.ID {
+		t.Errorf("Expected the reply in history, got %+v", got)
+	}
+}
@@ -0,0 +1,246 @@
+package daemon
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gochat/internal/node"
+	"gochat/internal/tui"
+	"gochat/internal/util"
+)
+
+// Largest request line a client may send
+const maxRequestSize = 1 << 20
+
+// Listen opens the control socket at path, readable by the current user
+// only. A stale socket left by a crashed daemon is replaced; a live one is
+// an error.
+func Listen(path string) (net.Listener, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return nil, err
+    }
+    if _, err := os.Stat(path); err == nil {
+        if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+            conn.Close()
+            return nil, fmt.Errorf("a daemon is already listening on %s", path)
+        }
+        if err := os.Remove(path); err != nil {
+            return nil, err
+        }
+    }
+    ln, err := net.Listen("unix", path)
+    if err != nil {
+        return nil, err
+    }
+    if err := os.Chmod(path, 0o600); err != nil {
+        ln.Close()
+        return nil, err
+    }
+    return ln, nil
+}
+
+type Server struct {
+    node *node.Node
+    log *slog.Logger
+}
+
+func NewServer(n *node.Node, log *slog.Logger) *Server {
+    return &Server{node: n, log: util.OrDiscard(log)}
+}
+
+// Serve accepts clients on ln until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+    go func() {
+        <-ctx.Done()
+        ln.Close()
+    }()
+
+    var wg sync.WaitGroup
+    defer wg.Wait()
+    for {
+        conn, err := ln.Accept()
+        if err != nil {
+            if ctx.Err() != nil {
+                return nil
+            }
+            return err
+        }
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            s.serveConn(ctx, conn)
+        }()
+    }
+}
+
+// client is one attached connection. Responses and event notifications
+// share the connection, so writes are serialised.
+type client struct {
+    conn net.Conn
+    mu sync.Mutex
+    enc *json.Encoder
+    unsubscribe func()
+}
+
+func (c *client) write(v any) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return c.enc.Encode(v)
+}
+
+func (s *Server) serveConn(ctx context.Context, conn net.Conn) {
+    c := &client{conn: conn, enc: json.NewEncoder(conn)}
+    defer func() {
+        if c.unsubscribe != nil {
+            c.unsubscribe()
+        }
+        conn.Close()
+    }()
+    // Unblock the scanner when the daemon shuts down
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+
+    s.log.Debug("control client attached")
+    sc := bufio.NewScanner(conn)
+    sc.Buffer(make([]byte, 0, 4096), maxRequestSize)
+    for sc.Scan() {
+        var req request
+        if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+            c.write(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &Error{codeParseError, "parse error: " + err.Error()}})
+            continue
+        }
+        if req.Method == "subscribe" {
+            if err := s.subscribe(c, req); err != nil {
+                return
+            }
+            continue
+        }
+        result, rpcErr := s.call(req)
+        if len(req.ID) == 0 {
+            // A notification; the client does not want a reply
+            continue
+        }
+        resp := response{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rpcErr}
+        if rpcErr == nil && result == nil {
+            resp.Result = struct{}{}
+        }
+        if err := c.write(resp); err != nil {
+            return
+        }
+    }
+    s.log.Debug("control client detached")
+}
+
+func (s *Server) call(req request) (any, *Error) {
+    if req.JSONRPC != "2.0" || req.Method == "" {
+        return nil, &Error{codeInvalidRequest, "invalid request"}
+    }
+
+    switch req.Method {
+    case "send":
+        var p SendParams
+        if err := decodeParams(req.Params, &p); err != nil {
+            return nil, err
+        }
+        return nil, nodeError(s.node.Send(p.Text))
+    case "peers":
+        return s.node.Peers(), nil
+    case "presence":
+        status, note := s.node.Presence()
+        return PresenceResult{Status: status, Note: note}, nil
+    case "connect":
+        var p ConnectParams
+        if err := decodeParams(req.Params, &p); err != nil {
+            return nil, err
+        }
+        if p.Addr == "" {
+            return nil, &Error{codeInvalidParams, "addr is required"}
+        }
+        return nil, nodeError(s.node.Connect(p.Addr))
+    case "disconnect":
+        var p DisconnectParams
+        if err := decodeParams(req.Params, &p); err != nil {
+            return nil, err
+        }
+        return nil, nodeError(s.node.Disconnect(p.Name))
+    case "history":
+        p := HistoryParams{Limit: 100}
+        if err := decodeParams(req.Params, &p); err != nil {
+            return nil, err
+        }
+        history := s.node.History(p.Limit)
+        if history == nil {
+            history = []tui.Message{}
+        }
+        return history, nil
+    }
+    return nil, &Error{codeMethodNotFound, "unknown method " + req.Method}
+}
+
+// subscribe starts the event stream for a client. The reply goes out before
+// the first event so clients can rely on the order.
+func (s *Server) subscribe(c *client, req request) error {
+    var p SubscribeParams
+    if rpcErr := decodeParams(req.Params, &p); rpcErr != nil {
+        return c.write(response{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+    }
+
+    var events <-chan tui.Message
+    result := SubscribeResult{History: []tui.Message{}}
+    if c.unsubscribe == nil {
+        var history []tui.Message
+        history, events, c.unsubscribe = s.node.SubscribeWithHistory(256, p.History)
+        if history != nil {
+            result.History = history
+        }
+    }
+    if len(req.ID) > 0 {
+        if err := c.write(response{JSONRPC: "2.0", ID: req.ID, Result: result}); err != nil {
+            return err
+        }
+    }
+    if events != nil {
+        go s.forward(c, events)
+    }
+    return nil
+}
+
+// forward streams node events to a subscribed client
+func (s *Server) forward(c *client, events <-chan tui.Message) {
+    for msg := range events {
+        if err := c.write(notification{JSONRPC: "2.0", Method: "event", Params: msg}); err != nil {
+            c.conn.Close()
+            return
+        }
+    }
+}
+
+func decodeParams(raw json.RawMessage, v any) *Error {
+    if len(raw) == 0 {
+        return nil
+    }
+    if err := json.Unmarshal(raw, v); err != nil {
+        return &Error{codeInvalidParams, "invalid params: " + err.Error()}
+    }
+    return nil
+}
+
+func nodeError(err error) *Error {
+    if err == nil {
+        return nil
+    }
+    var rpcErr *Error
+    if errors.As(err, &rpcErr) {
+        return rpcErr
+    }
+    return &Error{codeNodeError, err.Error()}
+}
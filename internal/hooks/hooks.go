@@ -0,0 +1,150 @@
+// Package hooks has the built-in chat.Hook implementations that main
+// registers from the config file.
+package hooks
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gochat/internal/chat"
+)
+
+// Profanity masks listed words in chat, both ways, and annotates the
+// envelope with "filtered" when it did
+type Profanity struct {
+    chat.NopHook
+    re *regexp.Regexp
+}
+
+func NewProfanity(words []string) *Profanity {
+    quoted := make([]string, len(words))
+    for i, w := range words {
+        quoted[i] = regexp.QuoteMeta(w)
+    }
+    return &Profanity{re: regexp.MustCompile(`(?i)\b(` + strings.Join(quoted, "|") + `)\b`)}
+}
+
+func (p *Profanity) OnIncoming(_ *chat.Peer, env *chat.Envelope) bool {
+    p.filter(env)
+    return true
+}
+
+func (p *Profanity) OnOutgoing(env *chat.Envelope) bool {
+    p.filter(env)
+    return true
+}
+
+func (p *Profanity) filter(env *chat.Envelope) {
+    if env.Type != chat.TypeChat && env.Type != chat.TypeEdit {
+        return
+    }
+    masked := p.re.ReplaceAllStringFunc(env.Text, func(w string) string {
+        return strings.Repeat("*", len([]rune(w)))
+    })
+    if masked != env.Text {
+        env.Text = masked
+        env.Annotate("filtered", "profanity")
+    }
+}
+
+// LinkRule replaces the Prefix of any link with Replace
+type LinkRule struct {
+    Prefix string
+    Replace string
+}
+
+// Links rewrites links in outgoing chat, e.g. to expand internal short
+// links or point at a mirror, and annotates the envelope with "links"
+type Links struct {
+    chat.NopHook
+    rules []LinkRule
+    re *regexp.Regexp
+}
+
+func NewLinks(rules []LinkRule) *Links {
+    quoted := make([]string, len(rules))
+    for i, r := range rules {
+        quoted[i] = regexp.QuoteMeta(r.Prefix)
+    }
+    // A prefix only counts at the start of a word
+    return &Links{rules: rules, re: regexp.MustCompile(`(^|\s)(` + strings.Join(quoted, "|") + `)`)}
+}
+
+func (l *Links) OnOutgoing(env *chat.Envelope) bool {
+    if env.Type != chat.TypeChat && env.Type != chat.TypeEdit {
+        return true
+    }
+    rewritten := l.re.ReplaceAllStringFunc(env.Text, func(m string) string {
+        lead := m[:len(m)-len(strings.TrimLeft(m, " \t\n"))]
+        for _, r := range l.rules {
+            if m[len(lead):] == r.Prefix {
+                return lead + r.Replace
+            }
+        }
+        return m
+    })
+    if rewritten != env.Text {
+        env.Text = rewritten
+        env.Annotate("links", "rewritten")
+    }
+    return true
+}
+
+// ReplyRule answers chat matching Pattern with Reply
+type ReplyRule struct {
+    Pattern *regexp.Regexp
+    Reply string
+}
+
+// How long before the same rule answers the same peer again. This keeps
+// two nodes with auto-replies from talking to each other forever.
+const replyCooldown = time.Minute
+
+// AutoReply answers matching incoming chat, sending the reply to the peer
+// that wrote it through the room's outgoing hooks
+type AutoReply struct {
+    chat.NopHook
+    room *chat.ChatRoom
+    name string
+    rules []ReplyRule
+
+    mu sync.Mutex
+    last map[string]time.Time // peer name and rule index -> last reply
+    now func() time.Time
+}
+
+func NewAutoReply(room *chat.ChatRoom, name string, rules []ReplyRule) *AutoReply {
+    return &AutoReply{room: room, name: name, rules: rules, last: make(map[string]time.Time), now: time.Now}
+}
+
+func (a *AutoReply) OnIncoming(peer *chat.Peer, env *chat.Envelope) bool {
+    // Never answer another node's auto-reply
+    if env.Type != chat.TypeChat || env.Annotations["auto_reply"] != "" {
+        return true
+    }
+    for i, r := range a.rules {
+        if !r.Pattern.MatchString(env.Text) || !a.allow(peer.Name, i) {
+            continue
+        }
+        reply := chat.NewChat(a.name, r.Reply)
+        reply.Room = env.Room
+        reply.Annotate("auto_reply", "true")
+        chat.SendTo(a.room, peer, reply)
+    }
+    return true
+}
+
+func (a *AutoReply) allow(peer string, rule int) bool {
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    key := fmt.Sprintf("%s/%d", peer, rule)
+    now := a.now()
+    if last, ok := a.last[key]; ok && now.Sub(last) < replyCooldown {
+        return false
+    }
+    a.last[key] = now
+    return true
+}
@@ -0,0 +1,86 @@
+package hooks
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/tui"
+)
+
+func TestProfanity(t *testing.T) {
+	p := NewProfanity([]string{"darn", "heck"})
+	env := chat.NewChat("bob", "Darn it, what the heck. Darning socks is fine")
+	p.OnIncoming(nil, &env)
+	if env.Text != "**** it, what the ****. Darning socks is fine" {
+		t.Errorf("Unexpected text %q", env.Text)
+	}
+	if env.Annotations["filtered"] != "profanity" {
+		t.Errorf("Expected an annotation, got %v", env.Annotations)
+	}
+
+	clean := chat.NewChat("bob", "all good")
+	p.OnOutgoing(&clean)
+	if clean.Text != "all good" || clean.Annotations != nil {
+		t.Errorf("Clean message changed: %+v", clean)
+	}
+}
+
+func TestLinks(t *testing.T) {
+	l := NewLinks([]LinkRule{
+		{Prefix: "jira/", Replace: "https://jira.example.com/browse/"},
+		{Prefix: "http://wiki/", Replace: "https://wiki.example.com/"},
+	})
+	env := chat.NewChat("alice", "see jira/OPS-1 and http://wiki/runbook, not myjira/x")
+	l.OnOutgoing(&env)
+	want := "see https://jira.example.com/browse/OPS-1 and https://wiki.example.com/runbook, not myjira/x"
+	if env.Text != want || env.Annotations["links"] != "rewritten" {
+		t.Errorf("Got %q %v", env.Text, env.Annotations)
+	}
+}
+
+func TestAutoReplyCooldown(t *testing.T) {
+	a := NewAutoReply(chat.NewRoom(), "alice", []ReplyRule{{Pattern: regexp.MustCompile(`(?i)^!ping$`), Reply: "pong"}})
+	now := time.Unix(1000, 0)
+	a.now = func() time.Time { return now }
+
+	if !a.allow("bob", 0) {
+		t.Error("First reply should be allowed")
+	}
+	if a.allow("bob", 0) {
+		t.Error("Second reply within the cooldown should be refused")
+	}
+	if !a.allow("carol", 0) {
+		t.Error("Other peers have their own cooldown")
+	}
+	now = now.Add(replyCooldown)
+	if !a.allow("bob", 0) {
+		t.Error("Reply should be allowed after the cooldown")
+	}
+}
+
+func TestAutoReplyGoesThroughHooks(t *testing.T) {
+	room := chat.NewRoom()
+	msgs := make(chan tui.Message, 10)
+	room.SetTUIMessageChannel(msgs)
+	room.AddHook(NewProfanity([]string{"darn"}))
+	a := NewAutoReply(room, "alice", []ReplyRule{{Pattern: regexp.MustCompile(`^!ping$`), Reply: "darn pong"}})
+	room.AddHook(a)
+	conn, other := net.Pipe()
+	defer conn.Close()
+	defer other.Close()
+	peer := room.AddPeer("bob", conn)
+
+	env := chat.NewChat("bob", "!ping")
+	a.OnIncoming(peer, &env)
+	select {
+	case msg := <-msgs:
+		if msg.Text != "To bob: **** pong" {
+			t.Errorf("Expected the filtered reply shown locally, got %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for the local notice")
+	}
+}
@@ -0,0 +1,152 @@
+// Package httpapi is the optional local HTTP endpoint that lets other tools
+// such as CI pipelines post into the mesh. Every request needs the
+// configured bearer token.
+//
+//    POST /rooms/{room}/messages  {"text": "build #42 passed"} or a text/plain body
+//    GET  /peers                  [{"name": "bob", "addr": "10.0.0.2:9000"}]
+//    GET  /health                 {"status": "ok", "node": "alice", "peers": 1, "uptime_seconds": 3600}
+package httpapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/config"
+	"gochat/internal/util"
+)
+
+// Largest message body accepted
+const maxBody = 64 << 10
+
+// Node is what the endpoint needs from the node it serves
+type Node interface {
+    Name() string
+    Post(room, from, text string) error
+    Peers() []chat.PeerInfo
+}
+
+type Server struct {
+    node Node
+    conf config.HTTP
+    log *slog.Logger
+    started time.Time
+}
+
+func New(n Node, conf config.HTTP, log *slog.Logger) *Server {
+    return &Server{node: n, conf: conf, log: util.OrDiscard(log), started: time.Now()}
+}
+
+// Handler routes requests, checking the token first
+func (s *Server) Handler() http.Handler {
+    mux := http.NewServeMux()
+    mux.HandleFunc("POST /rooms/{room}/messages", s.postMessage)
+    mux.HandleFunc("GET /peers", s.peers)
+    mux.HandleFunc("GET /health", s.health)
+    return s.authorize(mux)
+}
+
+// Serve answers requests on ln until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, ln net.Listener) error {
+    srv := &http.Server{
+        Handler: s.Handler(),
+        ReadHeaderTimeout: 10 * time.Second,
+        ErrorLog: slog.NewLogLogger(s.log.Handler(), slog.LevelWarn),
+    }
+    go func() {
+        <-ctx.Done()
+        shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+        defer cancel()
+        srv.Shutdown(shutdownCtx)
+    }()
+    if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+        return err
+    }
+    return nil
+}
+
+func (s *Server) authorize(next http.Handler) http.Handler {
+    want := []byte("Bearer " + s.conf.Token)
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        got := []byte(r.Header.Get("Authorization"))
+        if s.conf.Token == "" || subtle.ConstantTimeCompare(got, want) != 1 {
+            s.log.Warn("rejected HTTP request", "remote", r.RemoteAddr, "path", r.URL.Path)
+            w.Header().Set("WWW-Authenticate", `Bearer realm="gochat"`)
+            writeError(w, http.StatusUnauthorized, "missing or invalid token")
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+func (s *Server) postMessage(w http.ResponseWriter, r *http.Request) {
+    room := r.PathValue("room")
+    if !chat.ValidRoom(room) {
+        writeError(w, http.StatusBadRequest, "room names are up to 32 lowercase letters, digits, - and _")
+        return
+    }
+
+    body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+    if err != nil {
+        writeError(w, http.StatusRequestEntityTooLarge, "message body too large")
+        return
+    }
+    text := string(body)
+    if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "application/json" {
+        var req struct {
+            Text string `json:"text"`
+        }
+        if err := json.Unmarshal(body, &req); err != nil {
+            writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+            return
+        }
+        text = req.Text
+    }
+    if strings.TrimSpace(text) == "" {
+        writeError(w, http.StatusBadRequest, "text is required")
+        return
+    }
+
+    if err := s.node.Post(room, s.conf.Name, text); err != nil {
+        writeError(w, http.StatusInternalServerError, err.Error())
+        return
+    }
+    s.log.Info("message posted over HTTP", "room", room, "remote", r.RemoteAddr)
+    writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+}
+
+func (s *Server) peers(w http.ResponseWriter, r *http.Request) {
+    peers := s.node.Peers()
+    if peers == nil {
+        peers = []chat.PeerInfo{}
+    }
+    writeJSON(w, http.StatusOK, peers)
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+    writeJSON(w, http.StatusOK, map[string]any{
+        "status": "ok",
+        "node": s.node.Name(),
+        "peers": len(s.node.Peers()),
+        "uptime_seconds": int(time.Since(s.started).Seconds()),
+    })
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+    writeJSON(w, status, map[string]string{"error": msg})
+}
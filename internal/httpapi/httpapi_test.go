@@ -0,0 +1,111 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"gochat/internal/chat"
+	"gochat/internal/config"
+)
+
+const token = "0123456789abcdef"
+
+type post struct{ room, from, text string }
+
+type fakeNode struct {
+	mu    sync.Mutex
+	posts []post
+}
+
+func (f *fakeNode) Name() string { return "alice" }
+
+func (f *fakeNode) Post(room, from, text string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.posts = append(f.posts, post{room, from, text})
+	return nil
+}
+
+func (f *fakeNode) Peers() []chat.PeerInfo {
+	return []chat.PeerInfo{{Name: "bob", Addr: "127.0.0.1:9001"}}
+}
+
+func do(t *testing.T, h http.Handler, method, path, auth, contentType, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPostMessage(t *testing.T) {
+	node := &fakeNode{}
+	h := New(node, config.HTTP{Token: token, Name: "ci"}, nil).Handler()
+	auth := "Bearer " + token
+
+	if rec := do(t, h, "POST", "/rooms/builds/messages", auth, "application/json", `{"text":"build #42 passed"}`); rec.Code != http.StatusAccepted {
+		t.Errorf("JSON post: got %d %s", rec.Code, rec.Body)
+	}
+	if rec := do(t, h, "POST", "/rooms/general/messages", auth, "text/plain", "deploy done\n"); rec.Code != http.StatusAccepted {
+		t.Errorf("Plain post: got %d %s", rec.Code, rec.Body)
+	}
+	want := []post{{"builds", "ci", "build #42 passed"}, {"general", "ci", "deploy done\n"}}
+	if len(node.posts) != len(want) {
+		t.Fatalf("Expected %d posts, got %+v", len(want), node.posts)
+	}
+	for i := range want {
+		if node.posts[i] != want[i] {
+			t.Errorf("Post %d: expected %+v, got %+v", i, want[i], node.posts[i])
+		}
+	}
+
+	cases := []struct {
+		path, auth, contentType, body string
+		code                          int
+	}{
+		{"/rooms/builds/messages", "", "text/plain", "hi", http.StatusUnauthorized},
+		{"/rooms/builds/messages", "Bearer wrong", "text/plain", "hi", http.StatusUnauthorized},
+		{"/rooms/Bad%20Room/messages", auth, "text/plain", "hi", http.StatusBadRequest},
+		{"/rooms/builds/messages", auth, "application/json", `{"text":`, http.StatusBadRequest},
+		{"/rooms/builds/messages", auth, "text/plain", "   ", http.StatusBadRequest},
+		{"/rooms/builds/messages", auth, "text/plain", strings.Repeat("x", maxBody+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		if rec := do(t, h, "POST", c.path, c.auth, c.contentType, c.body); rec.Code != c.code {
+			t.Errorf("POST %s (%q): expected %d, got %d %s", c.path, c.auth, c.code, rec.Code, rec.Body)
+		}
+	}
+	if len(node.posts) != len(want) {
+		t.Errorf("Rejected requests were posted: %+v", node.posts[len(want):])
+	}
+}
+
+func TestPeersAndHealth(t *testing.T) {
+	h := New(&fakeNode{}, config.HTTP{Token: token}, nil).Handler()
+
+	rec := do(t, h, "GET", "/peers", "Bearer "+token, "", "")
+	var peers []chat.PeerInfo
+	if err := json.Unmarshal(rec.Body.Bytes(), &peers); err != nil || len(peers) != 1 || peers[0].Name != "bob" {
+		t.Errorf("Unexpected peers response %d %s", rec.Code, rec.Body)
+	}
+
+	rec = do(t, h, "GET", "/health", "Bearer "+token, "", "")
+	var health map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &health); err != nil || health["status"] != "ok" || health["peers"] != float64(1) {
+		t.Errorf("Unexpected health response %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(t, h, "GET", "/health", "", "", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected health to need the token, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,364 @@
+// Package ircbridge relays a gochat room to an IRC channel and back. It is
+// a plain RFC 1459/2812 client: it registers, joins one channel, answers
+// pings and reconnects with backoff when the connection drops.
+//
+// Messages from IRC enter the mesh as "nick@irc". Anything already sent as
+// someone@irc is never sent back to IRC, so bridges cannot echo each
+// other's messages around in a loop.
+package ircbridge
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+
+	"gochat/internal/config"
+	"gochat/internal/tui"
+	"gochat/internal/util"
+)
+
+// Suffix marking senders relayed from IRC
+const Suffix = "@irc"
+
+// IRC lines are at most 512 bytes including the command and CRLF; keep
+// relayed text well inside that
+const maxText = 400
+
+const (
+    minRedial = time.Second
+    maxRedial = time.Minute
+    dialTimeout = 15 * time.Second
+)
+
+// Lines relayed to IRC are paced so servers do not kick the bridge for
+// flooding: a burst of relayBurst, then one every relayInterval. At most
+// maxBacklog wait their turn; past that the oldest are dropped.
+const (
+    relayBurst = 5
+    relayInterval = time.Second
+    maxBacklog = 100
+)
+
+// Node is what the bridge needs from the node it runs on
+type Node interface {
+    Post(room, from, text string) error
+    Subscribe(buf int) (<-chan tui.Message, func())
+}
+
+type Bridge struct {
+    node Node
+    conf config.IRC
+    log *slog.Logger
+    room string // conf.Room as it appears in tui.Message.Room
+    minRedial time.Duration
+}
+
+func New(n Node, conf config.IRC, log *slog.Logger) *Bridge {
+    room := conf.Room
+    if room == "general" {
+        room = ""
+    }
+    return &Bridge{
+        node: n,
+        conf: conf,
+        log: util.OrDiscard(log).With("irc", conf.Server, "channel", conf.Channel),
+        room: room,
+        minRedial: minRedial,
+    }
+}
+
+// Run keeps the bridge connected until ctx is cancelled
+func (b *Bridge) Run(ctx context.Context) error {
+    events, unsubscribe := b.node.Subscribe(256)
+    defer unsubscribe()
+
+    delay := b.minRedial
+    for {
+        start := time.Now()
+        err := b.session(ctx, events)
+        if ctx.Err() != nil {
+            return nil
+        }
+        b.log.Warn("IRC connection lost", "err", err)
+        // A connection that lasted a while starts the backoff over
+        if time.Since(start) > maxRedial {
+            delay = b.minRedial
+        }
+        select {
+        case <-ctx.Done():
+            return nil
+        case <-time.After(delay):
+        }
+        delay = min(delay*2, maxRedial)
+    }
+}
+
+func (b *Bridge) dial(ctx context.Context) (net.Conn, error) {
+    d := &net.Dialer{Timeout: dialTimeout}
+    if b.conf.TLS {
+        host, _, _ := net.SplitHostPort(b.conf.Server)
+        td := &tls.Dialer{NetDialer: d, Config: &tls.Config{ServerName: host}}
+        return td.DialContext(ctx, "tcp", b.conf.Server)
+    }
+    return d.DialContext(ctx, "tcp", b.conf.Server)
+}
+
+// session runs one connection: register, join, then relay both ways
+func (b *Bridge) session(ctx context.Context, events <-chan tui.Message) error {
+    conn, err := b.dial(ctx)
+    if err != nil {
+        return err
+    }
+    defer conn.Close()
+    stop := context.AfterFunc(ctx, func() {
+        fmt.Fprintf(conn, "QUIT :bridge shutting down\r\n")
+        conn.Close()
+    })
+    defer stop()
+
+    lines := make(chan string)
+    readErr := make(chan error, 1)
+    done := make(chan struct{})
+    defer close(done)
+    go func() {
+        sc := bufio.NewScanner(conn)
+        for sc.Scan() {
+            select {
+            case lines <- sc.Text():
+            case <-done:
+                return
+            }
+        }
+        err := sc.Err()
+        if err == nil {
+            err = fmt.Errorf("server closed the connection")
+        }
+        readErr <- err
+        close(lines)
+    }()
+
+    c := &ircConn{conn: conn, nick: b.conf.Nick}
+    if b.conf.Password != "" {
+        c.send("PASS", b.conf.Password)
+    }
+    c.send("NICK", c.nick)
+    c.send("USER", c.nick, "0", "*", "gochat bridge")
+
+    joined := false
+    var relayWait <-chan time.Time
+    for {
+        select {
+        case line, ok := <-lines:
+            if !ok {
+                return <-readErr
+            }
+            if err := b.handle(c, line, &joined); err != nil {
+                return err
+            }
+        case msg, ok := <-events:
+            if !ok {
+                return fmt.Errorf("node stopped")
+            }
+            if joined {
+                b.relayToIRC(c, msg)
+            }
+        case <-relayWait:
+        }
+        relayWait = nil
+        if wait := c.flush(b.conf.Channel, time.Now()); wait > 0 {
+            relayWait = time.After(wait)
+        }
+        if c.err != nil {
+            return c.err
+        }
+    }
+}
+
+// handle reacts to one line from the server
+func (b *Bridge) handle(c *ircConn, line string, joined *bool) error {
+    prefix, cmd, params := parseLine(line)
+    switch cmd {
+    case "PING":
+        c.send("PONG", params...)
+    case "001":
+        // Registered; the server may have shortened or changed our nick
+        if len(params) > 0 {
+            c.nick = params[0]
+        }
+        c.send("JOIN", b.conf.Channel)
+    case "433":
+        // Nick in use, try another before registration completes
+        c.nick += "_"
+        c.send("NICK", c.nick)
+    case "JOIN":
+        if nickOf(prefix) == c.nick && len(params) > 0 && strings.EqualFold(params[0], b.conf.Channel) {
+            *joined = true
+            b.log.Info("joined IRC channel", "nick", c.nick)
+        }
+    case "KICK":
+        if len(params) > 1 && strings.EqualFold(params[0], b.conf.Channel) && params[1] == c.nick {
+            *joined = false
+            b.log.Warn("kicked from IRC channel, rejoining")
+            c.send("JOIN", b.conf.Channel)
+        }
+    case "ERROR":
+        return fmt.Errorf("server error: %s", strings.Join(params, " "))
+    case "PRIVMSG", "NOTICE":
+        if len(params) < 2 || !strings.EqualFold(params[0], b.conf.Channel) {
+            return nil
+        }
+        nick := nickOf(prefix)
+        if nick == "" || nick == c.nick {
+            return nil
+        }
+        text := params[1]
+        if action, ok := strings.CutPrefix(text, "\x01ACTION "); ok {
+            text = "* " + nick + " " + strings.TrimSuffix(action, "\x01")
+        } else if strings.HasPrefix(text, "\x01") {
+            return nil // other CTCP
+        }
+        if err := b.node.Post(b.conf.Room, nick+Suffix, text); err != nil {
+            b.log.Warn("failed to relay IRC message", "from", nick, "err", err)
+        }
+    }
+    return nil
+}
+
+// relayToIRC sends a chat line from the room to the channel
+func (b *Bridge) relayToIRC(c *ircConn, msg tui.Message) {
+    if msg.Kind != tui.KindText || msg.From == "System" || msg.Room != b.room {
+        return
+    }
+    if strings.HasSuffix(msg.From, Suffix) {
+        return
+    }
+    for _, line := range strings.Split(msg.Text, "\n") {
+        line = strings.TrimRight(line, "\r")
+        for line != "" {
+            chunk := truncate(line, maxText)
+            line = line[len(chunk):]
+            c.backlog = append(c.backlog, "<"+msg.From+"> "+chunk)
+        }
+    }
+    if over := len(c.backlog) - maxBacklog; over > 0 {
+        b.log.Warn("too much to relay to IRC, dropping the oldest lines", "dropped", over)
+        c.backlog = c.backlog[over:]
+    }
+}
+
+// truncate cuts s to at most n bytes without splitting a UTF-8 sequence
+func truncate(s string, n int) string {
+    if len(s) <= n {
+        return s
+    }
+    for n > 0 && s[n]&0xC0 == 0x80 {
+        n--
+    }
+    return s[:n]
+}
+
+type ircConn struct {
+    conn net.Conn
+    nick string
+    err error
+    backlog []string // relayed lines waiting for the pacer
+    pace pacer
+}
+
+// flush sends backlogged lines to channel as fast as the pacer allows, and
+// returns how long until the next may go, or 0 once none are left
+func (c *ircConn) flush(channel string, now time.Time) time.Duration {
+    for len(c.backlog) > 0 {
+        if wait := c.pace.take(now); wait > 0 {
+            return wait
+        }
+        c.send("PRIVMSG", channel, c.backlog[0])
+        c.backlog = c.backlog[1:]
+    }
+    return 0
+}
+
+// pacer is a token bucket holding relayBurst lines, refilled at one per
+// relayInterval
+type pacer struct {
+    free time.Time // when the bucket will be full again
+}
+
+// take uses up a line at now if one is left, returning 0, or else how
+// long until one is
+func (p *pacer) take(now time.Time) time.Duration {
+    if p.free.Before(now) {
+        p.free = now
+    }
+    if wait := p.free.Sub(now) - (relayBurst-1)*relayInterval; wait > 0 {
+        return wait
+    }
+    p.free = p.free.Add(relayInterval)
+    return 0
+}
+
+// send writes a command, making the last parameter a trailing one
+func (c *ircConn) send(cmd string, params ...string) {
+    if c.err != nil {
+        return
+    }
+    var sb strings.Builder
+    sb.WriteString(cmd)
+    for i, p := range params {
+        p = strings.NewReplacer("\r", "", "\n", "").Replace(p)
+        sb.WriteByte(' ')
+        if i == len(params)-1 && (p == "" || strings.ContainsAny(p, " :") || p[0] == ':') {
+            sb.WriteByte(':')
+        }
+        sb.WriteString(p)
+    }
+    sb.WriteString("\r\n")
+    c.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+    _, c.err = c.conn.Write([]byte(sb.String()))
+}
+
+// parseLine splits a raw IRC line into prefix, command and parameters
+func parseLine(line string) (prefix, cmd string, params []string) {
+    line = strings.TrimRight(line, "\r\n")
+    if strings.HasPrefix(line, "@") {
+        // IRCv3 message tags are not used
+        if i := strings.IndexByte(line, ' '); i >= 0 {
+            line = strings.TrimLeft(line[i+1:], " ")
+        }
+    }
+    if strings.HasPrefix(line, ":") {
+        i := strings.IndexByte(line, ' ')
+        if i < 0 {
+            return line[1:], "", nil
+        }
+        prefix, line = line[1:i], strings.TrimLeft(line[i+1:], " ")
+    }
+    var trailing string
+    hasTrailing := false
+    if i := strings.Index(line, " :"); i >= 0 {
+        trailing, hasTrailing = line[i+2:], true
+        line = line[:i]
+    }
+    fields := strings.Fields(line)
+    if len(fields) == 0 {
+        return prefix, "", nil
+    }
+    cmd, params = strings.ToUpper(fields[0]), fields[1:]
+    if hasTrailing {
+        params = append(params, trailing)
+    }
+    return prefix, cmd, params
+}
+
+// nickOf returns the nick in a nick!user@host prefix
+func nickOf(prefix string) string {
+    if i := strings.IndexAny(prefix, "!@"); i >= 0 {
+        return prefix[:i]
+    }
+    return prefix
+}
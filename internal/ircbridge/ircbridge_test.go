@@ -0,0 +1,301 @@
+package ircbridge
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gochat/internal/config"
+	"gochat/internal/tui"
+)
+
+type post struct{ room, from, text string }
+
+// fakeNode records posts and echoes them to subscribers like a real node
+type fakeNode struct {
+	mu     sync.Mutex
+	posts  chan post
+	events chan tui.Message
+}
+
+func newFakeNode() *fakeNode {
+	return &fakeNode{posts: make(chan post, 10), events: make(chan tui.Message, 10)}
+}
+
+func (f *fakeNode) Post(room, from, text string) error {
+	f.posts <- post{room, from, text}
+	if room == "general" {
+		room = ""
+	}
+	f.events <- tui.Message{From: from, Text: text, Room: room}
+	return nil
+}
+
+func (f *fakeNode) Subscribe(buf int) (<-chan tui.Message, func()) {
+	return f.events, func() {}
+}
+
+// ircServer is a minimal stand-in for an IRC server. It accepts one client
+// at a time, answers registration and records the lines it receives.
+type ircServer struct {
+	ln    net.Listener
+	lines chan string
+	mu    sync.Mutex
+	conn  net.Conn
+}
+
+func startServer(t *testing.T) *ircServer {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ircServer{ln: ln, lines: make(chan string, 100)}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conn = conn
+			s.mu.Unlock()
+			s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ircServer) serve(conn net.Conn) {
+	defer conn.Close()
+	sc := bufio.NewScanner(conn)
+	nick, user := "", false
+	for sc.Scan() {
+		line := sc.Text()
+		_, cmd, params := parseLine(line)
+		switch cmd {
+		case "NICK", "USER":
+			// Registration completes once there is a usable nick and a user
+			if cmd == "USER" {
+				user = true
+			} else if params[0] == "taken" {
+				s.send(":irc.test 433 * taken :Nickname is already in use")
+			} else {
+				nick = params[0]
+			}
+			if nick != "" && user {
+				s.send(":irc.test 001 " + nick + " :Welcome")
+			}
+		case "JOIN":
+			s.send(":" + nick + "!u@h JOIN " + params[0])
+		default:
+			s.lines <- line
+		}
+	}
+}
+
+func (s *ircServer) send(line string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Write([]byte(line + "\r\n"))
+}
+
+// hangUp drops the current client connection
+func (s *ircServer) hangUp() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.conn.Close()
+}
+
+func (s *ircServer) next(t *testing.T) string {
+	t.Helper()
+	select {
+	case line := <-s.lines:
+		return line
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the bridge to send to IRC")
+		return ""
+	}
+}
+
+func nextPost(t *testing.T, n *fakeNode) post {
+	t.Helper()
+	select {
+	case p := <-n.posts:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for the bridge to post to gochat")
+		return post{}
+	}
+}
+
+func startBridge(t *testing.T, srv *ircServer, nick string) *fakeNode {
+	t.Helper()
+	node := newFakeNode()
+	b := New(node, config.IRC{Server: srv.ln.Addr().String(), Nick: nick, Channel: "#team", Room: "general"}, nil)
+	b.minRedial = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return node
+}
+
+// waitJoined waits until the bridge relays to IRC, which it only does
+// once it is in the channel
+func waitJoined(t *testing.T, srv *ircServer, node *fakeNode) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		node.events <- tui.Message{From: "probe", Text: "ping"}
+		select {
+		case line := <-srv.lines:
+			if strings.Contains(line, "<probe> ping") {
+				return
+			}
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	t.Fatal("Bridge never joined the channel")
+}
+
+func TestBridgeRelaysBothWays(t *testing.T) {
+	srv := startServer(t)
+	node := startBridge(t, srv, "gobridge")
+	waitJoined(t, srv, node)
+
+	srv.send(":bob!b@h PRIVMSG #team :hello from irc")
+	if p := nextPost(t, node); p != (post{"general", "bob@irc", "hello from irc"}) {
+		t.Errorf("Unexpected post %+v", p)
+	}
+	srv.send(":bob!b@h PRIVMSG #team :\x01ACTION waves\x01")
+	if p := nextPost(t, node); p.text != "* bob waves" {
+		t.Errorf("Unexpected action post %+v", p)
+	}
+
+	// Other channels, private messages and our own nick are not relayed
+	srv.send(":bob!b@h PRIVMSG #other :elsewhere")
+	srv.send(":bob!b@h PRIVMSG gobridge :psst")
+	srv.send(":gobridge!u@h PRIVMSG #team :echo")
+	srv.send("PING :irc.test")
+	if line := srv.next(t); line != "PONG irc.test" {
+		t.Errorf("Expected PONG, got %q", line)
+	}
+	select {
+	case p := <-node.posts:
+		t.Errorf("Unexpected post %+v", p)
+	default:
+	}
+
+	// Chat goes to IRC attributed to its sender, line by line
+	node.events <- tui.Message{From: "alice", Text: "hi irc\nsecond line"}
+	if line := srv.next(t); line != "PRIVMSG #team :<alice> hi irc" {
+		t.Errorf("Unexpected line %q", line)
+	}
+	if line := srv.next(t); line != "PRIVMSG #team :<alice> second line" {
+		t.Errorf("Unexpected line %q", line)
+	}
+
+	// Messages that came from IRC, notices and other rooms stay put
+	node.events <- tui.Message{From: "carol@irc", Text: "loop"}
+	node.events <- tui.Message{From: "System", Text: "bob joined the chat"}
+	node.events <- tui.Message{From: "alice", Text: "builds only", Room: "builds"}
+	node.events <- tui.Message{From: "alice", Text: "last"}
+	if line := srv.next(t); line != "PRIVMSG #team :<alice> last" {
+		t.Errorf("Expected only the last message, got %q", line)
+	}
+}
+
+func TestBridgeReconnects(t *testing.T) {
+	srv := startServer(t)
+	node := startBridge(t, srv, "taken")
+	waitJoined(t, srv, node)
+
+	srv.hangUp()
+	waitJoined(t, srv, node)
+	srv.send(":bob!b@h PRIVMSG #team :back again")
+	if p := nextPost(t, node); p.text != "back again" {
+		t.Errorf("Unexpected post %+v", p)
+	}
+}
+
+func TestPacer(t *testing.T) {
+	var p pacer
+	start := time.Now()
+	for i := range relayBurst {
+		if wait := p.take(start); wait != 0 {
+			t.Fatalf("Expected line %d of the burst to go at once, got a wait of %v", i+1, wait)
+		}
+	}
+	cases := []struct {
+		after time.Duration
+		wait time.Duration
+	}{
+		{0, relayInterval},
+		{relayInterval / 2, relayInterval / 2},
+		{relayInterval, 0},
+		{relayInterval, relayInterval},
+		{2 * relayInterval, 0},
+		{2 * relayInterval, relayInterval},
+	}
+	for _, c := range cases {
+		if wait := p.take(start.Add(c.after)); wait != c.wait {
+			t.Errorf("take after %v waits %v, want %v", c.after, wait, c.wait)
+		}
+	}
+
+	// Left alone the bucket fills up again
+	for range relayBurst {
+		if wait := p.take(start.Add(time.Minute)); wait != 0 {
+			t.Errorf("Expected a full burst after a quiet minute, got a wait of %v", wait)
+		}
+	}
+}
+
+func TestBridgePacesLongMessages(t *testing.T) {
+	srv := startServer(t)
+	node := startBridge(t, srv, "gobridge")
+	waitJoined(t, srv, node)
+
+	// The probe used one line of the burst
+	node.events <- tui.Message{From: "alice", Text: "1\n2\n3\n4\n5\n6"}
+	for i := 1; i < relayBurst; i++ {
+		if line := srv.next(t); line != fmt.Sprintf("PRIVMSG #team :<alice> %d", i) {
+			t.Errorf("Unexpected line %q", line)
+		}
+	}
+	select {
+	case line := <-srv.lines:
+		t.Errorf("Expected a pause after the burst, got %q", line)
+	case <-time.After(relayInterval / 2):
+	}
+	if line := srv.next(t); line != "PRIVMSG #team :<alice> 5" {
+		t.Errorf("Unexpected line %q", line)
+	}
+}
+
+func TestParseLine(t *testing.T) {
+	prefix, cmd, params := parseLine(":nick!user@host PRIVMSG #chan :hello: there\r\n")
+	if prefix != "nick!user@host" || cmd != "PRIVMSG" || len(params) != 2 || params[1] != "hello: there" {
+		t.Errorf("Unexpected parse %q %q %q", prefix, cmd, params)
+	}
+	_, cmd, params = parseLine("@time=now ping :token")
+	if cmd != "PING" || len(params) != 1 || params[0] != "token" {
+		t.Errorf("Unexpected parse %q %q", cmd, params)
+	}
+	if nickOf("nick!user@host") != "nick" || nickOf("irc.test") != "irc.test" {
+		t.Error("nickOf failed")
+	}
+}
@@ -2,24 +2,31 @@ package netx
 
 import (
 	"context"
-	"fmt"
+	"crypto/tls"
 	"gochat/internal/chat"
-	"gochat/internal/util"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 )
 
-func Dail(addr string) (net.Conn, error) {
-    conn, err := net.Dial("tcp", addr)
+func Dail(addr string, log *slog.Logger) (net.Conn, error) {
+    var conn net.Conn
+    var err error
+    if conf := currentTLS(); conf != nil {
+        conn, err = tls.Dial("tcp", addr, conf)
+    } else {
+        conn, err = net.Dial("tcp", addr)
+    }
     if err != nil {
-        fmt.Println(util.Error, "Failed to connect to", addr, ":", err)
+        log.Debug("dial failed", "addr", addr, "err", err)
         return nil, err
     }
+    log.Debug("dialed peer", "addr", addr)
     return conn, nil
 }
 
-func DailPeers(ctx context.Context, peers []string, name string, wg *sync.WaitGroup, room *chat.ChatRoom) {
+func DailPeers(ctx context.Context, peers []string, name string, wg *sync.WaitGroup, room *chat.ChatRoom, log *slog.Logger) {
     defer wg.Done()
     
     if len(peers) == 0 {
@@ -36,19 +43,10 @@ func DailPeers(ctx context.Context, peers []string, name string, wg *sync.WaitGr
                 continue
             }
             
-            conn, err := Dail(peer)
-            if err != nil {
-                fmt.Println(util.Warning, "Failed to connect to peer", peer, ":", err)
+            if err := ConnectPeer(ctx, peer, name, room, log); err != nil {
+                log.Warn("failed to connect to peer", "addr", peer, "err", err)
                 continue
             }
-
-            connCtx, connCancel := context.WithCancel(ctx)
-            
-            go func() {
-                defer connCancel()
-                defer conn.Close()
-                chat.PeerHandler(connCtx, conn, name, room)
-            }()
             
             // Small delay to prevent overwhelming the system
             time.Sleep(100 * time.Millisecond)
@@ -56,3 +54,19 @@ func DailPeers(ctx context.Context, peers []string, name string, wg *sync.WaitGr
     }
 }
 
+// ConnectPeer dials addr and runs the peer handshake and message loop in
+// the background until ctx is cancelled or the connection drops.
+func ConnectPeer(ctx context.Context, addr, name string, room *chat.ChatRoom, log *slog.Logger) error {
+    conn, err := Dail(addr, log)
+    if err != nil {
+        return err
+    }
+
+    connCtx, connCancel := context.WithCancel(ctx)
+    go func() {
+        defer connCancel()
+        defer conn.Close()
+        chat.PeerHandler(connCtx, conn, name, room)
+    }()
+    return nil
+}
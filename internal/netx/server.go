@@ -2,35 +2,42 @@ package netx
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"gochat/internal/chat"
-	"gochat/internal/util"
+	"log/slog"
 	"net"
 	"sync"
 )
 
 
-func Listen(port int) (net.Listener, error) {
+func Listen(port int, log *slog.Logger) (net.Listener, error) {
     address := fmt.Sprintf(":%d", port);
     ln, error := net.Listen("tcp", address);
     if error != nil {
-        fmt.Println(util.Error, "Failed to listen on port", port, ":", error)
+        log.Error("failed to listen", "port", port, "err", error)
         return nil, error
     }
+    if conf := currentTLS(); conf != nil {
+        ln = tls.NewListener(ln, conf)
+    }
+    log.Info("listening", "addr", ln.Addr(), "tls", currentTLS() != nil)
     return ln, nil
 }
 
-func AcceptConnections(ctx context.Context, ln net.Listener, name string, wg *sync.WaitGroup, room *chat.ChatRoom) {
+func AcceptConnections(ctx context.Context, ln net.Listener, name string, wg *sync.WaitGroup, room *chat.ChatRoom, log *slog.Logger) {
     defer wg.Done()
     defer ln.Close()
     
     // Create a channel to signal when listener should stop
     done := make(chan struct{})
     
-    // Start a goroutine to handle context cancellation
+    // Start a goroutine to handle context cancellation. Closing the
+    // listener unblocks Accept.
     go func() {
         <-ctx.Done()
         close(done)
+        ln.Close()
     }()
     
     for {
@@ -40,9 +47,13 @@ func AcceptConnections(ctx context.Context, ln net.Listener, name string, wg *sy
         default:
             conn, err := ln.Accept()
             if err != nil {
-                fmt.Println(util.Error, "Failed to accept connection:", err)
+                if ctx.Err() != nil {
+                    return
+                }
+                log.Error("failed to accept connection", "err", err)
                 continue
             }
+            log.Debug("accepted connection", "addr", conn.RemoteAddr())
             
             connCtx, connCancel := context.WithCancel(ctx)
             
@@ -54,4 +65,3 @@ func AcceptConnections(ctx context.Context, ln net.Listener, name string, wg *sy
         }
     }
 }
-
@@ -0,0 +1,55 @@
+package netx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+)
+
+var (
+    tlsMu sync.Mutex
+    tlsConfig *tls.Config
+)
+
+// LoadTLS builds the configuration used for both accepting and dialing.
+// With a CA bundle, peers must present certificates signed by it in both
+// directions; without one, servers are checked against the system roots.
+func LoadTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+    cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        return nil, fmt.Errorf("load TLS key pair: %w", err)
+    }
+    conf := &tls.Config{
+        Certificates: []tls.Certificate{cert},
+        MinVersion: tls.VersionTLS13,
+    }
+    if caFile != "" {
+        pem, err := os.ReadFile(caFile)
+        if err != nil {
+            return nil, fmt.Errorf("read TLS CA bundle: %w", err)
+        }
+        pool := x509.NewCertPool()
+        if !pool.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("no certificates found in %s", caFile)
+        }
+        conf.RootCAs = pool
+        conf.ClientCAs = pool
+        conf.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+    return conf, nil
+}
+
+// SetTLSConfig makes Listen and Dail use TLS. Pass nil to go back to plain TCP.
+func SetTLSConfig(conf *tls.Config) {
+    tlsMu.Lock()
+    defer tlsMu.Unlock()
+    tlsConfig = conf
+}
+
+func currentTLS() *tls.Config {
+    tlsMu.Lock()
+    defer tlsMu.Unlock()
+    return tlsConfig
+}
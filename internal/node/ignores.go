@@ -0,0 +1,232 @@
+package node
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/config"
+)
+
+// How long /mute lasts when no time is given
+const defaultMute = time.Hour
+
+// ignoreLists are the peers the user is ignoring, muting and blocking
+type ignoreLists struct {
+    Ignore []string
+    Mute map[string]time.Time
+    Block []string
+}
+
+// applyFilters hands the room the lists, dropping mutes that have run out
+func (n *Node) applyFilters() {
+    n.filterMu.Lock()
+    f := chat.Filters{
+        Ignore: slices.Clone(n.ignores.Ignore),
+        Mute: make(map[string]time.Time),
+        Block: slices.Clone(n.ignores.Block),
+        RequireIdentity: n.cfg.RequireIdentity,
+    }
+    now := time.Now()
+    for name, until := range n.ignores.Mute {
+        if now.Before(until) {
+            f.Mute[name] = until
+        } else {
+            delete(n.ignores.Mute, name)
+        }
+    }
+    n.filterMu.Unlock()
+    n.room.SetFilters(f)
+}
+
+// changeFilters applies change to the lists, then applies them and saves
+// them to the profile in use, or the default one, in the config file
+func (n *Node) changeFilters(change func(l *ignoreLists) error) error {
+    n.filterMu.Lock()
+    err := change(&n.ignores)
+    n.filterMu.Unlock()
+    if err != nil {
+        return err
+    }
+    n.applyFilters()
+    if n.cfg.File == "" {
+        return nil
+    }
+    profile := n.cfg.Profile
+    if profile == "" {
+        profile = "default"
+    }
+    n.filterMu.Lock()
+    defer n.filterMu.Unlock()
+    return config.SaveFilters(n.cfg.File, profile, n.ignores.Ignore, n.ignores.Block, n.ignores.Mute)
+}
+
+// filterCommand runs /ignore, /unignore, /mute, /unmute, /block, /unblock
+// and /ignores. handled is false for any other line.
+func (n *Node) filterCommand(line string) (handled bool, err error) {
+    fields := strings.Fields(line)
+    switch {
+    case len(fields) == 1 && fields[0] == "/ignores":
+        n.Notify(n.describeFilters())
+        return true, nil
+    case len(fields) == 0 || !slices.Contains([]string{"/ignore", "/unignore", "/mute", "/unmute", "/block", "/unblock"}, fields[0]):
+        return false, nil
+    case len(fields) < 2 || len(fields) > 3 || (len(fields) == 3 && fields[0] != "/mute"):
+        if fields[0] == "/mute" {
+            return true, fmt.Errorf("usage: /mute <peer> [duration]")
+        }
+        return true, fmt.Errorf("usage: %s <peer>", fields[0])
+    }
+
+    target := fields[1]
+    switch fields[0] {
+    case "/ignore":
+        err = n.changeFilters(func(l *ignoreLists) error {
+            l.Ignore = addTarget(l.Ignore, target)
+            return nil
+        })
+        if err == nil {
+            n.Notify(fmt.Sprintf("Ignoring %s · /unignore %s to see them again", target, target))
+        }
+    case "/unignore":
+        err = n.changeFilters(func(l *ignoreLists) error {
+            return removeTarget(&l.Ignore, target, "ignoring")
+        })
+        if err == nil {
+            n.Notify("No longer ignoring " + target)
+        }
+    case "/mute":
+        d := defaultMute
+        if len(fields) == 3 {
+            if d, err = time.ParseDuration(fields[2]); err != nil || d <= 0 {
+                return true, fmt.Errorf("mute time %q must be a duration such as 30m", fields[2])
+            }
+        }
+        until := time.Now().Add(d)
+        err = n.changeFilters(func(l *ignoreLists) error {
+            if l.Mute == nil {
+                l.Mute = make(map[string]time.Time)
+            }
+            l.Mute[target] = until
+            return nil
+        })
+        if err == nil {
+            n.Notify(fmt.Sprintf("Muted %s until %s", target, until.Format("15:04")))
+        }
+    case "/unmute":
+        err = n.changeFilters(func(l *ignoreLists) error {
+            if _, ok := l.Mute[target]; !ok {
+                return fmt.Errorf("%s is not muted", target)
+            }
+            delete(l.Mute, target)
+            return nil
+        })
+        if err == nil {
+            n.Notify("Unmuted " + target)
+        }
+    case "/block":
+        block, who, berr := n.blockTarget(target)
+        if berr != nil {
+            return true, berr
+        }
+        err = n.changeFilters(func(l *ignoreLists) error {
+            l.Block = addTarget(l.Block, block)
+            return nil
+        })
+        if err == nil {
+            n.Notify(fmt.Sprintf("Blocked %s · /unblock %s to allow them again", who, block))
+        }
+    case "/unblock":
+        block, _, _ := n.blockTarget(target)
+        err = n.changeFilters(func(l *ignoreLists) error {
+            err := removeTarget(&l.Block, target, "blocking")
+            if err != nil && block != "" {
+                err = removeTarget(&l.Block, block, "blocking")
+            }
+            return err
+        })
+        if err == nil {
+            n.Notify("Unblocked " + target)
+        }
+    }
+    return true, err
+}
+
+// blockTarget turns a peer name into what a block names it by: its node ID
+// if it has one, otherwise the host it connects from. Node IDs and IP
+// addresses are used as given; anything else is an error, as a block on
+// it would never match. who describes the result for the user.
+func (n *Node) blockTarget(target string) (block, who string, err error) {
+    id, addr, ok := n.room.LookupPeer(target)
+    switch {
+    case ok && id != "":
+        return id, target + " (" + id + ")", nil
+    case ok:
+        if host, _, err := net.SplitHostPort(addr); err == nil {
+            return host, target + " (" + host + ")", nil
+        }
+    case isNodeID(target) || isAddress(target):
+        return target, target, nil
+    }
+    return "", "", fmt.Errorf("no peer %s to block: give a connected or known peer, a node ID or an IP address", target)
+}
+
+// isNodeID reports whether s looks like a node ID
+func isNodeID(s string) bool {
+    if len(s) != 32 {
+        return false
+    }
+    _, err := hex.DecodeString(s)
+    return err == nil
+}
+
+// isAddress reports whether s is an IP address, with or without a port
+func isAddress(s string) bool {
+    if host, _, err := net.SplitHostPort(s); err == nil {
+        s = host
+    }
+    return net.ParseIP(s) != nil
+}
+
+// describeFilters lists what is ignored, muted and blocked for /ignores
+func (n *Node) describeFilters() string {
+    n.filterMu.Lock()
+    defer n.filterMu.Unlock()
+    list := func(entries []string) string {
+        if len(entries) == 0 {
+            return "nobody"
+        }
+        return strings.Join(entries, ", ")
+    }
+    var muted []string
+    now := time.Now()
+    for name, until := range n.ignores.Mute {
+        if now.Before(until) {
+            muted = append(muted, name+" until "+until.Format("15:04"))
+        }
+    }
+    slices.Sort(muted)
+    return "Ignored: " + list(n.ignores.Ignore) +
+        " · Muted: " + list(muted) +
+        " · Blocked: " + list(n.ignores.Block)
+}
+
+func addTarget(list []string, target string) []string {
+    if slices.Contains(list, target) {
+        return list
+    }
+    return append(list, target)
+}
+
+func removeTarget(list *[]string, target, what string) error {
+    i := slices.Index(*list, target)
+    if i < 0 {
+        return fmt.Errorf("not %s %s", what, target)
+    }
+    *list = slices.Delete(*list, i, i+1)
+    return nil
+}
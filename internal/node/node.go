@@ -0,0 +1,570 @@
+// Package node runs a chat node: the listener, the dialer and the ChatRoom,
+// independent of any user interface. The TUI, the daemon and other
+// frontends drive a Node and subscribe to its events.
+package node
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/config"
+	"gochat/internal/httpapi"
+	"gochat/internal/netx"
+	"gochat/internal/script"
+	"gochat/internal/tui"
+	"gochat/internal/util"
+	"gochat/internal/webhook"
+)
+
+// Buffer between the room and the fan-out to subscribers
+const eventBuffer = 256
+
+// Chat lines and notices kept for clients that attach later
+const historySize = 500
+
+type Node struct {
+    cfg config.Config
+    log *slog.Logger
+    room *chat.ChatRoom
+    events chan tui.Message
+    peerEvents chan chat.PeerEvent
+    hooks *webhook.Dispatcher
+    scripts *script.Engine
+    ctx context.Context
+    wg sync.WaitGroup
+
+    mu sync.Mutex
+    subs map[int]chan tui.Message
+    nextSub int
+    closed bool
+    history []tui.Message
+
+    presenceMu sync.Mutex
+    lastActive time.Time // last line the user sent, typing included
+    autoAway bool // away was set by watchIdle, not the user
+    lastTyping time.Time
+    typingRoom string
+
+    filterMu sync.Mutex
+    ignores ignoreLists
+}
+
+func New(cfg config.Config, log *slog.Logger) *Node {
+    log = util.OrDiscard(log)
+    n := &Node{
+        cfg: cfg,
+        log: log,
+        room: chat.NewRoom(),
+        events: make(chan tui.Message, eventBuffer),
+        peerEvents: make(chan chat.PeerEvent, eventBuffer),
+        subs: make(map[int]chan tui.Message),
+        lastActive: time.Now(),
+    }
+    if len(cfg.Webhooks) > 0 {
+        n.hooks = webhook.New(cfg.Name, cfg.Webhooks, log)
+    }
+    if cfg.Scripts != "" {
+        n.scripts = script.New(n, cfg.Scripts, log)
+    }
+    n.room.SetLogger(log)
+    n.room.SetDownloadDir(cfg.Downloads)
+    n.room.SetTUIMessageChannel(n.events)
+    n.room.SetPeerEventChannel(n.peerEvents)
+    n.ignores = ignoreLists{Ignore: slices.Clone(cfg.Ignore), Mute: maps.Clone(cfg.Mute), Block: slices.Clone(cfg.Block)}
+    n.applyFilters()
+    return n
+}
+
+func (n *Node) Name() string {
+    return n.cfg.Name
+}
+
+func (n *Node) Config() config.Config {
+    return n.cfg
+}
+
+func (n *Node) Room() *chat.ChatRoom {
+    return n.room
+}
+
+func (n *Node) Logger() *slog.Logger {
+    return n.log
+}
+
+// Start begins listening and dials the configured peers. The node runs
+// until ctx is cancelled; Wait blocks until it has shut down.
+func (n *Node) Start(ctx context.Context) error {
+    if err := n.loadState(); err != nil {
+        return err
+    }
+    if n.cfg.TLS.Enabled() {
+        conf, err := netx.LoadTLS(n.cfg.TLS.Cert, n.cfg.TLS.Key, n.cfg.TLS.CA)
+        if err != nil {
+            return err
+        }
+        netx.SetTLSConfig(conf)
+    }
+
+    ln, err := netx.Listen(n.cfg.Port, n.log)
+    if err != nil {
+        return fmt.Errorf("failed to listen on port %d: %w", n.cfg.Port, err)
+    }
+    var httpLn net.Listener
+    if n.cfg.HTTP.Enabled() {
+        if httpLn, err = net.Listen("tcp", n.cfg.HTTP.Listen); err != nil {
+            ln.Close()
+            return fmt.Errorf("failed to start HTTP endpoint: %w", err)
+        }
+    }
+
+    n.ctx = ctx
+    n.wg.Add(3)
+    go netx.AcceptConnections(ctx, ln, n.cfg.Name, &n.wg, n.room, n.log)
+    go netx.DailPeers(ctx, n.cfg.Peers, n.cfg.Name, &n.wg, n.room, n.log)
+    go n.fanout(ctx)
+    if n.cfg.AwayAfter > 0 {
+        n.wg.Add(1)
+        go n.watchIdle(ctx)
+    }
+    if httpLn != nil {
+        n.serveHTTP(ctx, httpLn)
+    }
+    if n.hooks != nil {
+        n.wg.Add(1)
+        go func() {
+            defer n.wg.Done()
+            n.hooks.Run(ctx)
+        }()
+    }
+    if n.scripts != nil {
+        n.wg.Add(1)
+        go func() {
+            defer n.wg.Done()
+            n.scripts.Run(ctx)
+        }()
+    }
+    return nil
+}
+
+// loadState gives the room the node's identity and the messages kept for
+// absent peers. A node without a data directory has neither.
+func (n *Node) loadState() error {
+    if n.cfg.DataDir == "" {
+        return nil
+    }
+    id, err := chat.LoadIdentity(filepath.Join(n.cfg.DataDir, "node_key"))
+    if err != nil {
+        return fmt.Errorf("failed to load node key: %w", err)
+    }
+    n.room.SetIdentity(id)
+    if n.cfg.OfflineTTL > 0 {
+        if err := n.room.SetOfflineStore(n.cfg.DataDir, n.cfg.OfflineTTL); err != nil {
+            return fmt.Errorf("failed to load offline messages: %w", err)
+        }
+    }
+    return nil
+}
+
+func (n *Node) serveHTTP(ctx context.Context, ln net.Listener) {
+    srv := httpapi.New(n, n.cfg.HTTP, n.log)
+    n.wg.Add(1)
+    go func() {
+        defer n.wg.Done()
+        if err := srv.Serve(ctx, ln); err != nil {
+            n.log.Error("HTTP endpoint stopped", "err", err)
+        }
+    }()
+    n.log.Info("HTTP endpoint listening", "addr", ln.Addr())
+}
+
+// Wait blocks until the node has stopped, then closes all peer connections
+func (n *Node) Wait() {
+    n.wg.Wait()
+    n.room.Shutdown()
+}
+
+// Send handles a line typed by a user: slash commands are run, anything
+// else is broadcast to all peers and echoed to subscribers. "/room <name>
+// <text>" sends text to a room other than the default one, and "/edit
+// <id> <text>" and "/delete <id>" change a message the user sent.
+// "/react <id> <emoji>" reacts to a message, or takes the reaction back if
+// the user already reacted with that emoji, and "/reply <id> <text>"
+// answers a message in its room. "/status <online|away|busy> [note]" sets
+// the user's presence and "/typing [room]" tells peers they are writing.
+// "/info <id>" shows who has received and read a message the user sent,
+// "/retry <id>" sends it again to peers it failed to reach, and "/read
+// <id>..." tells senders their messages have been seen. "/ignore",
+// "/mute <name> [duration]" and "/block" hide or refuse a peer, each undone
+// with its "/un" form, and "/ignores" lists them.
+func (n *Node) Send(text string) error {
+    line := strings.TrimSpace(text)
+    if line == "" {
+        return nil
+    }
+    if rest, ok := strings.CutPrefix(line, "/read "); ok {
+        // Sent as messages come on screen, so not a sign the user is there
+        n.markRead(strings.Fields(rest))
+        return nil
+    }
+    n.active()
+    if ok, err := n.filterCommand(line); ok {
+        return err
+    }
+    if rest, ok := strings.CutPrefix(line, "/info "); ok {
+        return n.info(strings.TrimSpace(rest))
+    }
+    if rest, ok := strings.CutPrefix(line, "/retry "); ok {
+        return n.room.Retry(strings.TrimSpace(rest))
+    }
+    if line == "/status" {
+        return n.setStatus("")
+    }
+    if rest, ok := strings.CutPrefix(line, "/status "); ok {
+        return n.setStatus(rest)
+    }
+    if line == "/typing" {
+        return n.typing("")
+    }
+    if rest, ok := strings.CutPrefix(line, "/typing "); ok {
+        return n.typing(rest)
+    }
+    if rest, ok := strings.CutPrefix(line, "/room "); ok {
+        room, text, _ := strings.Cut(strings.TrimLeft(rest, " "), " ")
+        text = trimMessage(text)
+        if !chat.ValidRoom(room) || text == "" {
+            return fmt.Errorf("usage: /room <name> <text>")
+        }
+        return n.say(room, "", text)
+    }
+    if rest, ok := strings.CutPrefix(line, "/reply "); ok {
+        id, text, _ := strings.Cut(strings.TrimLeft(rest, " "), " ")
+        text = trimMessage(text)
+        if text == "" {
+            return fmt.Errorf("usage: /reply <id> <text>")
+        }
+        parent, ok := n.message(id)
+        if !ok {
+            return fmt.Errorf("no message %s to reply to", id)
+        }
+        return n.say(parent.Room, id, text)
+    }
+    if rest, ok := strings.CutPrefix(line, "/edit "); ok {
+        id, text, _ := strings.Cut(strings.TrimLeft(rest, " "), " ")
+        text = trimMessage(text)
+        if text == "" {
+            return fmt.Errorf("usage: /edit <id> <text>")
+        }
+        return n.edit(id, text)
+    }
+    if rest, ok := strings.CutPrefix(line, "/delete "); ok {
+        return n.delete(strings.TrimSpace(rest))
+    }
+    if rest, ok := strings.CutPrefix(line, "/react "); ok {
+        fields := strings.Fields(rest)
+        if len(fields) != 2 {
+            return fmt.Errorf("usage: /react <id> <emoji>")
+        }
+        return n.react(fields[0], fields[1])
+    }
+    if strings.HasPrefix(line, "/") {
+        return n.room.RunCommand(line)
+    }
+    return n.say("", "", trimMessage(text))
+}
+
+// trimMessage drops blank lines around a message and trailing spaces, but
+// keeps the indentation of its first line, which matters in pasted code
+func trimMessage(text string) string {
+    text = strings.TrimRight(text, " \t\r\n")
+    for {
+        line, rest, ok := strings.Cut(text, "\n")
+        if !ok || strings.TrimSpace(line) != "" {
+            return text
+        }
+        text = rest
+    }
+}
+
+// say broadcasts a chat line from the local user, answering the message
+// replyTo if it is set
+func (n *Node) say(room, replyTo, text string) error {
+    if room == chat.DefaultRoom {
+        room = ""
+    }
+    env := chat.NewChat(n.cfg.Name, text)
+    env.Room = room
+    env.ReplyTo = replyTo
+    env, ok := chat.Broadcast(n.room, env)
+    if !ok {
+        return fmt.Errorf("message was dropped by a filter")
+    }
+    receipts, _ := n.room.Receipts(env.ID)
+    n.publish(tui.Message{From: n.cfg.Name, Text: env.Text, ID: env.ID, Self: true, Room: room, ReplyTo: env.ReplyTo, Annotations: env.Annotations, Receipts: receipts})
+    if failed := n.Undelivered(env.ID); len(failed) > 0 {
+        return &UndeliveredError{ID: env.ID, Text: env.Text, Peers: failed}
+    }
+    return nil
+}
+
+// edit replaces the text of a message the local user sent
+func (n *Node) edit(id, text string) error {
+    msg, ok := n.ownMessage(id)
+    if !ok {
+        return fmt.Errorf("no message %s of yours to edit", id)
+    }
+    env := chat.NewEdit(n.cfg.Name, id, text)
+    env.Room = msg.Room
+    env, ok = chat.Broadcast(n.room, env)
+    if !ok {
+        return fmt.Errorf("edit was dropped by a filter")
+    }
+    n.publish(tui.Message{Kind: tui.KindEdit, From: n.cfg.Name, Text: env.Text, ID: id, Self: true, Room: msg.Room, Annotations: env.Annotations})
+    return nil
+}
+
+// delete retracts a message the local user sent
+func (n *Node) delete(id string) error {
+    msg, ok := n.ownMessage(id)
+    if !ok {
+        return fmt.Errorf("no message %s of yours to delete", id)
+    }
+    env := chat.NewDelete(n.cfg.Name, id)
+    env.Room = msg.Room
+    if _, ok := chat.Broadcast(n.room, env); !ok {
+        return fmt.Errorf("delete was dropped by a filter")
+    }
+    n.publish(tui.Message{Kind: tui.KindDelete, From: n.cfg.Name, ID: id, Self: true, Room: msg.Room})
+    return nil
+}
+
+// react toggles the local user's reaction to a message
+func (n *Node) react(id, code string) error {
+    emoji, ok := chat.Reaction(code)
+    if !ok {
+        return fmt.Errorf("%q is not an emoji or :short_code:", code)
+    }
+    msg, ok := n.message(id)
+    if !ok {
+        return fmt.Errorf("no message %s to react to", id)
+    }
+    remove := false
+    for _, r := range msg.Reactions {
+        remove = remove || (r.Emoji == emoji && r.Self)
+    }
+    env := chat.NewReaction(n.cfg.Name, id, emoji, remove)
+    env.Room = msg.Room
+    if _, ok := chat.Broadcast(n.room, env); !ok {
+        return fmt.Errorf("reaction was dropped by a filter")
+    }
+    kind := tui.KindReact
+    if remove {
+        kind = tui.KindUnreact
+    }
+    n.publish(tui.Message{Kind: kind, From: n.cfg.Name, Text: emoji, ID: id, Self: true, Room: msg.Room})
+    return nil
+}
+
+// message finds a chat line that is still in history
+func (n *Node) message(id string) (tui.Message, bool) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    for _, msg := range n.history {
+        if msg.ID != "" && msg.ID == id && msg.From != "System" {
+            return msg, true
+        }
+    }
+    return tui.Message{}, false
+}
+
+// ownMessage finds a message the local user sent that is still in history
+func (n *Node) ownMessage(id string) (tui.Message, bool) {
+    msg, ok := n.message(id)
+    return msg, ok && msg.Self
+}
+
+// Post broadcasts text to room on behalf of from, a bot or bridge rather
+// than the local user, and shows it to subscribers
+func (n *Node) Post(room, from, text string) error {
+    text = strings.TrimSpace(text)
+    if text == "" {
+        return fmt.Errorf("empty message")
+    }
+    if room == chat.DefaultRoom {
+        room = ""
+    }
+    env := chat.NewChat(from, text)
+    env.Room = room
+    env, ok := chat.Broadcast(n.room, env)
+    if !ok {
+        return fmt.Errorf("message was dropped by a filter")
+    }
+    n.publish(tui.Message{From: from, Text: env.Text, ID: env.ID, Room: room, Annotations: env.Annotations})
+    return nil
+}
+
+// Peers lists the currently connected peers
+func (n *Node) Peers() []chat.PeerInfo {
+    return n.room.ListPeers()
+}
+
+// Presence returns the user's status and its note
+func (n *Node) Presence() (status, note string) {
+    return n.room.Presence()
+}
+
+// Flush waits until everything sent so far has been written to the peers
+func (n *Node) Flush(ctx context.Context) error {
+    return n.room.Flush(ctx)
+}
+
+// Connect dials a peer now, in addition to those from the config
+func (n *Node) Connect(addr string) error {
+    if n.ctx == nil {
+        return fmt.Errorf("node is not running")
+    }
+    return netx.ConnectPeer(n.ctx, addr, n.cfg.Name, n.room, n.log)
+}
+
+// Disconnect drops the connection to the named peer
+func (n *Node) Disconnect(name string) error {
+    return n.room.Disconnect(name)
+}
+
+// Subscribe returns a channel of everything the node would show a user:
+// chat lines, system notices, transfer progress and offers. A subscriber
+// that falls behind loses events rather than holding up the node. Call the
+// returned func to unsubscribe.
+func (n *Node) Subscribe(buf int) (<-chan tui.Message, func()) {
+    _, ch, cancel := n.SubscribeWithHistory(buf, 0)
+    return ch, cancel
+}
+
+// SubscribeWithHistory is Subscribe that also returns up to limit of the
+// most recent chat lines and notices. Nothing is missed or repeated
+// between the history and the first event on the channel.
+func (n *Node) SubscribeWithHistory(buf, limit int) ([]tui.Message, <-chan tui.Message, func()) {
+    ch := make(chan tui.Message, buf)
+
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    history := n.historyLocked(limit)
+    if n.closed {
+        close(ch)
+        return history, ch, func() {}
+    }
+    id := n.nextSub
+    n.nextSub++
+    n.subs[id] = ch
+
+    return history, ch, func() {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        if sub, ok := n.subs[id]; ok {
+            delete(n.subs, id)
+            close(sub)
+        }
+    }
+}
+
+// Notify shows a system notice to every subscriber
+func (n *Node) Notify(text string) {
+    n.publish(tui.Message{From: "System", Text: text})
+}
+
+// History returns up to limit of the most recent chat lines and notices
+func (n *Node) History(limit int) []tui.Message {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    return n.historyLocked(limit)
+}
+
+func (n *Node) historyLocked(limit int) []tui.Message {
+    if limit <= 0 {
+        return nil
+    }
+    start := max(len(n.history)-limit, 0)
+    return append([]tui.Message(nil), n.history[start:]...)
+}
+
+func (n *Node) publish(msg tui.Message) {
+    n.mu.Lock()
+    defer n.mu.Unlock()
+    // Progress, offers, presence and typing only make sense live. Edits,
+    // deletes, reactions and receipts are applied to the history so clients
+    // attaching later see the result.
+    switch msg.Kind {
+    case tui.KindText:
+        n.history = append(n.history, msg)
+        if over := len(n.history) - historySize; over > 0 {
+            n.history = append(n.history[:0], n.history[over:]...)
+        }
+    case tui.KindEdit, tui.KindDelete, tui.KindReact, tui.KindUnreact, tui.KindReceipt:
+        for i, old := range n.history {
+            if old.ID != msg.ID || old.From == "System" {
+                continue
+            }
+            switch msg.Kind {
+            case tui.KindDelete:
+                n.history = append(n.history[:i], n.history[i+1:]...)
+            case tui.KindEdit:
+                n.history[i].Text, n.history[i].Annotations, n.history[i].Edited = msg.Text, msg.Annotations, true
+            case tui.KindReceipt:
+                n.history[i].ApplyReceipt(msg)
+            default:
+                n.history[i].ApplyReaction(msg)
+            }
+            break
+        }
+    }
+    for _, ch := range n.subs {
+        select {
+        case ch <- msg:
+        default:
+            n.log.Warn("subscriber channel full, dropping event", "from", msg.From)
+        }
+    }
+}
+
+func (n *Node) fanout(ctx context.Context) {
+    defer n.wg.Done()
+    defer func() {
+        n.mu.Lock()
+        defer n.mu.Unlock()
+        n.closed = true
+        for id, ch := range n.subs {
+            delete(n.subs, id)
+            close(ch)
+        }
+    }()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case msg := <-n.events:
+            n.publish(msg)
+            if msg.Kind != tui.KindText || msg.From == "System" {
+                continue
+            }
+            if n.hooks != nil {
+                n.hooks.Message(msg.From, msg.Text)
+            }
+            if n.scripts != nil {
+                n.scripts.Message(msg)
+            }
+        case ev := <-n.peerEvents:
+            if n.hooks != nil {
+                n.hooks.Peer(ev.Name, ev.Joined)
+            }
+        }
+    }
+}
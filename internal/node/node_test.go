@@ -0,0 +1,239 @@
+package node
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gochat/internal/config"
+	"gochat/internal/tui"
+)
+
+// newTestNode returns a node that is never started, so it has no peers,
+// saving its filter lists to a config file of its own
+func newTestNode(t *testing.T) *Node {
+	t.Helper()
+	cfg := config.Config{Name: "alice", File: filepath.Join(t.TempDir(), "config.toml"), ReadReceipts: true}
+	return New(cfg, nil)
+}
+
+// latest returns the newest line in n's history
+func latest(t *testing.T, n *Node) tui.Message {
+	t.Helper()
+	h := n.History(1)
+	if len(h) == 0 {
+		t.Fatal("Expected a line in history")
+	}
+	return h[0]
+}
+
+func TestSendCommands(t *testing.T) {
+	n := newTestNode(t)
+	if err := n.Send("first"); err != nil {
+		t.Fatal(err)
+	}
+	first := latest(t, n).ID
+	if err := n.Send("second"); err != nil {
+		t.Fatal(err)
+	}
+	second := latest(t, n).ID
+
+	cases := []struct {
+		line string
+		err string // empty for success
+		check func(t *testing.T, n *Node)
+	}{
+		{"/room dev hello dev", "", func(t *testing.T, n *Node) {
+			if msg := latest(t, n); msg.Room != "dev" || msg.Text != "hello dev" || !msg.Self {
+				t.Errorf("Expected a line in dev, got %+v", msg)
+			}
+		}},
+		{"/room dev", "usage: /room", nil},
+		{"/room no/such hi", "usage: /room", nil},
+		{"/reply {first} agreed", "", func(t *testing.T, n *Node) {
+			if msg := latest(t, n); msg.ReplyTo != first || msg.Text != "agreed" {
+				t.Errorf("Expected a reply to %s, got %+v", first, msg)
+			}
+		}},
+		{"/reply {first}", "usage: /reply", nil},
+		{"/reply nope hi", "no message nope", nil},
+		{"/edit {first} first, edited", "", func(t *testing.T, n *Node) {
+			if msg, _ := n.message(first); msg.Text != "first, edited" || !msg.Edited {
+				t.Errorf("Expected the edit in history, got %+v", msg)
+			}
+		}},
+		{"/edit {first}", "usage: /edit", nil},
+		{"/edit nope hi", "no message nope of yours", nil},
+		{"/react {first} :+1:", "", func(t *testing.T, n *Node) {
+			if msg, _ := n.message(first); len(msg.Reactions) != 1 || !msg.Reactions[0].Self {
+				t.Errorf("Expected our reaction in history, got %+v", msg.Reactions)
+			}
+		}},
+		{"/react {first} :+1:", "", func(t *testing.T, n *Node) {
+			if msg, _ := n.message(first); len(msg.Reactions) != 0 {
+				t.Errorf("Expected a second reaction to take it back, got %+v", msg.Reactions)
+			}
+		}},
+		{"/react {first}", "usage: /react", nil},
+		{"/react {first} " + strings.Repeat("x", 40), "not an emoji", nil},
+		{"/react nope :+1:", "no message nope", nil},
+		{"/delete {second}", "", func(t *testing.T, n *Node) {
+			if _, ok := n.message(second); ok {
+				t.Error("Expected the deleted line gone from history")
+			}
+		}},
+		{"/delete {second}", "no message", nil},
+		{"/status away at lunch", "", func(t *testing.T, n *Node) {
+			if status, note := n.Presence(); status != "away" || note != "at lunch" {
+				t.Errorf("Expected away at lunch, got %s %q", status, note)
+			}
+		}},
+		{"/status sleepy", "usage: /status", nil},
+		{"/read {first} nope", "", nil},
+		{"/info {first}", "", func(t *testing.T, n *Node) {
+			if msg := latest(t, n); msg.From != "System" || !strings.Contains(msg.Text, "nobody was connected") {
+				t.Errorf("Expected receipts shown, got %+v", msg)
+			}
+		}},
+		{"/info nope", "no message nope", nil},
+		{"/retry {first}", "", nil},
+		{"/retry nope", "no message nope", nil},
+		{"/nonsense", "unknown command", nil},
+	}
+	for _, c := range cases {
+		line := strings.NewReplacer("{first}", first, "{second}", second).Replace(c.line)
+		err := n.Send(line)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("Send(%q) unexpected error: %v", c.line, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("Send(%q) error = %v, want it to mention %q", c.line, err, c.err)
+		case c.check != nil:
+			c.check(t, n)
+		}
+	}
+}
+
+func TestPublishRewritesHistory(t *testing.T) {
+	cases := []struct {
+		name string
+		events []tui.Message
+		want func(h []tui.Message) bool
+	}{
+		{"edit", []tui.Message{{Kind: tui.KindEdit, ID: "a", Text: "changed"}},
+			func(h []tui.Message) bool { return h[1].Text == "changed" && h[1].Edited && h[2].Text == "two" }},
+		{"delete", []tui.Message{{Kind: tui.KindDelete, ID: "a"}},
+			func(h []tui.Message) bool { return len(h) == 2 && h[1].ID == "b" }},
+		{"react", []tui.Message{{Kind: tui.KindReact, ID: "b", From: "bob", Text: "👍"}, {Kind: tui.KindReact, ID: "b", From: "carol", Text: "👍"}},
+			func(h []tui.Message) bool { return len(h[2].Reactions) == 1 && len(h[2].Reactions[0].From) == 2 }},
+		{"unreact", []tui.Message{{Kind: tui.KindReact, ID: "b", From: "bob", Text: "👍"}, {Kind: tui.KindUnreact, ID: "b", From: "bob", Text: "👍"}},
+			func(h []tui.Message) bool { return len(h[2].Reactions) == 0 }},
+		{"receipt", []tui.Message{{Kind: tui.KindReceipt, ID: "a", From: "bob", Text: "delivered"}, {Kind: tui.KindReceipt, ID: "a", From: "bob", Text: "read"}},
+			func(h []tui.Message) bool { return h[1].Receipts["bob"] == "read" && len(h[1].Receipts) == 1 }},
+		{"notices are left alone", []tui.Message{{Kind: tui.KindEdit, ID: "n", Text: "changed"}, {Kind: tui.KindDelete, ID: "n"}},
+			func(h []tui.Message) bool { return len(h) == 3 && h[0].Text == "notice" }},
+		{"live events are not kept", []tui.Message{{Kind: tui.KindPresence, From: "bob", Text: "away"}, {Kind: tui.KindTyping, From: "bob"}},
+			func(h []tui.Message) bool { return len(h) == 3 }},
+	}
+	for _, c := range cases {
+		n := newTestNode(t)
+		n.publish(tui.Message{From: "System", ID: "n", Text: "notice"})
+		n.publish(tui.Message{From: "alice", ID: "a", Text: "one", Self: true})
+		n.publish(tui.Message{From: "bob", ID: "b", Text: "two"})
+		for _, ev := range c.events {
+			n.publish(ev)
+		}
+		if h := n.History(10); !c.want(h) {
+			t.Errorf("%s: unexpected history %+v", c.name, h)
+		}
+	}
+}
+
+func TestFilterCommand(t *testing.T) {
+	n := newTestNode(t)
+	cases := []struct {
+		line string
+		handled bool
+		err string // empty for success
+	}{
+		{"hello", false, ""},
+		{"/send bob x", false, ""},
+		{"/ignore bob", true, ""},
+		{"/ignore bob carol", true, "usage: /ignore"},
+		{"/unignore carol", true, "not ignoring carol"},
+		{"/mute carol 30m", true, ""},
+		{"/mute dave soon", true, "must be a duration"},
+		{"/mute", true, "usage: /mute"},
+		{"/unmute dave", true, "dave is not muted"},
+		{"/block 10.0.0.5", true, ""},
+		{"/block 0123456789abcdef0123456789abcdef", true, ""},
+		{"/block mallory", true, "no peer mallory"},
+		{"/unblock 10.0.0.6", true, "not blocking"},
+		{"/ignores", true, ""},
+	}
+	for _, c := range cases {
+		handled, err := n.filterCommand(c.line)
+		if handled != c.handled {
+			t.Errorf("filterCommand(%q) handled = %v", c.line, handled)
+		}
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("filterCommand(%q) unexpected error: %v", c.line, err)
+		case c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)):
+			t.Errorf("filterCommand(%q) error = %v, want it to mention %q", c.line, err, c.err)
+		}
+	}
+
+	if msg := latest(t, n); !strings.Contains(msg.Text, "Ignored: bob · Muted: carol until") || !strings.Contains(msg.Text, "Blocked: 10.0.0.5, 0123456789abcdef0123456789abcdef") {
+		t.Errorf("Unexpected /ignores notice %q", msg.Text)
+	}
+	if until := n.ignores.Mute["carol"]; time.Until(until) < 29*time.Minute {
+		t.Errorf("Expected carol muted for 30m, got until %v", until)
+	}
+	b, err := os.ReadFile(n.cfg.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"[profiles.default]", `ignore = ["bob"]`, `block = ["10.0.0.5","0123456789abcdef0123456789abcdef"]`, `"carol" = `} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Expected %s in the saved config:\n%s", want, b)
+		}
+	}
+}
+
+func TestChangeFilters(t *testing.T) {
+	n := newTestNode(t)
+	n.cfg.Profile = "work"
+	if err := n.changeFilters(func(l *ignoreLists) error {
+		l.Ignore = append(l.Ignore, "bob")
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(n.cfg.File)
+	if !strings.Contains(string(b), "[profiles.work]") {
+		t.Errorf("Expected the lists saved to the profile in use:\n%s", b)
+	}
+
+	// A change that fails is not saved
+	if err := n.changeFilters(func(l *ignoreLists) error {
+		return os.ErrInvalid
+	}); err != os.ErrInvalid {
+		t.Errorf("Expected the change's error, got %v", err)
+	}
+	if after, _ := os.ReadFile(n.cfg.File); string(after) != string(b) {
+		t.Errorf("Expected the config file untouched, got:\n%s", after)
+	}
+
+	// Without a config file the lists only last for the run
+	n = newTestNode(t)
+	n.cfg.File = ""
+	if err := n.changeFilters(func(l *ignoreLists) error {
+		l.Block = append(l.Block, "10.0.0.5")
+		return nil
+	}); err != nil || len(n.ignores.Block) != 1 {
+		t.Errorf("Expected the block kept in memory, got %v, %v", n.ignores.Block, err)
+	}
+}
@@ -0,0 +1,102 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/tui"
+)
+
+// How often idle time is checked against the away-after setting
+const idleCheck = 15 * time.Second
+
+// Typing signals closer together than this are not passed on
+const typingInterval = 3 * time.Second
+
+// setStatus handles "/status <online|away|busy> [note]"
+func (n *Node) setStatus(rest string) error {
+    status, note, _ := strings.Cut(strings.TrimSpace(rest), " ")
+    switch status {
+    case chat.StatusOnline, chat.StatusAway, chat.StatusBusy:
+    default:
+        return fmt.Errorf("usage: /status <online|away|busy> [note]")
+    }
+    n.presenceMu.Lock()
+    n.autoAway = false
+    n.presenceMu.Unlock()
+    n.announce(status, note)
+    return nil
+}
+
+// announce tells peers and subscribers the local user's new status
+func (n *Node) announce(status, note string) {
+    n.room.SetPresence(n.cfg.Name, status, note)
+    status, note = n.room.Presence()
+    text := status
+    if note != "" {
+        text += ": " + note
+    }
+    n.publish(tui.Message{Kind: tui.KindPresence, From: n.cfg.Name, Text: text, Self: true})
+}
+
+// typing handles "/typing [room]", sent by a frontend while the user
+// writes. Signals come often, so most are dropped here.
+func (n *Node) typing(room string) error {
+    room = strings.TrimSpace(room)
+    if room != "" && !chat.ValidRoom(room) {
+        return fmt.Errorf("usage: /typing [room]")
+    }
+    n.presenceMu.Lock()
+    now := time.Now()
+    send := now.Sub(n.lastTyping) >= typingInterval || room != n.typingRoom
+    if send {
+        n.lastTyping, n.typingRoom = now, room
+    }
+    n.presenceMu.Unlock()
+    if send {
+        chat.Broadcast(n.room, chat.NewTyping(n.cfg.Name, room))
+    }
+    return nil
+}
+
+// active records that the user did something, bringing them back from an
+// automatic away
+func (n *Node) active() {
+    n.presenceMu.Lock()
+    n.lastActive = time.Now()
+    back := n.autoAway
+    n.autoAway = false
+    n.presenceMu.Unlock()
+    if back {
+        n.announce(chat.StatusOnline, "")
+    }
+}
+
+// watchIdle turns the status to away once the user has been idle for the
+// configured time, unless they have set a status of their own
+func (n *Node) watchIdle(ctx context.Context) {
+    defer n.wg.Done()
+    t := time.NewTicker(min(idleCheck, n.cfg.AwayAfter))
+    defer t.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-t.C:
+        }
+        status, note := n.room.Presence()
+        n.presenceMu.Lock()
+        idle := time.Since(n.lastActive) >= n.cfg.AwayAfter
+        away := idle && !n.autoAway && status == chat.StatusOnline && note == ""
+        if away {
+            n.autoAway = true
+        }
+        n.presenceMu.Unlock()
+        if away {
+            n.announce(chat.StatusAway, "idle")
+        }
+    }
+}
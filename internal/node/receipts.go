@@ -0,0 +1,100 @@
+package node
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"gochat/internal/chat"
+	"gochat/internal/tui"
+)
+
+// UndeliveredError is returned by Send for a chat message that could not
+// be handed to some connected peers. It was still shown and sent to the
+// others, and "/retry <id>" sends it to the ones it missed.
+type UndeliveredError struct {
+    ID string
+    Text string
+    Peers []string
+}
+
+func (e *UndeliveredError) Error() string {
+    return "message not delivered to " + strings.Join(e.Peers, ", ") + " · /retry " + e.ID + " to send it again"
+}
+
+// Undelivered lists the peers message id, sent by this node, failed to
+// reach
+func (n *Node) Undelivered(id string) []string {
+    receipts, _ := n.room.Receipts(id)
+    var failed []string
+    for peer, state := range receipts {
+        if state == chat.ReceiptFailed {
+            failed = append(failed, peer)
+        }
+    }
+    slices.Sort(failed)
+    return failed
+}
+
+// markRead handles "/read <id>...", sent by a frontend once messages have
+// been on screen. Nothing is sent if read receipts are turned off.
+func (n *Node) markRead(ids []string) {
+    if !n.cfg.ReadReceipts {
+        return
+    }
+    for _, id := range ids {
+        if msg, ok := n.message(id); ok && !msg.Self {
+            n.room.MarkRead(id)
+        }
+    }
+}
+
+// info shows who has received and read one of the user's messages
+func (n *Node) info(id string) error {
+    msg, ok := n.message(id)
+    if !ok {
+        return fmt.Errorf("no message %s", id)
+    }
+    receipts, ok := n.room.Receipts(id)
+    if !msg.Self || !ok {
+        return fmt.Errorf("receipts are only kept for messages you sent")
+    }
+    byState := make(map[string][]string)
+    for peer, state := range receipts {
+        byState[state] = append(byState[state], peer)
+    }
+    var parts []string
+    for _, s := range []struct{ state, label string }{
+        {chat.ReceiptRead, "read by"},
+        {chat.ReceiptDelivered, "delivered to"},
+        {chat.ReceiptSent, "sent to"},
+        {chat.ReceiptQueued, "queued for"},
+        {chat.ReceiptFailed, "not delivered to"},
+    } {
+        if peers := byState[s.state]; len(peers) > 0 {
+            slices.Sort(peers)
+            parts = append(parts, s.label+" "+strings.Join(peers, ", "))
+        }
+    }
+    text := "nobody was connected when it was sent"
+    if len(parts) > 0 {
+        text = strings.Join(parts, "; ")
+    }
+    if len(byState[chat.ReceiptFailed]) > 0 {
+        text += " · /retry " + id + " to send it again"
+    }
+    n.publish(tui.Message{From: "System", Text: "Message " + quote(msg.Text) + ": " + text, Room: msg.Room})
+    return nil
+}
+
+// quote shortens text to a one-line excerpt
+func quote(text string) string {
+    line, _, more := strings.Cut(strings.TrimSpace(text), "\n")
+    if r := []rune(line); len(r) > 40 {
+        line, more = string(r[:40]), true
+    }
+    if more {
+        line += "…"
+    }
+    return fmt.Sprintf("%q", line)
+}
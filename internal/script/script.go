@@ -0,0 +1,268 @@
+// Package script runs user automations written in Lua. Every *.lua file in
+// the scripts directory is loaded into its own sandboxed interpreter, which
+// has the string, table and math libraries but no io, os or require. A
+// script talks to the node through a few globals:
+//
+//    send(room, text)   post text to a room as this node
+//    on_message(fn)     call fn({from=, text=, room=}) for each chat line from a peer
+//    peers()            names of the connected peers
+//    print(...)         show a system message
+//
+// The directory is polled for changes; an edited script is reloaded and a
+// deleted one unloaded. Script errors are shown as system messages and
+// never take the node down.
+package script
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+
+	"gochat/internal/chat"
+	"gochat/internal/tui"
+	"gochat/internal/util"
+)
+
+// Chat lines waiting for the scripts; more are dropped with a warning
+const queueSize = 256
+
+const (
+    pollInterval = 2 * time.Second
+    // A script gets this long to load or handle one message before it is
+    // stopped, so an endless loop cannot hang the engine
+    callTimeout = time.Second
+)
+
+// Node is what scripts need from the node they run on
+type Node interface {
+    Name() string
+    Post(room, from, text string) error
+    Peers() []chat.PeerInfo
+    Notify(text string)
+}
+
+type Engine struct {
+    node Node
+    dir string
+    log *slog.Logger
+    queue chan tui.Message
+    scripts map[string]*script // by file name
+    poll time.Duration
+    timeout time.Duration
+}
+
+type script struct {
+    name string
+    mod time.Time
+    size int64
+    L *lua.LState // nil if the script failed to load
+    handlers []*lua.LFunction
+}
+
+// New creates an engine for the scripts in dir. Nothing is loaded until Run.
+func New(n Node, dir string, log *slog.Logger) *Engine {
+    return &Engine{
+        node: n,
+        dir: dir,
+        log: util.OrDiscard(log),
+        queue: make(chan tui.Message, queueSize),
+        scripts: make(map[string]*script),
+        poll: pollInterval,
+        timeout: callTimeout,
+    }
+}
+
+// Run loads the scripts and feeds them messages until ctx is cancelled.
+// All Lua code runs on this goroutine.
+func (e *Engine) Run(ctx context.Context) {
+    defer func() {
+        for _, s := range e.scripts {
+            s.close()
+        }
+    }()
+
+    e.scan(ctx, true)
+    ticker := time.NewTicker(e.poll)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            e.scan(ctx, false)
+        case msg := <-e.queue:
+            e.dispatch(ctx, msg)
+        }
+    }
+}
+
+// Message hands a chat line from a peer to the scripts. It never blocks.
+func (e *Engine) Message(msg tui.Message) {
+    select {
+    case e.queue <- msg:
+    default:
+        e.log.Warn("script queue full, dropping message", "from", msg.From)
+    }
+}
+
+// scan loads new and changed scripts and unloads deleted ones
+func (e *Engine) scan(ctx context.Context, initial bool) {
+    entries, err := os.ReadDir(e.dir)
+    if err != nil && !errors.Is(err, os.ErrNotExist) {
+        e.log.Warn("failed to read scripts directory", "dir", e.dir, "err", err)
+        return
+    }
+
+    seen := make(map[string]bool)
+    for _, entry := range entries {
+        if entry.IsDir() || filepath.Ext(entry.Name()) != ".lua" {
+            continue
+        }
+        info, err := entry.Info()
+        if err != nil {
+            continue
+        }
+        name := entry.Name()
+        seen[name] = true
+        old, ok := e.scripts[name]
+        if ok && old.mod.Equal(info.ModTime()) && old.size == info.Size() {
+            continue
+        }
+        if ok {
+            old.close()
+        }
+        s := e.load(ctx, name, info)
+        e.scripts[name] = s
+        if s.L != nil && !initial {
+            e.node.Notify(fmt.Sprintf("Script %s reloaded", name))
+        }
+    }
+    for name, s := range e.scripts {
+        if !seen[name] {
+            s.close()
+            delete(e.scripts, name)
+            e.node.Notify(fmt.Sprintf("Script %s unloaded", name))
+        }
+    }
+}
+
+func (e *Engine) load(ctx context.Context, name string, info os.FileInfo) *script {
+    s := &script{name: name, mod: info.ModTime(), size: info.Size()}
+    L := e.newState(s)
+
+    callCtx, cancel := context.WithTimeout(ctx, e.timeout)
+    defer cancel()
+    L.SetContext(callCtx)
+    err := L.DoFile(filepath.Join(e.dir, name))
+    L.RemoveContext()
+    if err != nil {
+        L.Close()
+        e.fail(s, err)
+        return s
+    }
+    s.L = L
+    e.log.Info("script loaded", "script", name, "handlers", len(s.handlers))
+    return s
+}
+
+// newState builds the sandbox for s
+func (e *Engine) newState(s *script) *lua.LState {
+    L := lua.NewState(lua.Options{SkipOpenLibs: true, CallStackSize: 128, RegistryMaxSize: 1 << 20})
+    for _, lib := range []struct {
+        name string
+        open lua.LGFunction
+    }{
+        {lua.BaseLibName, lua.OpenBase},
+        {lua.TabLibName, lua.OpenTable},
+        {lua.StringLibName, lua.OpenString},
+        {lua.MathLibName, lua.OpenMath},
+    } {
+        L.Push(L.NewFunction(lib.open))
+        L.Push(lua.LString(lib.name))
+        L.Call(1, 0)
+    }
+    // Nothing that reaches the file system
+    for _, name := range []string{"dofile", "loadfile", "require", "module", "_printregs"} {
+        L.SetGlobal(name, lua.LNil)
+    }
+
+    L.SetGlobal("send", L.NewFunction(func(L *lua.LState) int {
+        room, text := L.CheckString(1), L.CheckString(2)
+        if err := e.node.Post(room, e.node.Name(), text); err != nil {
+            L.RaiseError("send: %v", err)
+        }
+        return 0
+    }))
+    L.SetGlobal("on_message", L.NewFunction(func(L *lua.LState) int {
+        s.handlers = append(s.handlers, L.CheckFunction(1))
+        return 0
+    }))
+    L.SetGlobal("peers", L.NewFunction(func(L *lua.LState) int {
+        t := L.NewTable()
+        for _, p := range e.node.Peers() {
+            t.Append(lua.LString(p.Name))
+        }
+        L.Push(t)
+        return 1
+    }))
+    L.SetGlobal("print", L.NewFunction(func(L *lua.LState) int {
+        parts := make([]string, L.GetTop())
+        for i := range parts {
+            parts[i] = L.ToStringMeta(L.Get(i + 1)).String()
+        }
+        e.node.Notify(fmt.Sprintf("[%s] %s", s.name, strings.Join(parts, "\t")))
+        return 0
+    }))
+    return L
+}
+
+func (e *Engine) dispatch(ctx context.Context, msg tui.Message) {
+    room := msg.Room
+    if room == "" {
+        room = chat.DefaultRoom
+    }
+    for _, s := range e.scripts {
+        if s.L == nil || len(s.handlers) == 0 {
+            continue
+        }
+        t := s.L.NewTable()
+        t.RawSetString("from", lua.LString(msg.From))
+        t.RawSetString("text", lua.LString(msg.Text))
+        t.RawSetString("room", lua.LString(room))
+        for _, fn := range s.handlers {
+            callCtx, cancel := context.WithTimeout(ctx, e.timeout)
+            s.L.SetContext(callCtx)
+            err := s.L.CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, t)
+            s.L.RemoveContext()
+            cancel()
+            if err != nil {
+                e.fail(s, err)
+            }
+        }
+    }
+}
+
+// fail reports a script error to the user, without the Lua stack trace
+func (e *Engine) fail(s *script, err error) {
+    text := err.Error()
+    var apiErr *lua.ApiError
+    if errors.As(err, &apiErr) {
+        text = apiErr.Object.String()
+    }
+    e.log.Warn("script error", "script", s.name, "err", text)
+    e.node.Notify(fmt.Sprintf("Script %s: %s", s.name, text))
+}
+
+func (s *script) close() {
+    if s.L != nil {
+        s.L.Close()
+        s.L = nil
+    }
+}
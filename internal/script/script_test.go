@@ -0,0 +1,155 @@
+package script
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/tui"
+)
+
+type post struct{ room, from, text string }
+
+type fakeNode struct {
+	mu      sync.Mutex
+	posts   []post
+	notices []string
+}
+
+func (n *fakeNode) Name() string { return "alice" }
+
+func (n *fakeNode) Post(room, from, text string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.posts = append(n.posts, post{room, from, text})
+	return nil
+}
+
+func (n *fakeNode) Peers() []chat.PeerInfo {
+	return []chat.PeerInfo{{Name: "bob"}, {Name: "carol"}}
+}
+
+func (n *fakeNode) Notify(text string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notices = append(n.notices, text)
+}
+
+// waitUntil polls cond, failing the test after a few seconds
+func waitUntil(t *testing.T, n *fakeNode, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) {
+		n.mu.Lock()
+		ok := cond()
+		n.mu.Unlock()
+		if ok {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	t.Fatalf("Timed out waiting for %s; posts %v, notices %q", what, n.posts, n.notices)
+}
+
+func startEngine(t *testing.T, dir string) (*Engine, *fakeNode) {
+	t.Helper()
+	n := &fakeNode{}
+	e := New(n, dir, nil)
+	e.poll = 20 * time.Millisecond
+	e.timeout = 200 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return e, n
+}
+
+func writeScript(t *testing.T, dir, name, src string) {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Make sure a rewrite within the same clock tick still looks changed
+	future := time.Now().Add(time.Duration(len(src)) * time.Second)
+	os.Chtimes(path, future, future)
+}
+
+func TestScriptAPI(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "echo.lua", `
+on_message(function(m)
+  if m.text == "!peers" then
+    send(m.room, table.concat(peers(), ","))
+  else
+    send("ops", m.from .. " said " .. string.upper(m.text))
+  end
+end)
+`)
+	e, n := startEngine(t, dir)
+	e.Message(tui.Message{From: "bob", Text: "hi"})
+	e.Message(tui.Message{From: "bob", Text: "!peers", Room: "dev"})
+	waitUntil(t, n, "two posts", func() bool { return len(n.posts) == 2 })
+
+	want := []post{{"ops", "alice", "bob said HI"}, {"dev", "alice", "bob,carol"}}
+	for i, p := range want {
+		if n.posts[i] != p {
+			t.Errorf("Post %d: got %+v, want %+v", i, n.posts[i], p)
+		}
+	}
+}
+
+func TestScriptSandbox(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "sandbox.lua", `
+print(type(os), type(io), type(require), type(dofile), type(string.format))
+`)
+	_, n := startEngine(t, dir)
+	waitUntil(t, n, "print output", func() bool { return len(n.notices) > 0 })
+	if n.notices[0] != "[sandbox.lua] nil\tnil\tnil\tnil\tfunction" {
+		t.Errorf("Unexpected globals: %q", n.notices[0])
+	}
+}
+
+func TestScriptErrorsAndReload(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "bad.lua", `
+on_message(function(m) error("boom") end)
+on_message(function(m) while true do end end)
+`)
+	writeScript(t, dir, "broken.lua", `this is not lua`)
+	e, n := startEngine(t, dir)
+	waitUntil(t, n, "load error", func() bool { return len(n.notices) == 1 })
+	if !strings.HasPrefix(n.notices[0], "Script broken.lua: ") {
+		t.Errorf("Unexpected notice %q", n.notices[0])
+	}
+
+	e.Message(tui.Message{From: "bob", Text: "hi"})
+	waitUntil(t, n, "handler errors", func() bool { return len(n.notices) == 3 })
+	if !strings.Contains(n.notices[1], "boom") || !strings.Contains(n.notices[2], "context deadline exceeded") {
+		t.Errorf("Unexpected notices %q", n.notices[1:])
+	}
+
+	// Fixing the script reloads it; deleting the other unloads it
+	writeScript(t, dir, "broken.lua", `on_message(function(m) send("general", "fixed") end)`)
+	os.Remove(filepath.Join(dir, "bad.lua"))
+	waitUntil(t, n, "reload", func() bool { return len(n.notices) == 5 })
+	e.Message(tui.Message{From: "bob", Text: "hi"})
+	waitUntil(t, n, "post from the fixed script", func() bool { return len(n.posts) == 1 })
+	if len(n.notices) != 5 {
+		t.Errorf("Unexpected notices %q", n.notices)
+	}
+}
@@ -0,0 +1,57 @@
+package tui
+
+import (
+	"errors"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Backend is what the model talks to, a node in the same process or a
+// daemon the TUI is attached to. The model does not care which.
+type Backend interface {
+    // Send delivers a line typed by the user, a message or a slash command
+    Send(text string) error
+    // Incoming carries messages to display. It is closed when the backend
+    // goes away.
+    Incoming() <-chan Message
+}
+
+// backendClosedMsg tells the model its backend has gone away
+type backendClosedMsg struct{}
+
+// chanBackend adapts the original pair of channels to Backend
+type chanBackend struct {
+    out chan<- string
+    in <-chan Message
+}
+
+func (b chanBackend) Send(text string) error {
+    if b.out == nil {
+        return nil
+    }
+    select {
+    case b.out <- text:
+        return nil
+    default:
+        return errors.New("outgoing queue full, message dropped")
+    }
+}
+
+func (b chanBackend) Incoming() <-chan Message {
+    return b.in
+}
+
+// send hands text to the backend without blocking the UI. Failures come
+// back as system messages.
+func (m Model) send(text string) tea.Cmd {
+    backend := m.backend
+    if backend == nil {
+        return nil
+    }
+    return func() tea.Msg {
+        if err := backend.Send(text); err != nil {
+            return Message{From: "System", Text: err.Error()}
+        }
+        return nil
+    }
+}
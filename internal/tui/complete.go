@@ -0,0 +1,211 @@
+package tui
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// completion is a Tab completion in progress. Repeated presses cycle
+// through candidates, replacing the word between before and after.
+type completion struct {
+    before []rune
+    after []rune
+    candidates []string
+    index int
+}
+
+// argCompleter lists candidates for a command argument starting with word
+type argCompleter func(m *Model, word string) []string
+
+// Slash commands the input box completes, with a completer per argument
+var commands = map[string][]argCompleter{
+    "/accept": {(*Model).offerCandidates},
+    "/block": {(*Model).peerCandidates},
+    "/decline": {(*Model).offerCandidates},
+    "/delete": nil,
+    "/edit": nil,
+    "/forget": nil,
+    "/ignore": {(*Model).peerCandidates},
+    "/ignores": nil,
+    "/info": nil,
+    "/mute": {(*Model).peerCandidates},
+    "/retry": nil,
+    "/room": {(*Model).roomCandidates},
+    "/send": {(*Model).peerCandidates, pathCandidates},
+    "/status": {(*Model).statusCandidates},
+    "/unblock": nil,
+    "/unignore": nil,
+    "/unmute": nil,
+}
+
+// SetPeerSource tells the model how to list connected peers for nickname
+// completion
+func (m *Model) SetPeerSource(fn func() []string) {
+    m.peerSource = fn
+}
+
+// complete handles Tab (step 1) and shift+Tab (step -1)
+func (m *Model) complete(step int) {
+    if m.comp == nil {
+        m.comp = m.startCompletion()
+        if m.comp == nil {
+            return
+        }
+    } else {
+        n := len(m.comp.candidates)
+        m.comp.index = (m.comp.index + step + n) % n
+    }
+
+    c := m.comp
+    word := []rune(c.candidates[c.index])
+    value := string(c.before) + string(word) + string(c.after)
+    m.setInput(value, len(c.before)+len(word))
+    if len(c.candidates) == 1 {
+        // Nothing to cycle through; the next Tab starts afresh, which
+        // descends into a completed directory
+        m.comp = nil
+    }
+}
+
+// startCompletion works out what the word before the cursor could be
+func (m *Model) startCompletion() *completion {
+    value, pos := m.inputCursor()
+    start := pos
+    for start > 0 && value[start-1] != ' ' && value[start-1] != '\n' {
+        start--
+    }
+    word := string(value[start:pos])
+    fields := strings.Fields(string(value[:start]))
+
+    var candidates []string
+    switch {
+    case start == 0 && strings.HasPrefix(word, "/"):
+        for name := range commands {
+            if strings.HasPrefix(name, word) {
+                candidates = append(candidates, name+" ")
+            }
+        }
+        slices.Sort(candidates)
+    case len(fields) > 0 && strings.HasPrefix(fields[0], "/"):
+        args := commands[fields[0]]
+        if i := len(fields) - 1; i < len(args) {
+            candidates = args[i](m, word)
+        }
+    case strings.HasPrefix(word, "@"):
+        for _, name := range m.peerCandidates(word[1:]) {
+            candidates = append(candidates, "@"+name)
+        }
+    default:
+        // A nickname at the start of the line addresses that peer
+        suffix := " "
+        if start == 0 {
+            suffix = ": "
+        }
+        for _, name := range m.peerCandidates(word) {
+            candidates = append(candidates, strings.TrimSuffix(name, " ")+suffix)
+        }
+    }
+    if len(candidates) == 0 {
+        return nil
+    }
+    return &completion{before: value[:start], after: value[pos:], candidates: candidates}
+}
+
+// peerCandidates lists connected peers whose names start with word,
+// ignoring case
+func (m *Model) peerCandidates(word string) []string {
+    if m.peerSource == nil {
+        return nil
+    }
+    var out []string
+    for _, name := range m.peerSource() {
+        if strings.HasPrefix(strings.ToLower(name), strings.ToLower(word)) {
+            out = append(out, name+" ")
+        }
+    }
+    slices.SortFunc(out, func(a, b string) int {
+        return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+    })
+    return out
+}
+
+func (m *Model) offerCandidates(word string) []string {
+    var out []string
+    for _, o := range m.offers {
+        if strings.HasPrefix(o.ID, word) {
+            out = append(out, o.ID)
+        }
+    }
+    return out
+}
+
+func (m *Model) roomCandidates(word string) []string {
+    var out []string
+    for _, name := range m.roomOrder {
+        if name := roomLabel(name); strings.HasPrefix(name, word) {
+            out = append(out, name+" ")
+        }
+    }
+    slices.Sort(out)
+    return out
+}
+
+// pathCandidates lists files and directories starting with word. A
+// directory keeps its trailing slash so completion can carry on inside it.
+func pathCandidates(_ *Model, word string) []string {
+    dir, base := filepath.Split(word)
+    list := dir
+    if list == "" {
+        list = "."
+    } else if rest, ok := strings.CutPrefix(list, "~/"); ok {
+        if home, err := os.UserHomeDir(); err == nil {
+            list = filepath.Join(home, rest)
+        }
+    }
+    entries, err := os.ReadDir(list)
+    if err != nil {
+        return nil
+    }
+    var out []string
+    for _, e := range entries {
+        name := e.Name()
+        if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+            continue
+        }
+        if e.IsDir() {
+            out = append(out, dir+name+"/")
+        } else {
+            out = append(out, dir+name)
+        }
+    }
+    return out
+}
+
+// inputCursor returns the input as runes and the cursor's index into them
+func (m *Model) inputCursor() ([]rune, int) {
+    lines := strings.Split(m.textarea.Value(), "\n")
+    pos := 0
+    for _, line := range lines[:m.textarea.Line()] {
+        pos += len([]rune(line)) + 1
+    }
+    info := m.textarea.LineInfo()
+    pos += info.StartColumn + info.ColumnOffset
+    return []rune(m.textarea.Value()), pos
+}
+
+// setInput replaces the input, leaving the cursor at rune index pos
+func (m *Model) setInput(value string, pos int) {
+    m.textarea.SetValue(value)
+    lines := strings.Split(value, "\n")
+    row := 0
+    for row < len(lines)-1 && pos > len([]rune(lines[row])) {
+        pos -= len([]rune(lines[row])) + 1
+        row++
+    }
+    for i := len(lines) - 1; i > row; i-- {
+        m.textarea.CursorUp()
+    }
+    m.textarea.SetCursor(pos)
+}
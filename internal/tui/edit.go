@@ -0,0 +1,69 @@
+package tui
+
+// lastOwn returns the newest message the user sent in the room being
+// shown, nil if there is none left to change
+func (m *Model) lastOwn() *entry {
+    lines := m.room(m.current).lines
+    for i := len(lines) - 1; i >= 0; i-- {
+        if e := lines[i]; e.msg.Self && e.msg.ID != "" && !e.deleted {
+            return e
+        }
+    }
+    return nil
+}
+
+// startEdit puts the user's last message in the input box. Enter then
+// sends the change rather than a new message.
+func (m *Model) startEdit() {
+    e := m.lastOwn()
+    if e == nil {
+        m.addLine(m.current, &entry{msg: Message{From: "System", Text: "You have no message here to edit"}}, false)
+        return
+    }
+    m.editing = e.msg.ID
+    m.setInput(e.msg.Text, len([]rune(e.msg.Text)))
+    m.resize()
+}
+
+// change applies an edit, delete, reaction or receipt to the line it
+// refers to
+func (m *Model) change(msg Message) {
+    r, ok := m.rooms[msg.Room]
+    if !ok {
+        return
+    }
+    for _, e := range r.lines {
+        if e.msg.ID != msg.ID || e.msg.From == "System" {
+            continue
+        }
+        switch msg.Kind {
+        case KindDelete:
+            e.deleted = true
+            e.msg.Text, e.msg.Reactions = "", nil
+            if m.editing == msg.ID {
+                m.editing = ""
+                m.resize()
+            }
+            if m.selected == e {
+                m.selected = nil
+            }
+        case KindEdit:
+            e.msg.Text, e.msg.Annotations, e.msg.Edited = msg.Text, msg.Annotations, true
+            e.highlight = m.isHighlight(e.msg)
+        case KindReceipt:
+            e.msg.ApplyReceipt(msg)
+        default:
+            e.msg.ApplyReaction(msg)
+        }
+        e.rendered = ""
+        for _, reply := range r.lines {
+            if reply.parent == e {
+                reply.rendered = "" // its quote shows e
+            }
+        }
+        if msg.Room == m.current {
+            m.refresh()
+        }
+        return
+    }
+}
@@ -0,0 +1,143 @@
+package tui
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Sent lines kept for Up and Down, in memory and in the history file
+const maxSent = 500
+
+// Longest line in the history file, newline included: a message of
+// maxInput characters encoded as a JSON string, where escapes such as
+// \u003c take six bytes
+const maxHistoryLine = 6*maxInput + 3
+
+// SetHistoryFile loads lines sent in earlier sessions from path and saves
+// new ones there. Each line of the file is a JSON string, so multi-line
+// messages survive the trip.
+func (m *Model) SetHistoryFile(path string) error {
+    m.historyFile = path
+    f, err := os.Open(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    defer f.Close()
+
+    var lines []string
+    total := 0
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 0, 4096), maxHistoryLine)
+    for sc.Scan() {
+        var line string
+        if json.Unmarshal(sc.Bytes(), &line) == nil && line != "" {
+            lines = append(lines, line)
+            total++
+        }
+    }
+    if err := sc.Err(); err != nil {
+        return err
+    }
+    if len(lines) > maxSent {
+        lines = lines[len(lines)-maxSent:]
+    }
+    m.sent = lines
+    m.sentPos = len(m.sent)
+    if total > 2*maxSent {
+        // Lines are only ever appended, so once in a while the file is
+        // cut back to what is kept
+        return rewriteHistory(path, lines)
+    }
+    return nil
+}
+
+func rewriteHistory(path string, lines []string) error {
+    tmp := path + ".tmp"
+    f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+    if err != nil {
+        return err
+    }
+    w := bufio.NewWriter(f)
+    for _, line := range lines {
+        b, _ := json.Marshal(line)
+        w.Write(append(b, '\n'))
+    }
+    if err := w.Flush(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
+
+// remember adds a sent line to the history and returns a command saving it
+func (m *Model) remember(line string) tea.Cmd {
+    if n := len(m.sent); n == 0 || m.sent[n-1] != line {
+        m.sent = append(m.sent, line)
+        if len(m.sent) > maxSent {
+            m.sent = m.sent[len(m.sent)-maxSent:]
+        }
+    }
+    m.sentPos = len(m.sent)
+
+    path := m.historyFile
+    if path == "" {
+        return nil
+    }
+    return func() tea.Msg {
+        if err := appendHistory(path, line); err != nil {
+            return Message{From: "System", Text: "Could not save input history: " + err.Error()}
+        }
+        return nil
+    }
+}
+
+func appendHistory(path, line string) error {
+    if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+        return err
+    }
+    f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+    if err != nil {
+        return err
+    }
+    b, _ := json.Marshal(line)
+    if _, err := f.Write(append(b, '\n')); err != nil {
+        f.Close()
+        return err
+    }
+    return f.Close()
+}
+
+// recall steps through sent lines, step -1 for Up and 1 for Down. It only
+// takes the keys while the input is empty or still shows a recalled line,
+// so they move the cursor as usual in anything being written.
+func (m *Model) recall(step int) bool {
+    value := m.textarea.Value()
+    if m.sentPos >= len(m.sent) || value != m.sent[m.sentPos] {
+        if value != "" {
+            return false
+        }
+        m.sentPos = len(m.sent)
+    }
+    pos := m.sentPos + step
+    switch {
+    case pos < 0:
+        // Already at the oldest line
+    case pos >= len(m.sent):
+        m.sentPos = len(m.sent)
+        m.textarea.Reset()
+    default:
+        m.sentPos = pos
+        m.setInput(m.sent[pos], len([]rune(m.sent[pos])))
+    }
+    return true
+}
@@ -0,0 +1,160 @@
+package tui
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gochat/internal/util"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Most records the log pane keeps; older ones are discarded
+const maxLogRecords = 500
+
+// Levels the log pane filter cycles through
+var logLevels = []slog.Level{slog.LevelDebug, slog.LevelInfo, slog.LevelWarn, slog.LevelError}
+
+// logBatch carries records read from the log channel in one go, so a burst
+// of logging costs one re-render instead of hundreds
+type logBatch []util.LogRecord
+
+// logPane tails the node's own log records below the chat
+type logPane struct {
+    view viewport.Model
+    records []util.LogRecord
+    level slog.Level
+    visible bool
+    ch <-chan util.LogRecord
+    headerStyle lipgloss.Style
+    levelStyles map[slog.Level]lipgloss.Style
+}
+
+func newLogPane() logPane {
+    return logPane{
+        view: viewport.New(40, 5),
+        level: slog.LevelInfo,
+        headerStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+        levelStyles: map[slog.Level]lipgloss.Style{
+            slog.LevelDebug: lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+            slog.LevelInfo: lipgloss.NewStyle().Foreground(lipgloss.Color("33")),
+            slog.LevelWarn: lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+            slog.LevelError: lipgloss.NewStyle().Foreground(lipgloss.Color("196")),
+        },
+    }
+}
+
+// SetLogChannel feeds the log pane (toggled with ctrl+l) from ch. The
+// sending side should drop records rather than block, as
+// util.ChannelHandler does.
+func (m *Model) SetLogChannel(ch <-chan util.LogRecord) {
+    m.logs.ch = ch
+}
+
+// waitForLogs blocks until at least one record arrives, then drains
+// whatever else is already queued.
+func waitForLogs(ch <-chan util.LogRecord) tea.Cmd {
+    if ch == nil {
+        return nil
+    }
+    return func() tea.Msg {
+        rec, ok := <-ch
+        if !ok {
+            return nil
+        }
+        batch := logBatch{rec}
+        for len(batch) < maxLogRecords {
+            select {
+            case rec, ok := <-ch:
+                if !ok {
+                    return batch
+                }
+                batch = append(batch, rec)
+            default:
+                return batch
+            }
+        }
+        return batch
+    }
+}
+
+func (p *logPane) add(batch logBatch) {
+    atBottom := p.view.AtBottom()
+    p.records = append(p.records, batch...)
+    if over := len(p.records) - maxLogRecords; over > 0 {
+        p.records = append(p.records[:0], p.records[over:]...)
+    }
+    p.render()
+    if atBottom {
+        p.view.GotoBottom()
+    }
+}
+
+// cycleLevel moves the filter to the next level, wrapping back to debug
+func (p *logPane) cycleLevel() {
+    for i, l := range logLevels {
+        if l == p.level {
+            p.level = logLevels[(i+1)%len(logLevels)]
+            break
+        }
+    }
+    p.render()
+    p.view.GotoBottom()
+}
+
+func (p *logPane) render() {
+    var lines []string
+    for _, r := range p.records {
+        if r.Level < p.level {
+            continue
+        }
+        level := p.levelStyle(r.Level).Render(fmt.Sprintf("%-5s", levelName(r.Level)))
+        line := fmt.Sprintf("%s %s %s", r.Time.Format("15:04:05"), level, r.Message)
+        if r.Attrs != "" {
+            line += " " + p.headerStyle.Render(r.Attrs)
+        }
+        lines = append(lines, line)
+    }
+    p.view.SetContent(lipgloss.NewStyle().Width(p.view.Width).Render(strings.Join(lines, "\n")))
+}
+
+func (p logPane) levelStyle(l slog.Level) lipgloss.Style {
+    switch {
+    case l >= slog.LevelError:
+        return p.levelStyles[slog.LevelError]
+    case l >= slog.LevelWarn:
+        return p.levelStyles[slog.LevelWarn]
+    case l >= slog.LevelInfo:
+        return p.levelStyles[slog.LevelInfo]
+    }
+    return p.levelStyles[slog.LevelDebug]
+}
+
+func levelName(l slog.Level) string {
+    switch {
+    case l > slog.LevelError:
+        return "FATAL"
+    case l >= slog.LevelError:
+        return "ERROR"
+    case l >= slog.LevelWarn:
+        return "WARN"
+    case l >= slog.LevelInfo:
+        return "INFO"
+    }
+    return "DEBUG"
+}
+
+func (p logPane) header(width int) string {
+    title := fmt.Sprintf("── log ≥ %s · F3 level · pgup/pgdn scroll · ctrl+l hide ", levelName(p.level))
+    if pad := width - lipgloss.Width(title); pad > 0 {
+        title += strings.Repeat("─", pad)
+    }
+    return p.headerStyle.Render(title)
+}
+
+func (p logPane) View(width int) string {
+    return p.header(width) + "\n" + p.view.View()
+}
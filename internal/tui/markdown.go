@@ -0,0 +1,103 @@
+package tui
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/quick"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// Inline Markdown, one alternative per construct: `code`, **bold**,
+// [text](url), a bare URL, _italic_ and *italic*
+var inline = regexp.MustCompile("`([^`]+)`" +
+    `|\*\*([^*]+)\*\*` +
+    `|\[([^\]]+)\]\((https?://[^)\s]+)\)` +
+    `|(https?://[^\s<>()]*[^\s<>().,;:!?'"])` +
+    `|\b_([^_]+)_\b` +
+    `|\*([^*\s][^*]*)\*`)
+
+// SetRaw starts the view with chat shown as typed; ctrl+r toggles it
+func (m *Model) SetRaw(raw bool) {
+    m.raw = raw
+}
+
+// markdown renders the subset of Markdown people use in chat. Plain text
+// is drawn in base.
+func (m *Model) markdown(text string, base lipgloss.Style) string {
+    var out []string
+    lines := strings.Split(text, "\n")
+    for i := 0; i < len(lines); i++ {
+        fence, ok := strings.CutPrefix(strings.TrimSpace(lines[i]), "```")
+        if !ok {
+            out = append(out, m.inline(lines[i], base))
+            continue
+        }
+        // A fenced block runs to the closing fence or the end of the message
+        var code []string
+        for i++; i < len(lines) && strings.TrimSpace(lines[i]) != "```"; i++ {
+            code = append(code, lines[i])
+        }
+        bar := m.StatusStyle.Render("│ ")
+        for _, line := range strings.Split(m.highlightCode(strings.Join(code, "\n"), strings.TrimSpace(fence)), "\n") {
+            out = append(out, bar+line)
+        }
+    }
+    return strings.Join(out, "\n")
+}
+
+func (m *Model) inline(line string, base lipgloss.Style) string {
+    var sb strings.Builder
+    last := 0
+    for _, g := range inline.FindAllStringSubmatchIndex(line, -1) {
+        sb.WriteString(base.Render(line[last:g[0]]))
+        last = g[1]
+        part := func(n int) string { return line[g[2*n]:g[2*n+1]] }
+        switch {
+        case g[2] >= 0:
+            sb.WriteString(m.CodeStyle.Render(part(1)))
+        case g[4] >= 0:
+            sb.WriteString(base.Bold(true).Render(part(2)))
+        case g[6] >= 0:
+            sb.WriteString(base.Underline(true).Render(part(3)))
+            if part(3) != part(4) {
+                sb.WriteString(" " + m.StatusStyle.Render("("+part(4)+")"))
+            }
+        case g[10] >= 0:
+            sb.WriteString(base.Underline(true).Render(part(5)))
+        case g[12] >= 0:
+            sb.WriteString(base.Italic(true).Render(part(6)))
+        default:
+            sb.WriteString(base.Italic(true).Render(part(7)))
+        }
+    }
+    sb.WriteString(base.Render(line[last:]))
+    return sb.String()
+}
+
+// highlightCode colours code in the language named after the opening
+// fence, in as many colours as the terminal has. Unknown languages, the
+// mono theme and colourless terminals get the code as it is.
+func (m *Model) highlightCode(code, lang string) string {
+    if m.codeStyle == "" || lang == "" || lexers.Get(lang) == nil {
+        return code
+    }
+    var formatter string
+    switch lipgloss.ColorProfile() {
+    case termenv.Ascii:
+        return code
+    case termenv.ANSI:
+        formatter = "terminal16"
+    case termenv.ANSI256:
+        formatter = "terminal256"
+    default:
+        formatter = "terminal16m"
+    }
+    var sb strings.Builder
+    if err := quick.Highlight(&sb, code, lang, formatter, m.codeStyle); err != nil {
+        return code
+    }
+    return sb.String()
+}
@@ -0,0 +1,152 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+    typingEvery = 3 * time.Second // how often writing in the input box is announced
+    typingTimeout = 6 * time.Second // how long a peer shows as typing after their last signal
+)
+
+// Statuses offered for /status completion
+var statuses = []string{"online", "away", "busy"}
+
+// typist is a peer writing a message
+type typist struct {
+    room string
+    until time.Time
+}
+
+// typingExpiredMsg asks the model to forget peers that stopped typing
+type typingExpiredMsg struct{}
+
+// setPresence records a KindPresence message: the local user's status if
+// it is their own, otherwise a peer's. Peers that go offline leave the list.
+func (m *Model) setPresence(msg Message) {
+    switch {
+    case msg.Self:
+        m.status = msg.Text
+    case msg.Text == "offline":
+        delete(m.presence, msg.From)
+        delete(m.typing, msg.From)
+    default:
+        m.presence[msg.From] = msg.Text
+    }
+    m.resize()
+}
+
+// SetPresence seeds the user's status and the peers' from a node that was
+// running before the model, such as a daemon being attached to. Statuses
+// are "status" or "status: note", as in presence messages.
+func (m *Model) SetPresence(self string, peers map[string]string) {
+    m.status = self
+    for name, status := range peers {
+        m.presence[name] = status
+    }
+}
+
+// setTyping records that a peer is writing and returns a command that
+// clears it once the signals stop
+func (m *Model) setTyping(msg Message) tea.Cmd {
+    m.typing[msg.From] = typist{room: msg.Room, until: time.Now().Add(typingTimeout)}
+    m.resize()
+    return tea.Tick(typingTimeout, func(time.Time) tea.Msg { return typingExpiredMsg{} })
+}
+
+func (m *Model) expireTyping() {
+    now := time.Now()
+    for name, t := range m.typing {
+        if !now.Before(t.until) {
+            delete(m.typing, name)
+        }
+    }
+    m.resize()
+}
+
+// typingSignal returns a command telling peers the user is writing, if
+// the input changed from before into something that will be sent as chat
+// and the last signal was long enough ago
+func (m *Model) typingSignal(before string) tea.Cmd {
+    value := m.textarea.Value()
+    if value == before || strings.TrimSpace(value) == "" || strings.HasPrefix(value, "/") {
+        return nil
+    }
+    if m.editing != "" || m.reacting != "" || time.Since(m.lastTyping) < typingEvery {
+        return nil
+    }
+    m.lastTyping = time.Now()
+    return m.send(strings.TrimSpace("/typing " + m.current))
+}
+
+// typingLine says who is writing in the current room, or is empty
+func (m Model) typingLine() string {
+    var names []string
+    for name, t := range m.typing {
+        if t.room == m.current {
+            names = append(names, name)
+        }
+    }
+    slices.Sort(names)
+    switch len(names) {
+    case 0:
+        return ""
+    case 1:
+        return names[0] + " is typing…"
+    case 2, 3:
+        return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1] + " are typing…"
+    default:
+        return fmt.Sprintf("%d people are typing…", len(names))
+    }
+}
+
+// peerBar lists known peers with a mark for their status, or is empty
+// before anyone has connected
+func (m Model) peerBar() string {
+    names := make([]string, 0, len(m.presence))
+    for name := range m.presence {
+        names = append(names, name)
+    }
+    if len(names) == 0 {
+        return ""
+    }
+    slices.SortFunc(names, func(a, b string) int {
+        return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+    })
+    parts := make([]string, len(names))
+    for i, name := range names {
+        status, note, _ := strings.Cut(m.presence[name], ": ")
+        mark := "●"
+        switch status {
+        case "away":
+            mark = "◌"
+        case "busy":
+            mark = "⊖"
+        }
+        part := mark + " " + m.PeerStyle.Render(name)
+        if status != "online" {
+            part += " " + m.StatusStyle.Render(strings.TrimSpace(status+" "+note))
+        }
+        if _, ok := m.typing[name]; ok {
+            part += m.StatusStyle.Render(" ✎")
+        }
+        parts[i] = part
+    }
+    return strings.Join(parts, "  ")
+}
+
+// statusCandidates completes the first argument of /status
+func (m *Model) statusCandidates(word string) []string {
+    var out []string
+    for _, s := range statuses {
+        if strings.HasPrefix(s, word) {
+            out = append(out, s+" ")
+        }
+    }
+    return out
+}
@@ -0,0 +1,127 @@
+package tui
+
+import (
+	"strconv"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Reactions on keys 1 to 6 in selection mode
+var quickReactions = []string{"👍", "❤️", "😂", "🎉", "😮", "👀"}
+
+// selectable reports whether e is a chat line that can be reacted to
+func selectable(e *entry) bool {
+    return e.msg.ID != "" && e.msg.From != "" && e.msg.From != "System" && !e.deleted
+}
+
+// selectKey handles keys in selection mode, which ctrl+s enters on the
+// newest message of the room and Esc or ctrl+s leaves
+func (m Model) selectKey(k tea.KeyMsg) (Model, tea.Cmd) {
+    listen := listenForIncomingMessages(m.incomingChan)
+    if m.selected == nil {
+        m.moveSelection(-1)
+        m.resize()
+        return m, listen
+    }
+    e := m.selected
+    switch k.String() {
+    case "ctrl+c":
+        return m, tea.Quit
+    case "esc", "ctrl+s":
+        m.selected = nil
+    case "up", "k":
+        m.moveSelection(-1)
+        return m, listen
+    case "down", "j":
+        m.moveSelection(1)
+        return m, listen
+    case "enter":
+        m.selected = nil
+        m.replying = e
+    case "t":
+        m.selected = nil
+        m.openThread(e)
+        return m, listen
+    case "r":
+        m.selected = nil
+        m.reacting = e.msg.ID
+    case "i":
+        m.selected = nil
+        m.refresh()
+        m.resize()
+        return m, tea.Batch(m.send("/info "+e.msg.ID), listen)
+    case "R":
+        m.selected = nil
+        m.refresh()
+        m.resize()
+        return m, tea.Batch(m.send("/retry "+e.msg.ID), listen)
+    case "1", "2", "3", "4", "5", "6":
+        emoji := quickReactions[k.Runes[0]-'1']
+        m.selected = nil
+        m.refresh()
+        m.resize()
+        return m, tea.Batch(m.send("/react "+e.msg.ID+" "+emoji), listen)
+    default:
+        return m, listen
+    }
+    m.refresh()
+    m.resize()
+    return m, listen
+}
+
+// moveSelection selects the next selectable line step places up (-1) or
+// down (1) from the current one, or the newest if there is none yet, and
+// scrolls it into view
+func (m *Model) moveSelection(step int) {
+    lines := m.room(m.current).lines
+    i := len(lines)
+    for j, e := range lines {
+        if e == m.selected {
+            i = j
+        }
+    }
+    for i += step; i >= 0 && i < len(lines); i += step {
+        if selectable(lines[i]) && m.visible(lines[i]) {
+            m.selected = lines[i]
+            break
+        }
+    }
+    if m.selected == nil {
+        return
+    }
+    offsets := m.refresh()
+    for j, e := range lines {
+        if e != m.selected {
+            continue
+        }
+        top := offsets[j]
+        bottom := top + lipgloss.Height(m.render(e, m.viewport.Width)) - 1
+        if top < m.viewport.YOffset {
+            m.viewport.SetYOffset(top)
+        } else if bottom >= m.viewport.YOffset+m.viewport.Height {
+            m.viewport.SetYOffset(bottom - m.viewport.Height + 1)
+        }
+    }
+}
+
+// markSelected renders e with a bar down its left edge
+func (m *Model) markSelected(e *entry) string {
+    bar := m.HighlightStyle.Render("▌")
+    return bar + strings.ReplaceAll(m.format(e, m.viewport.Width-1), "\n", "\n"+bar)
+}
+
+// reactionLine shows reactions as emoji and counts, the user's own in the
+// sender colour
+func (m *Model) reactionLine(reactions []Reaction) string {
+    parts := make([]string, len(reactions))
+    for i, r := range reactions {
+        style := m.StatusStyle
+        if r.Self {
+            style = m.SenderStyle
+        }
+        parts[i] = style.Render(r.Emoji + " " + strconv.Itoa(len(r.From)))
+    }
+    return strings.Join(parts, "  ")
+}
@@ -0,0 +1,81 @@
+package tui
+
+import (
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// SetReadReceipts makes the model tell peers when their messages have been
+// on screen
+func (m *Model) SetReadReceipts(on bool) {
+    m.readReceipts = on
+}
+
+// markRead returns a command reporting peers' messages in the room that
+// are on screen as read, if the terminal has focus. Lines scrolled out of
+// the viewport wait until they are scrolled into it. Each line is reported
+// once.
+func (m *Model) markRead() tea.Cmd {
+    if !m.readReceipts || !m.focused {
+        return nil
+    }
+    lines := m.room(m.current).lines
+    offsets := m.refresh()
+    top, bottom := m.viewport.YOffset, m.viewport.YOffset+m.viewport.Height
+    var ids []string
+    for i, e := range lines {
+        if e.seen || e.msg.Self || !selectable(e) || !m.visible(e) {
+            continue
+        }
+        end := m.viewport.TotalLineCount()
+        if i+1 < len(offsets) {
+            end = offsets[i+1]
+        }
+        if offsets[i] >= bottom || end <= top {
+            continue
+        }
+        e.seen = true
+        ids = append(ids, e.msg.ID)
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+    return m.send("/read " + strings.Join(ids, " "))
+}
+
+// receiptMark sums up how far one of the user's messages got: ✓ once every
+// peer has it, ✓✓ once every peer has read it, and the peers it failed to
+// reach or is waiting for if there are any
+func (m *Model) receiptMark(receipts map[string]string) string {
+    var failed, queued []string
+    delivered, read := len(receipts) > 0, len(receipts) > 0
+    for peer, state := range receipts {
+        switch state {
+        case "failed":
+            failed = append(failed, peer)
+        case "queued":
+            queued = append(queued, peer)
+            delivered, read = false, false
+        case "read":
+        case "delivered":
+            read = false
+        default:
+            delivered, read = false, false
+        }
+    }
+    switch {
+    case len(failed) > 0:
+        slices.Sort(failed)
+        return m.HighlightStyle.Render("✗ not delivered to " + strings.Join(failed, ", "))
+    case len(queued) > 0:
+        slices.Sort(queued)
+        return m.StatusStyle.Render("⏳ waiting for " + strings.Join(queued, ", "))
+    case read:
+        return m.StatusStyle.Render("✓✓")
+    case delivered:
+        return m.StatusStyle.Render("✓")
+    }
+    return ""
+}
@@ -0,0 +1,241 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// defaultRoomName is how the room with an empty name is shown
+const defaultRoomName = "general"
+
+// roomView is the scrollback and unread state of one room
+type roomView struct {
+    lines []*entry
+    unread int // lines that arrived while the room was not being looked at
+    marker int // index of the first line since the user last looked, -1 if none
+    mentions []int // indices of unread lines that mention the user, oldest first
+}
+
+// SetHighlights makes messages that mention @name or contain one of words
+// stand out and ring the terminal bell. Words match whole, ignoring case.
+func (m *Model) SetHighlights(name string, words []string) {
+    m.highlights = nil
+    if name != "" {
+        m.highlights = append(m.highlights, wordPattern("@"+name))
+    }
+    for _, w := range words {
+        m.highlights = append(m.highlights, wordPattern(w))
+    }
+}
+
+func wordPattern(w string) *regexp.Regexp {
+    return regexp.MustCompile(`(?i)(^|\W)` + regexp.QuoteMeta(w) + `($|\W)`)
+}
+
+// isHighlight reports whether msg is a peer's chat line the user asked to
+// be alerted to
+func (m Model) isHighlight(msg Message) bool {
+    if msg.Self || msg.From == "System" {
+        return false
+    }
+    for _, re := range m.highlights {
+        if re.MatchString(msg.Text) {
+            return true
+        }
+    }
+    return false
+}
+
+// entry is a line of scrollback, rendered when first shown and again only
+// when the width or raw mode changes
+type entry struct {
+    msg Message
+    highlight bool
+    deleted bool // retracted by its sender; only a note is shown
+    seen bool // reported as read
+    parent *entry // the message this one replies to, if it is in the room
+    rendered string
+    width int
+    raw bool
+}
+
+// render returns e formatted for the current width and mode
+func (m *Model) render(e *entry, width int) string {
+    if e.rendered == "" || e.width != width || e.raw != m.raw {
+        e.rendered, e.width, e.raw = m.format(e, width), width, m.raw
+    }
+    return e.rendered
+}
+
+// room returns the view for name, opening it if this is its first message
+func (m *Model) room(name string) *roomView {
+    r, ok := m.rooms[name]
+    if !ok {
+        r = &roomView{marker: -1}
+        m.rooms[name] = r
+        m.roomOrder = append(m.roomOrder, name)
+    }
+    return r
+}
+
+// addLine appends a line to a room. Lines that count toward the unread
+// total are those from peers arriving while the user is elsewhere.
+func (m *Model) addLine(name string, line *entry, counts bool) {
+    r := m.room(name)
+    if line.msg.ReplyTo != "" {
+        line.parent = r.findEntry(line.msg.ReplyTo)
+    }
+    if counts && (name != m.current || !m.focused) {
+        if r.marker < 0 {
+            r.marker = len(r.lines)
+        }
+        r.unread++
+        if line.highlight {
+            r.mentions = append(r.mentions, len(r.lines))
+        }
+    }
+    r.lines = append(r.lines, line)
+    if name == m.current && m.visible(line) {
+        m.refresh()
+        m.viewport.GotoBottom()
+    }
+}
+
+// switchRoom shows the room step places away from the current one
+func (m *Model) switchRoom(step int) {
+    if len(m.roomOrder) < 2 {
+        return
+    }
+    for i, name := range m.roomOrder {
+        if name == m.current {
+            next := (i + step + len(m.roomOrder)) % len(m.roomOrder)
+            m.enterRoom(m.roomOrder[next])
+            return
+        }
+    }
+}
+
+// enterRoom makes name the current room. The room being left has been
+// looked at, so its marker and pending mentions go; the marker of the room
+// being entered stays until the user leaves it in turn.
+func (m *Model) enterRoom(name string) {
+    m.selected = nil
+    m.thread = nil
+    if name != m.current {
+        left := m.room(m.current)
+        left.marker = -1
+        left.mentions = nil
+        m.current = name
+    }
+    m.room(name).unread = 0
+    m.refresh()
+    m.viewport.GotoBottom()
+}
+
+// nextMention moves to the oldest unread mention, in the current room
+// first and then the rooms after it
+func (m *Model) nextMention() {
+    start := 0
+    for i, name := range m.roomOrder {
+        if name == m.current {
+            start = i
+        }
+    }
+    for i := range m.roomOrder {
+        name := m.roomOrder[(start+i)%len(m.roomOrder)]
+        r := m.rooms[name]
+        if len(r.mentions) == 0 {
+            continue
+        }
+        line := r.mentions[0]
+        r.mentions = r.mentions[1:]
+        m.thread = nil
+        if name != m.current {
+            m.enterRoom(name)
+        }
+        offsets := m.refresh()
+        m.viewport.SetYOffset(offsets[line])
+        return
+    }
+}
+
+// refresh renders the current room into the viewport and returns the
+// viewport line each chat line starts on
+func (m *Model) refresh() []int {
+    r := m.room(m.current)
+    var sb strings.Builder
+    offsets := make([]int, len(r.lines))
+    row := 0
+    for i, e := range r.lines {
+        offsets[i] = row
+        if !m.visible(e) {
+            continue
+        }
+        if i == r.marker {
+            marker := m.HighlightStyle.Render(markerLine(m.viewport.Width))
+            sb.WriteString(marker + "\n")
+            row += lipgloss.Height(marker)
+        }
+        offsets[i] = row
+        line := m.render(e, m.viewport.Width)
+        if e == m.selected {
+            line = m.markSelected(e)
+        }
+        sb.WriteString(line + "\n")
+        row += lipgloss.Height(line)
+    }
+    m.viewport.SetContent(strings.TrimSuffix(sb.String(), "\n"))
+    return offsets
+}
+
+// markerLine is the "new since you last looked" rule, as wide as the view
+func markerLine(width int) string {
+    label := " new "
+    side := max((width-len(label))/2, 3)
+    return strings.Repeat("─", side) + label + strings.Repeat("─", side)
+}
+
+// roomBar lists the rooms with their unread and mention counts
+func (m Model) roomBar() string {
+    parts := make([]string, 0, len(m.roomOrder))
+    for _, name := range m.roomOrder {
+        r := m.rooms[name]
+        label := "#" + roomLabel(name)
+        if name == m.current {
+            label = m.SenderStyle.Bold(true).Render(label)
+        } else {
+            label = m.StatusStyle.Render(label)
+        }
+        if r.unread > 0 {
+            label += m.StatusStyle.Render(fmt.Sprintf(" %d", r.unread))
+        }
+        if len(r.mentions) > 0 {
+            label += " " + m.HighlightStyle.Render(fmt.Sprintf("@%d", len(r.mentions)))
+        }
+        parts = append(parts, label)
+    }
+    return strings.Join(parts, "  ")
+}
+
+func roomLabel(name string) string {
+    if name == "" {
+        return defaultRoomName
+    }
+    return name
+}
+
+// ring sounds the terminal bell
+func ring(w io.Writer) tea.Cmd {
+    if w == nil {
+        return nil
+    }
+    return func() tea.Msg {
+        w.Write([]byte("\a"))
+        return nil
+    }
+}
@@ -0,0 +1,75 @@
+package tui
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Theme is the colour scheme for the chat view
+type Theme struct {
+    Sender lipgloss.Style
+    System lipgloss.Style
+    Peer lipgloss.Style
+    Status lipgloss.Style
+    Highlight lipgloss.Style // mentions and highlight words
+    Code lipgloss.Style // inline code
+    CodeStyle string // chroma style for code blocks, empty for none
+}
+
+var themes = map[string]Theme{
+    "default": {
+        Sender: lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
+        System: lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true),
+        Peer: lipgloss.NewStyle().Foreground(lipgloss.Color("33")),
+        Status: lipgloss.NewStyle().Foreground(lipgloss.Color("244")),
+        Highlight: lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Bold(true),
+        Code: lipgloss.NewStyle().Foreground(lipgloss.Color("203")),
+        CodeStyle: "monokai",
+    },
+    // Darker colours that stay readable on a light background
+    "light": {
+        Sender: lipgloss.NewStyle().Foreground(lipgloss.Color("125")),
+        System: lipgloss.NewStyle().Foreground(lipgloss.Color("242")).Italic(true),
+        Peer: lipgloss.NewStyle().Foreground(lipgloss.Color("25")),
+        Status: lipgloss.NewStyle().Foreground(lipgloss.Color("238")),
+        Highlight: lipgloss.NewStyle().Foreground(lipgloss.Color("130")).Bold(true),
+        Code: lipgloss.NewStyle().Foreground(lipgloss.Color("161")),
+        CodeStyle: "github",
+    },
+    "mono": {
+        Sender: lipgloss.NewStyle().Bold(true),
+        System: lipgloss.NewStyle().Italic(true),
+        Peer: lipgloss.NewStyle().Underline(true),
+        Status: lipgloss.NewStyle().Faint(true),
+        Highlight: lipgloss.NewStyle().Reverse(true),
+        Code: lipgloss.NewStyle().Faint(true),
+    },
+}
+
+// ThemeNames lists the themes SetTheme accepts
+func ThemeNames() []string {
+    names := make([]string, 0, len(themes))
+    for name := range themes {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// SetTheme switches the model's styles to the named theme
+func (m *Model) SetTheme(name string) error {
+    t, ok := themes[name]
+    if !ok {
+        return fmt.Errorf("unknown theme %q", name)
+    }
+    m.SenderStyle = t.Sender
+    m.SystemStyle = t.System
+    m.PeerStyle = t.Peer
+    m.StatusStyle = t.Status
+    m.HighlightStyle = t.Highlight
+    m.CodeStyle = t.Code
+    m.codeStyle = t.CodeStyle
+    return nil
+}
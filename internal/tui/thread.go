@@ -0,0 +1,75 @@
+package tui
+
+import (
+	"strings"
+)
+
+// Longest quote of a parent message shown above a reply, in runes
+const previewLength = 50
+
+// preview is a one-line summary of e for quoting
+func preview(e *entry) string {
+    if e.deleted {
+        return e.msg.From + ": message deleted"
+    }
+    line, _, _ := strings.Cut(strings.TrimSpace(e.msg.Text), "\n")
+    if r := []rune(line); len(r) > previewLength {
+        line = string(r[:previewLength-1]) + "…"
+    }
+    return e.msg.From + ": " + line
+}
+
+// findEntry returns the line of room r with message ID id
+func (r *roomView) findEntry(id string) *entry {
+    for i := len(r.lines) - 1; i >= 0; i-- {
+        if e := r.lines[i]; e.msg.ID == id && e.msg.From != "System" {
+            return e
+        }
+    }
+    return nil
+}
+
+// threadRoot follows replies back to the message that started them
+func threadRoot(e *entry) *entry {
+    // Parents always arrive before their replies, so this ends
+    for e.parent != nil {
+        e = e.parent
+    }
+    return e
+}
+
+// visible reports whether e is shown: in the thread view only the root
+// and its replies are
+func (m *Model) visible(e *entry) bool {
+    if m.thread == nil {
+        return true
+    }
+    return e.msg.From != "System" && threadRoot(e) == m.thread
+}
+
+// openThread shows only the thread e is part of
+func (m *Model) openThread(e *entry) {
+    m.thread = threadRoot(e)
+    m.refresh()
+    m.viewport.GotoBottom()
+    m.resize()
+}
+
+func (m *Model) closeThread() {
+    m.thread = nil
+    m.refresh()
+    m.viewport.GotoBottom()
+    m.resize()
+}
+
+// quote is the line shown above a reply
+func (m *Model) quote(e *entry, width int) string {
+    text := "↪ earlier message"
+    if e.parent != nil {
+        text = "↪ " + preview(e.parent)
+    }
+    if r := []rune(text); width > 1 && len(r) > width {
+        text = string(r[:width-1]) + "…"
+    }
+    return m.StatusStyle.Render(text)
+}
@@ -3,7 +3,13 @@ package tui
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -13,6 +19,11 @@ import (
 
 const gap = "\n\n"
 
+const (
+    maxInput = 16 << 10 // characters in one message
+    maxInputHeight = 8 // lines the input box grows to before it scrolls
+)
+
 type errMsg error 
 
 type Model struct {
@@ -21,15 +32,136 @@ type Model struct {
     SenderStyle lipgloss.Style
     SystemStyle lipgloss.Style
     PeerStyle lipgloss.Style
+    StatusStyle lipgloss.Style
+    HighlightStyle lipgloss.Style
+    CodeStyle lipgloss.Style
+    codeStyle string // chroma style for code blocks
     err error
-    messages []string
+    rooms map[string]*roomView
+    roomOrder []string // rooms in the order they were first seen
+    current string // room being shown; empty for the default room
+    focused bool // whether the terminal has focus, as far as we know
+    highlights []*regexp.Regexp
+    bell io.Writer // where the bell is rung, nil for silence
+    peerSource func() []string
+    comp *completion // Tab completion in progress
+    raw bool // show message text as typed instead of rendering Markdown
+    readReceipts bool // tell peers when their messages have been on screen
+    sent []string // lines sent, oldest first, for Up and Down
+    sentPos int // index into sent of the recalled line, len(sent) if none
+    historyFile string // where sent lines are kept between sessions
+    editing string // ID of the message being edited, empty if none
+    selected *entry // line picked in selection mode, nil outside it
+    reacting string // ID of the message a custom reaction is being typed for
+    replying *entry // message the next line sent answers, nil if none
+    thread *entry // root of the thread being shown on its own, nil for the whole room
+    presence map[string]string // peer name -> status, maybe with a note
+    typing map[string]typist // peers writing a message, by name
+    status string // the local user's status, empty until the node reports one
+    lastTyping time.Time // when peers were last told the user is writing
+    width int
+    height int
+    transfers map[string]string // transfer ID -> progress line
+    offers []Message // file offers waiting for accept/decline
+    logs logPane
+    backend Backend
     outgoingChan chan<- string // Channel to send outgoing messages
     incomingChan <-chan Message // Channel to receive incoming messages
 }
 
+// MessageKind tells the model where an incoming Message belongs
+type MessageKind int
+
+const (
+    KindText MessageKind = iota // chat line or system notice for the viewport
+    KindProgress // transfer progress for the status area; empty Text clears it
+    KindOffer // incoming file offer waiting for the user to accept or decline
+    KindEdit // new Text for the chat line with the same ID
+    KindDelete // the chat line with the same ID was retracted
+    KindReact // From reacted with the emoji in Text to the chat line with the same ID
+    KindUnreact // From took back that reaction
+    KindPresence // From's status changed to Text: online, away, busy or offline, maybe with a note
+    KindTyping // From is writing a message in Room
+    KindReceipt // the user's chat line with the same ID was queued for, sent, delivered or read by From, or failed to reach them, as Text says
+)
+
+var kindNames = []string{"text", "progress", "offer", "edit", "delete", "react", "unreact", "presence", "typing", "receipt"}
+
+func (k MessageKind) MarshalText() ([]byte, error) {
+    if int(k) < 0 || int(k) >= len(kindNames) {
+        return nil, fmt.Errorf("unknown message kind %d", k)
+    }
+    return []byte(kindNames[k]), nil
+}
+
+func (k *MessageKind) UnmarshalText(b []byte) error {
+    for i, name := range kindNames {
+        if name == string(b) {
+            *k = MessageKind(i)
+            return nil
+        }
+    }
+    return fmt.Errorf("unknown message kind %q", b)
+}
+
 type Message struct { 
-    From string 
-    Text string
+    From string `json:"from"`
+    Text string `json:"text"`
+    Kind MessageKind `json:"kind"`
+    ID string `json:"id,omitempty"` // message ID of chat lines, transfer ID for progress and offers
+    Self bool `json:"self,omitempty"` // sent by the local user, echoed back for display
+    Room string `json:"room,omitempty"` // empty for the default room
+    ReplyTo string `json:"reply_to,omitempty"` // ID of the message this one answers
+    Annotations map[string]string `json:"annotations,omitempty"` // notes added by chat hooks
+    Edited bool `json:"edited,omitempty"` // text was changed after it was sent
+    Reactions []Reaction `json:"reactions,omitempty"` // in the order first used
+    Receipts map[string]string `json:"receipts,omitempty"` // own chat lines only; peer -> queued, sent, delivered, read or failed
+}
+
+// Reaction is an emoji and who reacted to a message with it
+type Reaction struct {
+    Emoji string `json:"emoji"`
+    From []string `json:"from"`
+    Self bool `json:"self,omitempty"` // the local user is among them
+}
+
+// ApplyReaction adds or takes back r, a KindReact or KindUnreact message,
+// among msg's reactions. The slices are copied rather than changed in
+// place, as other copies of msg may share them.
+func (msg *Message) ApplyReaction(r Message) {
+    var out []Reaction
+    found := false
+    for _, old := range msg.Reactions {
+        if old.Emoji == r.Text {
+            found = true
+            old.From = slices.DeleteFunc(slices.Clone(old.From), func(name string) bool { return name == r.From })
+            if r.Self {
+                old.Self = r.Kind == KindReact
+            }
+            if r.Kind == KindReact {
+                old.From = append(old.From, r.From)
+            }
+            if len(old.From) == 0 {
+                continue
+            }
+        }
+        out = append(out, old)
+    }
+    if !found && r.Kind == KindReact {
+        out = append(out, Reaction{Emoji: r.Text, From: []string{r.From}, Self: r.Self})
+    }
+    msg.Reactions = out
+}
+
+// ApplyReceipt records r, a KindReceipt message, among msg's receipts. The
+// map is copied rather than changed in place, as for reactions.
+func (msg *Message) ApplyReceipt(r Message) {
+    receipts := make(map[string]string, len(msg.Receipts)+1)
+    for peer, state := range msg.Receipts {
+        receipts[peer] = state
+    }
+    receipts[r.From] = r.Text
+    msg.Receipts = receipts
 }
 
 type OutgoingMsg struct {
@@ -43,38 +175,60 @@ func InitModel() Model {
 
 // New function that accepts channels for message handling
 func InitModelWithChannels(outgoingChan chan<- string, incomingChan <-chan Message) Model {
+    m := InitModelWithBackend(chanBackend{out: outgoingChan, in: incomingChan})
+    m.outgoingChan = outgoingChan
+    return m
+}
+
+// InitModelWithBackend builds a model that sends to and receives from b
+func InitModelWithBackend(b Backend) Model {
     ta := textarea.New()
     ta.Placeholder = "Type your message here..."
     ta.Focus()
     ta.Prompt = "> "
-    ta.CharLimit = 512
+    ta.CharLimit = maxInput
+    ta.MaxHeight = 0 // the box stops growing at maxInputHeight; the text need not
     ta.SetWidth(40)
     ta.SetHeight(1)
     ta.FocusedStyle.CursorLine = lipgloss.NewStyle()
     ta.ShowLineNumbers = false
 
     vp := viewport.New(40, 20)
-    vp.SetContent("Welcome to the gochat application!\n\n")
 
-    ta.KeyMap.InsertNewline.SetEnabled(false)
+    // Enter sends, so newlines need a modifier. Pasted text keeps its own.
+    ta.KeyMap.InsertNewline.SetKeys("alt+enter", "ctrl+j")
 
-    return Model{
+    theme := themes["default"]
+    m := Model{
         viewport: vp,
         textarea: ta,
-        SenderStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("205")),
-        SystemStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Italic(true),
-        PeerStyle: lipgloss.NewStyle().Foreground(lipgloss.Color("33")),
-        messages: []string{},
+        SenderStyle: theme.Sender,
+        SystemStyle: theme.System,
+        PeerStyle: theme.Peer,
+        StatusStyle: theme.Status,
+        HighlightStyle: theme.Highlight,
+        CodeStyle: theme.Code,
+        codeStyle: theme.CodeStyle,
+        rooms: make(map[string]*roomView),
+        focused: true,
+        bell: os.Stdout,
+        transfers: make(map[string]string),
+        presence: make(map[string]string),
+        typing: make(map[string]typist),
+        logs: newLogPane(),
         err: nil,
-        outgoingChan: outgoingChan,
-        incomingChan: incomingChan,
+        backend: b,
+        incomingChan: b.Incoming(),
     }
+    m.addLine("", &entry{msg: Message{Text: "Welcome to the gochat application!\n"}}, false)
+    return m
 }
 
 func (m Model) Init() tea.Cmd {
     return tea.Batch(
         textarea.Blink,
         listenForIncomingMessages(m.incomingChan),
+        waitForLogs(m.logs.ch),
     )
 }
 
@@ -85,7 +239,10 @@ func listenForIncomingMessages(incomingChan <-chan Message) tea.Cmd {
     }
     return func() tea.Msg {
         select {
-        case msg := <-incomingChan:
+        case msg, ok := <-incomingChan:
+            if !ok {
+                return backendClosedMsg{}
+            }
             return msg
         default:
             // Return nil to continue listening on next update
@@ -95,70 +252,264 @@ func listenForIncomingMessages(incomingChan <-chan Message) tea.Cmd {
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+    offset := m.viewport.YOffset
+    next, cmd := m.update(msg)
+    next.fitInput()
+    if next.viewport.YOffset != offset {
+        // Scrolling may have brought peers' lines on screen
+        cmd = tea.Batch(cmd, next.markRead())
+    }
+    return next, cmd
+}
+
+func (m Model) update(msg tea.Msg) (Model, tea.Cmd) {
     var (
         tiCmd tea.Cmd
         vpCmd tea.Cmd
     )
+    if k, ok := msg.(tea.KeyMsg); ok && (m.selected != nil || k.Type == tea.KeyCtrlS) {
+        // Selection mode has the keyboard to itself
+        return m.selectKey(k)
+    }
+    if k, ok := msg.(tea.KeyMsg); ok && (k.Type == tea.KeyUp || k.Type == tea.KeyDown) {
+        // Up and Down recall sent lines, and alt+Up on an empty input
+        // edits the last message; otherwise they go to the input box
+        step := 1
+        if k.Type == tea.KeyUp {
+            step = -1
+        }
+        switch {
+        case k.Alt && k.Type == tea.KeyUp && m.textarea.Value() == "":
+            m.startEdit()
+            return m, listenForIncomingMessages(m.incomingChan)
+        case !k.Alt && m.editing == "" && m.reacting == "" && m.recall(step):
+            return m, listenForIncomingMessages(m.incomingChan)
+        }
+    }
+    before := m.textarea.Value()
     m.textarea, tiCmd = m.textarea.Update(msg)
-    m.viewport, vpCmd = m.viewport.Update(msg)
+    if _, ok := msg.(tea.KeyMsg); ok {
+        tiCmd = tea.Batch(tiCmd, m.typingSignal(before))
+    }
+    if k, ok := msg.(tea.KeyMsg); ok && m.logs.visible && (k.Type == tea.KeyPgUp || k.Type == tea.KeyPgDown) {
+        // Page keys scroll the log pane while it is open
+        m.logs.view, vpCmd = m.logs.view.Update(msg)
+    } else {
+        m.viewport, vpCmd = m.viewport.Update(msg)
+    }
 
     switch msg := msg.(type) {
     case tea.WindowSizeMsg:
+        m.width, m.height = msg.Width, msg.Height
         m.viewport.Width = msg.Width
+        m.logs.view.Width = msg.Width
         m.textarea.SetWidth(msg.Width)
-        m.viewport.Height = msg.Height - m.textarea.Height() - lipgloss.Height(gap)
-        if len(m.messages) > 0 {
-            m.viewport.SetContent(lipgloss.NewStyle().Width(m.viewport.Width).Render(strings.Join(m.messages, "\n")))
-        }
+        m.resize()
+        m.logs.render()
+        m.refresh()
         m.viewport.GotoBottom()
+    case tea.FocusMsg:
+        m.focused = true
+        m.room(m.current).unread = 0
+        return m, tea.Batch(tiCmd, vpCmd, m.markRead(), listenForIncomingMessages(m.incomingChan))
+    case tea.BlurMsg:
+        m.focused = false
     case tea.KeyMsg:
+        if msg.Type != tea.KeyTab && msg.Type != tea.KeyShiftTab {
+            m.comp = nil
+        }
         switch msg.Type {
-        case tea.KeyCtrlC, tea.KeyEsc:
+        case tea.KeyTab, tea.KeyShiftTab:
+            step := 1
+            if msg.Type == tea.KeyShiftTab {
+                step = -1
+            }
+            m.complete(step)
+            return m, tea.Batch(tiCmd, listenForIncomingMessages(m.incomingChan))
+        case tea.KeyEsc:
+            if m.thread != nil {
+                m.closeThread()
+                return m, tea.Batch(tiCmd, m.markRead(), listenForIncomingMessages(m.incomingChan))
+            }
             return m, tea.Quit
+        case tea.KeyCtrlC:
+            return m, tea.Quit
+        case tea.KeyCtrlL:
+            m.logs.visible = !m.logs.visible
+            m.resize()
+            m.logs.view.GotoBottom()
+            return m, tea.Batch(tiCmd, listenForIncomingMessages(m.incomingChan))
+        case tea.KeyCtrlLeft, tea.KeyCtrlRight:
+            step := 1
+            if msg.Type == tea.KeyCtrlLeft {
+                step = -1
+            }
+            m.switchRoom(step)
+            return m, tea.Batch(tiCmd, m.markRead(), listenForIncomingMessages(m.incomingChan))
+        case tea.KeyCtrlR:
+            m.raw = !m.raw
+            m.refresh()
+            return m, tea.Batch(tiCmd, listenForIncomingMessages(m.incomingChan))
+        case tea.KeyCtrlG:
+            m.nextMention()
+            return m, tea.Batch(tiCmd, m.markRead(), listenForIncomingMessages(m.incomingChan))
+        case tea.KeyF3:
+            if m.logs.visible {
+                m.logs.cycleLevel()
+            }
+            return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+        case tea.KeyCtrlY, tea.KeyCtrlX:
+            // Answer the oldest pending file offer
+            if len(m.offers) == 0 {
+                break
+            }
+            offer := m.offers[0]
+            m.offers = m.offers[1:]
+            command := "/decline " + offer.ID
+            if msg.Type == tea.KeyCtrlY {
+                command = "/accept " + offer.ID
+            }
+            m.resize()
+            return m, tea.Batch(tiCmd, vpCmd, m.send(command), listenForIncomingMessages(m.incomingChan))
         case tea.KeyEnter:
+            if msg.Alt {
+                break // alt+enter inserted a newline
+            }
             // Get the message text before resetting
-            messageText := strings.TrimSpace(m.textarea.Value())
-            if messageText == "" {
+            messageText := strings.TrimRight(m.textarea.Value(), " \t\r\n")
+            if id := m.editing; id != "" {
+                // Sending an empty edit cancels it
+                m.editing = ""
+                m.textarea.Reset()
+                m.resize()
+                if strings.TrimSpace(messageText) == "" {
+                    return m, tea.Batch(tiCmd, vpCmd)
+                }
+                return m, tea.Batch(tiCmd, vpCmd, m.send("/edit "+id+" "+messageText), listenForIncomingMessages(m.incomingChan))
+            }
+            if m.replying != nil && strings.TrimSpace(messageText) == "" {
+                // An empty line takes the reply back
+                m.replying = nil
+                m.resize()
                 return m, tea.Batch(tiCmd, vpCmd)
             }
+            if id := m.reacting; id != "" {
+                m.reacting = ""
+                m.textarea.Reset()
+                m.resize()
+                if strings.TrimSpace(messageText) == "" {
+                    return m, tea.Batch(tiCmd, vpCmd)
+                }
+                return m, tea.Batch(tiCmd, vpCmd, m.send("/react "+id+" "+strings.TrimSpace(messageText)), listenForIncomingMessages(m.incomingChan))
+            }
+            if strings.TrimSpace(messageText) == "" {
+                return m, tea.Batch(tiCmd, vpCmd)
+            }
+            switch messageText {
+            case "/edit":
+                m.textarea.Reset()
+                m.startEdit()
+                return m, tea.Batch(tiCmd, vpCmd)
+            case "/delete":
+                m.textarea.Reset()
+                e := m.lastOwn()
+                if e == nil {
+                    m.addLine(m.current, &entry{msg: Message{From: "System", Text: "You have no message here to delete"}}, false)
+                    return m, tea.Batch(tiCmd, vpCmd)
+                }
+                return m, tea.Batch(tiCmd, vpCmd, m.send("/delete "+e.msg.ID), listenForIncomingMessages(m.incomingChan))
+            case "/info", "/retry":
+                m.textarea.Reset()
+                e := m.lastOwn()
+                if e == nil {
+                    m.addLine(m.current, &entry{msg: Message{From: "System", Text: "You have no message here"}}, false)
+                    return m, tea.Batch(tiCmd, vpCmd)
+                }
+                return m, tea.Batch(tiCmd, vpCmd, m.send(messageText+" "+e.msg.ID), listenForIncomingMessages(m.incomingChan))
+            }
+            saveCmd := m.remember(messageText)
             
-            // Add to local display
-            m.messages = append(m.messages, m.SenderStyle.Render("You: ")+messageText)
-            m.viewport.SetContent(strings.Join(m.messages, "\n"))
+            // Sent messages are displayed when the node echoes them back
+            // with Self set, so every attached client shows them the same way
             m.textarea.Reset()
             m.viewport.GotoBottom()
-            
-            // Send message through channel if available
-            if m.outgoingChan != nil {
-                go func() {
-                    select {
-                    case m.outgoingChan <- messageText:
-                    default:
-                        // Channel is full, message dropped
-                    }
-                }()
+            switch {
+            case strings.HasPrefix(messageText, "/"):
+            case m.replying != nil:
+                messageText = "/reply " + m.replying.msg.ID + " " + messageText
+                m.replying = nil
+                m.resize()
+            case m.thread != nil:
+                // In a thread everything sent goes on with it
+                messageText = "/reply " + m.thread.msg.ID + " " + messageText
+            case m.current != "":
+                messageText = "/room " + m.current + " " + messageText
             }
-            
-            return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+
+            return m, tea.Batch(tiCmd, vpCmd, m.send(messageText), saveCmd, listenForIncomingMessages(m.incomingChan))
         }
     case Message: 
-        // Format incoming messages with sender name and proper styling
-        var formattedMsg string
-        if msg.From == "System" {
-            // System messages (join/leave notifications)
-            formattedMsg = m.SystemStyle.Render(fmt.Sprintf("• %s", msg.Text))
-        } else {
-            // Peer messages - only color the name, not the entire message
-            coloredName := m.PeerStyle.Render(msg.From)
-            formattedMsg = fmt.Sprintf("%s: %s", coloredName, msg.Text)
+        switch msg.Kind {
+        case KindProgress:
+            if msg.Text == "" {
+                delete(m.transfers, msg.ID)
+            } else {
+                m.transfers[msg.ID] = msg.Text
+            }
+            m.dropOffer(msg.ID)
+            m.resize()
+            return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+        case KindOffer:
+            m.dropOffer(msg.ID)
+            m.offers = append(m.offers, msg)
+            m.resize()
+            return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+        case KindEdit, KindDelete, KindReact, KindUnreact, KindReceipt:
+            m.change(msg)
+            return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+        case KindPresence:
+            m.setPresence(msg)
+            return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+        case KindTyping:
+            return m, tea.Batch(tiCmd, vpCmd, m.setTyping(msg), listenForIncomingMessages(m.incomingChan))
         }
-        m.messages = append(m.messages, formattedMsg)
-        m.viewport.SetContent(strings.Join(m.messages, "\n"))
-        m.viewport.GotoBottom()
-        
+        if !msg.Self && m.typing[msg.From].room == msg.Room {
+            // The message they were writing has arrived
+            delete(m.typing, msg.From)
+        }
+
+        highlight := m.isHighlight(msg)
+
+        // Notices not tied to a room show wherever the user is looking
+        room := msg.Room
+        if msg.From == "System" && room == "" {
+            room = m.current
+        }
+        counts := !msg.Self && msg.From != "System"
+        m.addLine(room, &entry{msg: msg, highlight: highlight}, counts)
+        m.resize()
+
         // Continue listening for more incoming messages
-        return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+        cmds := []tea.Cmd{tiCmd, vpCmd, m.markRead(), listenForIncomingMessages(m.incomingChan)}
+        if highlight {
+            cmds = append(cmds, ring(m.bell))
+        }
+        return m, tea.Batch(cmds...)
         
+    case typingExpiredMsg:
+        m.expireTyping()
+        return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
+
+    case backendClosedMsg:
+        // Stop polling the closed channel and say so once
+        m.incomingChan = nil
+        m.addLine(m.current, &entry{msg: Message{From: "System", Text: "Disconnected from the node"}}, false)
+        return m, tea.Batch(tiCmd, vpCmd)
+
+    case logBatch:
+        m.logs.add(msg)
+        return m, tea.Batch(tiCmd, vpCmd, waitForLogs(m.logs.ch))
+
     case errMsg:
         m.err = msg
         return m, nil
@@ -166,11 +517,171 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
     return m, tea.Batch(tiCmd, vpCmd, listenForIncomingMessages(m.incomingChan))
 }
 
+// format renders a chat line to fit width columns. The body is wrapped on
+// its own so continuation lines sit indented under the first.
+func (m *Model) format(e *entry, width int) string {
+    msg := e.msg
+    var prefix string
+    switch {
+    case msg.From == "":
+        return msg.Text
+    case msg.From == "System":
+        // System messages (join/leave notifications)
+        return m.SystemStyle.Render("• " + indent(wrap(msg.Text, width-2), 2))
+    case msg.Self:
+        prefix = m.SenderStyle.Render("You: ")
+    default:
+        // Peer messages - only color the name, not the entire message
+        prefix = m.PeerStyle.Render(msg.From) + ": "
+    }
+
+    if msg.ReplyTo != "" {
+        prefix = m.quote(e, width) + "\n" + prefix
+    }
+    w := lipgloss.Width(prefix)
+    if e.deleted {
+        return prefix + m.StatusStyle.Render("message deleted")
+    }
+    base := lipgloss.NewStyle()
+    if e.highlight {
+        base = m.HighlightStyle
+    }
+    body := base.Render(msg.Text)
+    if !m.raw {
+        body = m.markdown(msg.Text, base)
+    }
+    if msg.Edited {
+        body += " " + m.StatusStyle.Render("(edited)")
+    }
+    if mark := m.receiptMark(msg.Receipts); msg.Self && mark != "" {
+        body += " " + mark
+    }
+    if len(msg.Reactions) > 0 {
+        body += "\n" + m.reactionLine(msg.Reactions)
+    }
+    return prefix + indent(wrap(body, width-w), w)
+}
+
+// wrap fits text to width columns, leaving it alone if the view is too
+// narrow to be worth it
+func wrap(text string, width int) string {
+    if width < 10 {
+        return text
+    }
+    return lipgloss.NewStyle().Width(width).Render(text)
+}
+
+// indent lines the continuation lines of a multi-line message up under
+// its first line, after a prefix width columns wide
+func indent(text string, width int) string {
+    return strings.ReplaceAll(text, "\n", "\n"+strings.Repeat(" ", width))
+}
+
+// fitInput grows or shrinks the input box to its content
+func (m *Model) fitInput() {
+    h := min(max(m.textarea.LineCount(), 1), maxInputHeight)
+    if h != m.textarea.Height() {
+        m.textarea.SetHeight(h)
+        m.resize()
+    }
+}
+
+func (m *Model) dropOffer(id string) {
+    for i, o := range m.offers {
+        if o.ID == id {
+            m.offers = append(m.offers[:i], m.offers[i+1:]...)
+            return
+        }
+    }
+}
+
+// statusView renders transfer progress and pending offers shown between
+// the viewport and the input box. It is empty when there is nothing to show.
+func (m Model) statusView() string {
+    var lines []string
+    if m.status != "" && m.status != "online" {
+        lines = append(lines, "You are "+m.status+" · /status online to clear it")
+    }
+    if typing := m.typingLine(); typing != "" {
+        lines = append(lines, typing)
+    }
+    if m.editing != "" {
+        lines = append(lines, "Editing your message · Enter saves · clear it and Enter to cancel")
+    }
+    if m.selected != nil {
+        lines = append(lines, "Select a message with ↑ ↓ · Enter reply · t thread · 1-6 "+strings.Join(quickReactions, " ")+" · r other reaction · i info · R retry · Esc done")
+    }
+    if m.reacting != "" {
+        lines = append(lines, "React with an emoji or :short_code: · Enter sends · empty Enter cancels")
+    }
+    if m.replying != nil {
+        lines = append(lines, "Replying to "+preview(m.replying)+" · empty Enter cancels")
+    }
+    if m.thread != nil {
+        lines = append(lines, "Thread of "+preview(m.thread)+" · Esc back to #"+roomLabel(m.current))
+    }
+    ids := make([]string, 0, len(m.transfers))
+    for id := range m.transfers {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    for _, id := range ids {
+        lines = append(lines, m.transfers[id])
+    }
+    if len(m.offers) > 0 {
+        o := m.offers[0]
+        line := fmt.Sprintf("%s offers %s · ctrl+y accept · ctrl+x decline", o.From, o.Text)
+        if len(m.offers) > 1 {
+            line += fmt.Sprintf(" (+%d more)", len(m.offers)-1)
+        }
+        lines = append(lines, line)
+    }
+    if len(lines) == 0 {
+        return ""
+    }
+    return m.StatusStyle.Render(strings.Join(lines, "\n"))
+}
+
+// resize lays out the viewport around the log pane, status area and
+// input box
+func (m *Model) resize() {
+    if m.height == 0 {
+        return
+    }
+    h := m.height - m.textarea.Height() - lipgloss.Height(gap) - lipgloss.Height(m.roomBar())
+    if peers := m.peerBar(); peers != "" {
+        h -= lipgloss.Height(peers)
+    }
+    if status := m.statusView(); status != "" {
+        h -= lipgloss.Height(status)
+    }
+    if m.logs.visible {
+        // The log pane takes a third of the space plus its header line
+        logHeight := max(h/3, 3)
+        m.logs.view.Height = logHeight
+        h -= logHeight + 1
+    }
+    m.viewport.Height = max(h, 1)
+}
+
 func (m Model) View() string {
+    status := m.statusView()
+    if status != "" {
+        status += "\n"
+    }
+    view := m.roomBar() + "\n"
+    if peers := m.peerBar(); peers != "" {
+        view += peers + "\n"
+    }
+    view += m.viewport.View()
+    if m.logs.visible {
+        view += "\n" + m.logs.View(m.viewport.Width)
+    }
     return fmt.Sprintf(
-        "%s%s%s",
-        m.viewport.View(),
+        "%s%s%s%s",
+        view,
         gap,
+        status,
         m.textarea.View(),
     )
 }
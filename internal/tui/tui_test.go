@@ -1,8 +1,16 @@
 package tui
 
 import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
 )
 
 func TestTUIMessageHandling(t *testing.T) {
@@ -78,3 +86,555 @@ func TestMessageStruct(t *testing.T) {
 		t.Errorf("Expected Text: 'Hello, Bob!', got: '%s'", msg.Text)
 	}
 }
+
+func TestLogPaneFiltersByLevel(t *testing.T) {
+	model := InitModel()
+	updated, _ := model.Update(tea.WindowSizeMsg{Width: 80, Height: 30})
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyCtrlL})
+	updated, _ = updated.Update(logBatch{
+		{Time: time.Now(), Level: slog.LevelDebug, Message: "dialed peer"},
+		{Time: time.Now(), Level: slog.LevelError, Message: "handshake failed"},
+	})
+
+	view := updated.View()
+	if !strings.Contains(view, "handshake failed") {
+		t.Error("Expected error record in log pane")
+	}
+	if strings.Contains(view, "dialed peer") {
+		t.Error("Debug record should be hidden at the default level")
+	}
+
+	// F3 wraps round from info through to debug
+	for i := 0; i < 3; i++ {
+		updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyF3})
+	}
+	if !strings.Contains(updated.View(), "dialed peer") {
+		t.Error("Expected debug record once the filter is at debug")
+	}
+
+	updated, _ = updated.Update(tea.KeyMsg{Type: tea.KeyCtrlL})
+	if strings.Contains(updated.View(), "handshake failed") {
+		t.Error("Log pane should be hidden after toggling it off")
+	}
+}
+
+// update feeds msgs to the model in turn. Commands are run, so the bell
+// rings into the model's buffer and lines reach the backend, except those
+// for typing, which only blink the cursor.
+func update(t *testing.T, m Model, msgs ...tea.Msg) Model {
+	t.Helper()
+	for _, msg := range msgs {
+		next, cmd := m.Update(msg)
+		m = next.(Model)
+		if k, ok := msg.(tea.KeyMsg); !ok || k.Type == tea.KeyEnter {
+			runCmd(cmd)
+		}
+	}
+	return m
+}
+
+func runCmd(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	if batch, ok := cmd().(tea.BatchMsg); ok {
+		for _, c := range batch {
+			runCmd(c)
+		}
+	}
+}
+
+func TestMentionsAndUnread(t *testing.T) {
+	var bell strings.Builder
+	m := InitModel()
+	m.bell = &bell
+	m.SetHighlights("alice", []string{"deploy"})
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		Message{From: "bob", Text: "morning"},
+		Message{From: "bob", Text: "ops chatter", Room: "ops"},
+		Message{From: "bob", Text: "@alice can you look?", Room: "ops"},
+		Message{From: "carol", Text: "email@alice.example is not a mention", Room: "ops"},
+		Message{From: "carol", Text: "Deploy done", Room: "dev"},
+		Message{From: "alice", Text: "@alice talking to myself", Self: true},
+	)
+
+	if got := strings.Count(bell.String(), "\a"); got != 2 {
+		t.Errorf("Expected the bell twice, got %d", got)
+	}
+	if m.rooms[""].unread != 0 || m.rooms["ops"].unread != 3 || m.rooms["dev"].unread != 1 {
+		t.Errorf("Unexpected unread counts: general %d, ops %d, dev %d",
+			m.rooms[""].unread, m.rooms["ops"].unread, m.rooms["dev"].unread)
+	}
+	if len(m.rooms["ops"].mentions) != 1 {
+		t.Errorf("Expected one mention in ops, got %v", m.rooms["ops"].mentions)
+	}
+	if bar := m.roomBar(); !strings.Contains(bar, "#ops 3 @1") || !strings.Contains(bar, "#dev 1 @1") {
+		t.Errorf("Unexpected room bar %q", bar)
+	}
+
+	// Jumping to the mention opens ops with the marker above its unread lines
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlG})
+	if m.current != "ops" || m.rooms["ops"].unread != 0 || len(m.rooms["ops"].mentions) != 0 {
+		t.Fatalf("Expected to be in ops with nothing unread, in %q with %+v", m.current, m.rooms["ops"])
+	}
+	view := m.View()
+	if strings.Index(view, " new ") > strings.Index(view, "ops chatter") {
+		t.Error("Expected the new marker before the first unread line")
+	}
+
+	// The next jump goes on to dev; leaving ops drops its marker
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlG})
+	if m.current != "dev" || m.rooms["ops"].marker != -1 {
+		t.Errorf("Expected to be in dev with the ops marker gone, in %q", m.current)
+	}
+}
+
+func TestRoomsCountWhileUnfocused(t *testing.T) {
+	m := InitModel()
+	m.bell = nil
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		tea.BlurMsg{},
+		Message{From: "bob", Text: "you there?"},
+	)
+	if m.rooms[""].unread != 1 || m.rooms[""].marker < 0 {
+		t.Errorf("Expected an unread line with a marker, got %+v", m.rooms[""])
+	}
+	m = update(t, m, tea.FocusMsg{})
+	if m.rooms[""].unread != 0 || !strings.Contains(m.View(), " new ") {
+		t.Errorf("Expected the count cleared and the marker kept, got %+v", m.rooms[""])
+	}
+
+	// ctrl+right cycles rooms; typed text then goes to the room shown
+	out := make(chan string, 1)
+	m.backend = chanBackend{out: out}
+	m = update(t, m,
+		Message{From: "bob", Text: "hi", Room: "ops"},
+		tea.KeyMsg{Type: tea.KeyCtrlRight},
+		tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("hello")},
+		tea.KeyMsg{Type: tea.KeyEnter},
+	)
+	if got := <-out; got != "/room ops hello" {
+		t.Errorf("Expected the line sent to ops, got %q", got)
+	}
+}
+
+func typeKeys(text string) []tea.Msg {
+	return []tea.Msg{tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)}}
+}
+
+func TestTabCompletion(t *testing.T) {
+	m := InitModel()
+	m.SetPeerSource(func() []string { return []string{"bob", "Bella", "carol"} })
+	tab := tea.KeyMsg{Type: tea.KeyTab}
+
+	m = update(t, m, append(typeKeys("b"), tab)...)
+	if got := m.textarea.Value(); got != "Bella: " {
+		t.Errorf("Expected the first nick at line start, got %q", got)
+	}
+	m = update(t, m, tab)
+	if got := m.textarea.Value(); got != "bob: " {
+		t.Errorf("Expected Tab to cycle, got %q", got)
+	}
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyShiftTab})
+	if got := m.textarea.Value(); got != "Bella: " {
+		t.Errorf("Expected shift+Tab to cycle back, got %q", got)
+	}
+
+	m.textarea.Reset()
+	m = update(t, m, append(typeKeys("ask @ca"), tab)...)
+	if got := m.textarea.Value(); got != "ask @carol " {
+		t.Errorf("Expected a mention completed mid-line, got %q", got)
+	}
+
+	m.textarea.Reset()
+	m = update(t, m, append(typeKeys("/se"), tab)...)
+	m = update(t, m, append(typeKeys("c"), tab)...)
+	if got := m.textarea.Value(); got != "/send carol " {
+		t.Errorf("Expected command and peer completed, got %q", got)
+	}
+
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "logs"), 0o755)
+	os.WriteFile(filepath.Join(dir, "logs", "build.log"), nil, 0o644)
+	m = update(t, m, append(typeKeys(dir+"/l"), tab, tab)...)
+	if got := m.textarea.Value(); got != "/send carol "+dir+"/logs/build.log" {
+		t.Errorf("Expected the path completed through the directory, got %q", got)
+	}
+}
+
+func TestMultiLineInput(t *testing.T) {
+	out := make(chan string, 1)
+	m := InitModelWithChannels(out, nil)
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("trace:")},
+		tea.KeyMsg{Type: tea.KeyEnter, Alt: true},
+		tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("panic: boom\n\tmain.go:12\n\tmain.go:7"), Paste: true},
+	)
+	if m.textarea.Height() != 4 {
+		t.Errorf("Expected the input to grow to 4 lines, got %d", m.textarea.Height())
+	}
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlJ})
+	for i := 0; i < 10; i++ {
+		m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlJ})
+	}
+	if m.textarea.Height() != maxInputHeight {
+		t.Errorf("Expected the input to stop at %d lines, got %d", maxInputHeight, m.textarea.Height())
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEnter})
+	if got, want := <-out, "trace:\npanic: boom\n    main.go:12\n    main.go:7"; got != want {
+		t.Errorf("Sent %q, want %q", got, want)
+	}
+	if m.textarea.Height() != 1 {
+		t.Errorf("Expected the input back to one line, got %d", m.textarea.Height())
+	}
+
+	m = update(t, m, Message{From: "bob", Text: "first\nsecond"})
+	if view := m.View(); !strings.Contains(view, "bob: first") || !strings.Contains(view, "\n     second") {
+		t.Errorf("Expected the continuation line indented under the first:\n%s", m.View())
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	m := InitModel()
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		Message{From: "bob", Text: "**bold** and `code`, see [docs](https://example.com/docs)\n```go\nfunc main() {}\n```"},
+	)
+	view := m.View()
+	for _, want := range []string{"bob: bold and code, see docs (https://example.com/docs)", "│ func main() {}"} {
+		if !strings.Contains(view, want) {
+			t.Errorf("Expected %q in view:\n%s", want, view)
+		}
+	}
+	if strings.Contains(view, "```") {
+		t.Error("Code fences should not be shown")
+	}
+
+	// ctrl+r shows the text as it was typed
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlR})
+	if view := m.View(); !strings.Contains(view, "**bold** and `code`") || !strings.Contains(view, "```go") {
+		t.Errorf("Expected raw text after ctrl+r:\n%s", view)
+	}
+}
+
+func TestInputHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input_history")
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	if err := m.SetHistoryFile(path); err != nil {
+		t.Fatal(err)
+	}
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	m = update(t, m, append(typeKeys("first"), enter)...)
+	m = update(t, m, append(typeKeys("second\n  indented"), enter)...)
+
+	// A fresh model picks the lines up from the file
+	m = InitModelWithChannels(out, nil)
+	if err := m.SetHistoryFile(path); err != nil {
+		t.Fatal(err)
+	}
+	up, down := tea.KeyMsg{Type: tea.KeyUp}, tea.KeyMsg{Type: tea.KeyDown}
+	m = update(t, m, up)
+	if got := m.textarea.Value(); got != "second\n  indented" {
+		t.Errorf("Expected the last line sent, got %q", got)
+	}
+	m = update(t, m, up, up)
+	if got := m.textarea.Value(); got != "first" {
+		t.Errorf("Expected to stop at the oldest line, got %q", got)
+	}
+	m = update(t, m, down, down)
+	if got := m.textarea.Value(); got != "" {
+		t.Errorf("Expected Down past the newest line to clear the input, got %q", got)
+	}
+
+	// Up leaves text being written alone
+	m = update(t, m, append(typeKeys("draft"), up)...)
+	if got := m.textarea.Value(); got != "draft" {
+		t.Errorf("Expected the draft kept, got %q", got)
+	}
+}
+
+func TestInputHistoryLongLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input_history")
+	long := []string{strings.Repeat("<", maxInput), strings.Repeat("é", maxInput)}
+	var b []byte
+	for _, line := range long {
+		enc, _ := json.Marshal(line)
+		b = append(append(b, enc...), '\n')
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := InitModelWithChannels(make(chan string, 1), nil)
+	if err := m.SetHistoryFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.sent) != 2 || m.sent[0] != long[0] || m.sent[1] != long[1] {
+		t.Errorf("Expected both long lines loaded, got %d lines", len(m.sent))
+	}
+}
+
+func TestEditAndDelete(t *testing.T) {
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		Message{From: "alice", Text: "helo", ID: "m1", Self: true},
+		Message{From: "bob", Text: "hi", ID: "m2"},
+		tea.KeyMsg{Type: tea.KeyUp, Alt: true},
+	)
+	if m.editing != "m1" || m.textarea.Value() != "helo" {
+		t.Fatalf("Expected to be editing m1, editing %q with %q", m.editing, m.textarea.Value())
+	}
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyBackspace})
+	m = update(t, m, append(typeKeys("lo"), enter)...)
+	if got := <-out; got != "/edit m1 hello" {
+		t.Errorf("Expected the edit sent, got %q", got)
+	}
+
+	m = update(t, m, Message{Kind: KindEdit, From: "alice", Text: "hello", ID: "m1", Self: true})
+	if view := m.View(); !strings.Contains(view, "You: hello (edited)") {
+		t.Errorf("Expected the edited line:\n%s", view)
+	}
+
+	m = update(t, m, append(typeKeys("/delete"), enter)...)
+	if got := <-out; got != "/delete m1" {
+		t.Errorf("Expected the delete sent, got %q", got)
+	}
+	m = update(t, m, Message{Kind: KindDelete, From: "bob", ID: "m2"})
+	view := m.View()
+	if strings.Contains(view, "bob: hi") || !strings.Contains(view, "bob: message deleted") {
+		t.Errorf("Expected bob's line retracted:\n%s", view)
+	}
+}
+
+func TestReactions(t *testing.T) {
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		Message{From: "bob", Text: "shipped it", ID: "m1"},
+		Message{From: "System", Text: "carol joined the chat"},
+		Message{From: "carol", Text: "nice", ID: "m2"},
+	)
+
+	// ctrl+s selects the newest message and Up moves past the notice
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlS}, tea.KeyMsg{Type: tea.KeyUp})
+	if m.selected == nil || m.selected.msg.ID != "m1" {
+		t.Fatalf("Expected m1 selected, got %+v", m.selected)
+	}
+	next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("1")})
+	m = next.(Model)
+	runCmd(cmd)
+	if got := <-out; got != "/react m1 👍" {
+		t.Errorf("Expected a quick reaction, got %q", got)
+	}
+	if m.selected != nil {
+		t.Error("Expected reacting to leave selection mode")
+	}
+
+	m = update(t, m,
+		Message{Kind: KindReact, From: "alice", Text: "👍", ID: "m1", Self: true},
+		Message{Kind: KindReact, From: "carol", Text: "👍", ID: "m1"},
+		Message{Kind: KindReact, From: "carol", Text: "🎉", ID: "m1"},
+	)
+	if view := m.View(); !strings.Contains(view, "👍 2  🎉 1") {
+		t.Errorf("Expected aggregated reactions:\n%s", view)
+	}
+	m = update(t, m, Message{Kind: KindUnreact, From: "carol", Text: "🎉", ID: "m1"})
+	if view := m.View(); strings.Contains(view, "🎉") || !m.rooms[""].lines[1].msg.Reactions[0].Self {
+		t.Errorf("Expected carol's reaction gone and ours kept:\n%s", view)
+	}
+
+	// r asks for any other reaction
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlS}, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")})
+	m = update(t, m, append(typeKeys(":tada:"), tea.KeyMsg{Type: tea.KeyEnter})...)
+	if got := <-out; got != "/react m2 :tada:" {
+		t.Errorf("Expected a typed reaction, got %q", got)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	enter := tea.KeyMsg{Type: tea.KeyEnter}
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		Message{From: "bob", Text: "who broke the build?", ID: "m1"},
+		Message{From: "carol", Text: "lunch anyone?", ID: "m2"},
+		Message{From: "alice", Text: "looking", ID: "m3", ReplyTo: "m1", Self: true},
+		Message{From: "bob", Text: "thanks", ID: "m4", ReplyTo: "m3"},
+	)
+	view := m.View()
+	if q := strings.Index(view, "↪ bob: who broke the build?"); q < 0 || q > strings.Index(view, "You: looking") {
+		t.Errorf("Expected the reply under a quote of its parent:\n%s", view)
+	}
+
+	// Enter in selection mode replies to the selected message
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlS}, enter)
+	m = update(t, m, append(typeKeys("np"), enter)...)
+	if got := <-out; got != "/reply m4 np" {
+		t.Errorf("Expected a reply to m4, got %q", got)
+	}
+
+	// t shows the thread on its own, and lines sent there join it
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlS}, tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("t")})
+	view = m.View()
+	if strings.Contains(view, "lunch") || !strings.Contains(view, "who broke") || !strings.Contains(view, "thanks") {
+		t.Errorf("Expected only the thread:\n%s", view)
+	}
+	m = update(t, m, append(typeKeys("fixed"), enter)...)
+	if got := <-out; got != "/reply m1 fixed" {
+		t.Errorf("Expected a reply to the thread root, got %q", got)
+	}
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyEsc})
+	if m.thread != nil || !strings.Contains(m.View(), "lunch") {
+		t.Error("Expected Esc to go back to the whole room")
+	}
+}
+
+func TestPresenceAndTyping(t *testing.T) {
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 100, Height: 30},
+		Message{Kind: KindPresence, From: "bob", Text: "online"},
+		Message{Kind: KindPresence, From: "carol", Text: "away: lunch"},
+		Message{Kind: KindPresence, From: "alice", Text: "busy", Self: true},
+	)
+	view := m.View()
+	if !strings.Contains(view, "● bob") || !strings.Contains(view, "◌ carol away lunch") {
+		t.Errorf("Expected both peers in the peer list:\n%s", view)
+	}
+	if !strings.Contains(view, "You are busy") {
+		t.Errorf("Expected the user's own status shown:\n%s", view)
+	}
+
+	// The tick that expires the notice is not run here
+	next, _ := m.Update(Message{Kind: KindTyping, From: "bob"})
+	m = next.(Model)
+	next, _ = m.Update(Message{Kind: KindTyping, From: "carol", Room: "ops"})
+	m = next.(Model)
+	if view := m.View(); !strings.Contains(view, "bob is typing…") || strings.Contains(view, "carol is typing") {
+		t.Errorf("Expected only bob typing in this room:\n%s", view)
+	}
+	m = update(t, m, Message{From: "bob", Text: "done"})
+	if view := m.View(); strings.Contains(view, "bob is typing") {
+		t.Errorf("Expected bob's message to end the typing notice:\n%s", view)
+	}
+	m.typing["carol"] = typist{room: "ops"}
+	m = update(t, m, typingExpiredMsg{}, Message{Kind: KindPresence, From: "carol", Text: "offline"})
+	if len(m.typing) != 0 || strings.Contains(m.View(), "carol") {
+		t.Errorf("Expected carol gone, typing %v:\n%s", m.typing, m.View())
+	}
+
+	// Writing a chat line tells peers, at most every few seconds; commands
+	// do not
+	_, cmd := m.Update(typeKeys("h")[0])
+	runCmd(cmd)
+	if got := <-out; got != "/typing" {
+		t.Errorf("Expected a typing signal, got %q", got)
+	}
+	m.lastTyping = time.Time{}
+	_, cmd = m.Update(typeKeys("/status")[0])
+	runCmd(cmd)
+	select {
+	case got := <-out:
+		t.Errorf("Expected no typing signal for a command, got %q", got)
+	default:
+	}
+}
+
+func TestReadOnlyOnScreen(t *testing.T) {
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	m.SetReadReceipts(true)
+	m = update(t, m, tea.WindowSizeMsg{Width: 80, Height: 16}, tea.BlurMsg{})
+	for i := 0; i < 40; i++ {
+		m = update(t, m, Message{From: "bob", Text: fmt.Sprintf("line %d", i), ID: fmt.Sprintf("m%d", i)})
+	}
+	m = update(t, m, tea.FocusMsg{})
+	got := " " + <-out + " "
+	if !strings.Contains(got, " m39 ") || strings.Contains(got, " m0 ") {
+		t.Errorf("Expected only the lines on screen read, got %q", got)
+	}
+
+	// Scrolling up reports the older lines as they come into view
+	for i := 0; i < 10; i++ {
+		next, cmd := m.Update(tea.KeyMsg{Type: tea.KeyPgUp})
+		m = next.(Model)
+		runCmd(cmd)
+	}
+	var read []string
+	for len(out) > 0 {
+		read = append(read, <-out)
+	}
+	if all := " " + strings.Join(read, " ") + " "; !strings.Contains(all, " m0 ") || strings.Contains(all, " m39 ") {
+		t.Errorf("Expected the older lines read once scrolled to, got %q", read)
+	}
+}
+
+func TestSetPresence(t *testing.T) {
+	m := InitModelWithChannels(make(chan string, 1), nil)
+	m.SetPresence("away: idle", map[string]string{"bob": "busy: deploying"})
+	m = update(t, m, tea.WindowSizeMsg{Width: 100, Height: 30})
+	view := m.View()
+	if !strings.Contains(view, "⊖ bob busy deploying") || !strings.Contains(view, "You are away: idle") {
+		t.Errorf("Expected seeded statuses shown:\n%s", view)
+	}
+}
+
+func TestReceipts(t *testing.T) {
+	out := make(chan string, 10)
+	m := InitModelWithChannels(out, nil)
+	m.SetReadReceipts(true)
+	m = update(t, m,
+		tea.WindowSizeMsg{Width: 80, Height: 30},
+		Message{From: "alice", Text: "ship it", ID: "m1", Self: true, Receipts: map[string]string{"bob": "sent", "carol": "failed"}},
+	)
+	if view := m.View(); !strings.Contains(view, "ship it ✗ not delivered to carol") {
+		t.Errorf("Expected carol's failure marked:\n%s", view)
+	}
+	m = update(t, m,
+		Message{Kind: KindReceipt, From: "carol", Text: "read", ID: "m1"},
+		Message{Kind: KindReceipt, From: "bob", Text: "delivered", ID: "m1"},
+	)
+	if view := m.View(); !strings.Contains(view, "ship it ✓") || strings.Contains(view, "✓✓") {
+		t.Errorf("Expected a delivered mark:\n%s", view)
+	}
+	m = update(t, m, Message{Kind: KindReceipt, From: "bob", Text: "read", ID: "m1"})
+	if view := m.View(); !strings.Contains(view, "ship it ✓✓") {
+		t.Errorf("Expected a read mark:\n%s", view)
+	}
+	m = update(t, m, Message{From: "alice", Text: "later", ID: "m4", Self: true, Receipts: map[string]string{"bob": "sent", "dave": "queued"}})
+	if view := m.View(); !strings.Contains(view, "later ⏳ waiting for dave") {
+		t.Errorf("Expected dave's queued message marked:\n%s", view)
+	}
+
+	// Peers' lines are reported read once, and only while focused
+	m = update(t, m, tea.BlurMsg{}, Message{From: "bob", Text: "on it", ID: "m2"})
+	select {
+	case got := <-out:
+		t.Errorf("Expected nothing reported while unfocused, got %q", got)
+	default:
+	}
+	m = update(t, m, tea.FocusMsg{}, Message{From: "bob", Text: "done", ID: "m3"})
+	if got := <-out; got != "/read m2" {
+		t.Errorf("Expected m2 read on focus, got %q", got)
+	}
+	if got := <-out; got != "/read m3" {
+		t.Errorf("Expected m3 read as it arrived, got %q", got)
+	}
+
+	m = update(t, m, tea.KeyMsg{Type: tea.KeyCtrlS}, tea.KeyMsg{Type: tea.KeyUp}, tea.KeyMsg{Type: tea.KeyUp}, tea.KeyMsg{Type: tea.KeyUp})
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("i")})
+	runCmd(cmd)
+	if got := <-out; got != "/info m1" {
+		t.Errorf("Expected info asked for the selected message, got %q", got)
+	}
+}
@@ -1,7 +1,17 @@
 package util
 
-
-
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
 
 type LogLevel int
 
@@ -19,4 +29,289 @@ func (l LogLevel) String() string {
     return []string{"DEBUG:", "INFO:", "WARNING:", "ERROR:", "FATAL:", "PANIC:"}[l]
 }
 
+// Level maps l onto slog's scale. Fatal and Panic sit above slog.LevelError.
+func (l LogLevel) Level() slog.Level {
+    switch l {
+    case Debug:
+        return slog.LevelDebug
+    case Info:
+        return slog.LevelInfo
+    case Warning:
+        return slog.LevelWarn
+    case Error:
+        return slog.LevelError
+    case Fatal:
+        return slog.LevelError + 4
+    default:
+        return slog.LevelError + 8
+    }
+}
+
+// ParseLevel accepts the names used by the -log-level flag
+func ParseLevel(s string) (LogLevel, error) {
+    switch strings.ToLower(strings.TrimSpace(s)) {
+    case "debug":
+        return Debug, nil
+    case "info", "":
+        return Info, nil
+    case "warn", "warning":
+        return Warning, nil
+    case "error":
+        return Error, nil
+    case "fatal":
+        return Fatal, nil
+    case "panic":
+        return Panic, nil
+    }
+    return Info, fmt.Errorf("unknown log level %q (want debug, info, warning or error)", s)
+}
+
+// NewLogger returns a structured logger writing text records to w, dropping
+// anything below level.
+func NewLogger(w io.Writer, level LogLevel) *slog.Logger {
+    return slog.New(NewHandler(w, level))
+}
+
+// NewHandler is the handler behind NewLogger, for combining with Fanout
+func NewHandler(w io.Writer, level LogLevel) slog.Handler {
+    return slog.NewTextHandler(w, &slog.HandlerOptions{
+        Level: level.Level(),
+        ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+            // Name our extra levels instead of printing "ERROR+4"
+            if a.Key == slog.LevelKey && len(groups) == 0 {
+                switch a.Value.Any().(slog.Level) {
+                case Fatal.Level():
+                    a.Value = slog.StringValue("FATAL")
+                case Panic.Level():
+                    a.Value = slog.StringValue("PANIC")
+                }
+            }
+            return a
+        },
+    })
+}
+
+// Discard is a logger that drops everything, used until one is configured
+func Discard() *slog.Logger {
+    return slog.New(slog.DiscardHandler)
+}
+
+// OrDiscard returns l, or a discarding logger if l is nil
+func OrDiscard(l *slog.Logger) *slog.Logger {
+    if l == nil {
+        return Discard()
+    }
+    return l
+}
+
+// RotatingFile is an io.Writer that appends to a log file and rotates it
+// once it grows past maxSize, keeping up to maxBackups old files named
+// path.1, path.2, ...
+type RotatingFile struct {
+    mu sync.Mutex
+    path string
+    maxSize int64
+    maxBackups int
+    f *os.File
+    size int64
+}
+
+func NewRotatingFile(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+    if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+        return nil, err
+    }
+    rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+    if err := rf.open(); err != nil {
+        return nil, err
+    }
+    return rf, nil
+}
+
+func (rf *RotatingFile) open() error {
+    f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+    if err != nil {
+        return err
+    }
+    info, err := f.Stat()
+    if err != nil {
+        f.Close()
+        return err
+    }
+    rf.f = f
+    rf.size = info.Size()
+    return nil
+}
+
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+    rf.mu.Lock()
+    defer rf.mu.Unlock()
+
+    if rf.f == nil {
+        return 0, os.ErrClosed
+    }
+    if rf.maxSize > 0 && rf.size+int64(len(p)) > rf.maxSize && rf.size > 0 {
+        if err := rf.rotate(); err != nil {
+            return 0, err
+        }
+    }
+    n, err := rf.f.Write(p)
+    rf.size += int64(n)
+    return n, err
+}
+
+func (rf *RotatingFile) rotate() error {
+    rf.f.Close()
+    rf.f = nil
+    if rf.maxBackups > 0 {
+        os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+        for i := rf.maxBackups - 1; i >= 1; i-- {
+            os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+        }
+        if err := os.Rename(rf.path, rf.path+".1"); err != nil && !os.IsNotExist(err) {
+            return err
+        }
+    } else {
+        os.Remove(rf.path)
+    }
+    return rf.open()
+}
+
+func (rf *RotatingFile) Close() error {
+    rf.mu.Lock()
+    defer rf.mu.Unlock()
+    if rf.f == nil {
+        return nil
+    }
+    err := rf.f.Close()
+    rf.f = nil
+    return err
+}
 
+// LogRecord is a log record flattened for display, e.g. in the TUI log pane
+type LogRecord struct {
+    Time time.Time
+    Level slog.Level
+    Message string
+    Attrs string // "key=value" pairs separated by spaces
+}
+
+// ChannelHandler is a slog.Handler that sends records to a channel. When
+// the channel is full records are dropped, so logging never blocks on a
+// slow consumer.
+type ChannelHandler struct {
+    ch chan<- LogRecord
+    level slog.Leveler
+    attrs string
+    prefix string
+}
+
+func NewChannelHandler(ch chan<- LogRecord, level LogLevel) *ChannelHandler {
+    return &ChannelHandler{ch: ch, level: level.Level()}
+}
+
+func (h *ChannelHandler) Enabled(_ context.Context, level slog.Level) bool {
+    return level >= h.level.Level()
+}
+
+func (h *ChannelHandler) Handle(_ context.Context, r slog.Record) error {
+    var b strings.Builder
+    b.WriteString(h.attrs)
+    r.Attrs(func(a slog.Attr) bool {
+        appendAttr(&b, h.prefix, a)
+        return true
+    })
+    select {
+    case h.ch <- LogRecord{Time: r.Time, Level: r.Level, Message: r.Message, Attrs: b.String()}:
+    default:
+    }
+    return nil
+}
+
+func (h *ChannelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    var b strings.Builder
+    b.WriteString(h.attrs)
+    for _, a := range attrs {
+        appendAttr(&b, h.prefix, a)
+    }
+    h2 := *h
+    h2.attrs = b.String()
+    return &h2
+}
+
+func (h *ChannelHandler) WithGroup(name string) slog.Handler {
+    if name == "" {
+        return h
+    }
+    h2 := *h
+    h2.prefix = h.prefix + name + "."
+    return &h2
+}
+
+func appendAttr(b *strings.Builder, prefix string, a slog.Attr) {
+    a.Value = a.Value.Resolve()
+    if a.Equal(slog.Attr{}) {
+        return
+    }
+    if a.Value.Kind() == slog.KindGroup {
+        if a.Key != "" {
+            prefix += a.Key + "."
+        }
+        for _, ga := range a.Value.Group() {
+            appendAttr(b, prefix, ga)
+        }
+        return
+    }
+    if b.Len() > 0 {
+        b.WriteByte(' ')
+    }
+    v := a.Value.String()
+    if v == "" || strings.ContainsAny(v, " =\"") {
+        v = strconv.Quote(v)
+    }
+    b.WriteString(prefix + a.Key + "=" + v)
+}
+
+// Fanout returns a handler that passes each record to all of hs
+func Fanout(hs ...slog.Handler) slog.Handler {
+    return fanoutHandler(hs)
+}
+
+type fanoutHandler []slog.Handler
+
+func (f fanoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+    for _, h := range f {
+        if h.Enabled(ctx, level) {
+            return true
+        }
+    }
+    return false
+}
+
+func (f fanoutHandler) Handle(ctx context.Context, r slog.Record) error {
+    var firstErr error
+    for _, h := range f {
+        if !h.Enabled(ctx, r.Level) {
+            continue
+        }
+        if err := h.Handle(ctx, r.Clone()); err != nil && firstErr == nil {
+            firstErr = err
+        }
+    }
+    return firstErr
+}
+
+func (f fanoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+    out := make(fanoutHandler, len(f))
+    for i, h := range f {
+        out[i] = h.WithAttrs(attrs)
+    }
+    return out
+}
+
+func (f fanoutHandler) WithGroup(name string) slog.Handler {
+    out := make(fanoutHandler, len(f))
+    for i, h := range f {
+        out[i] = h.WithGroup(name)
+    }
+    return out
+}
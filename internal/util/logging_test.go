@@ -0,0 +1,86 @@
+package util
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]LogLevel{"debug": Debug, "INFO": Info, "warn": Warning, "warning": Warning, "error": Error}
+	for in, want := range cases {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("Expected error for unknown level")
+	}
+}
+
+func TestLoggerFiltersByLevel(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewLogger(&buf, Warning)
+	log.Info("hidden")
+	log.Warn("shown", "peer", "bob")
+
+	out := buf.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("Info record should have been filtered: %q", out)
+	}
+	if !strings.Contains(out, "level=WARN") || !strings.Contains(out, "peer=bob") {
+		t.Errorf("Expected structured warning, got %q", out)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "node.log")
+	rf, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	current, _ := os.ReadFile(path)
+	if string(current) != "fourth\n" {
+		t.Errorf("Expected current file to hold the last line, got %q", current)
+	}
+	newest, _ := os.ReadFile(path + ".1")
+	if string(newest) != "third\n" {
+		t.Errorf("Expected %s.1 to hold the previous line, got %q", path, newest)
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Expected at most 2 backups")
+	}
+}
+
+func TestChannelHandlerNeverBlocks(t *testing.T) {
+	ch := make(chan LogRecord, 2)
+	log := slog.New(NewChannelHandler(ch, Info)).With("peer", "bob")
+
+	log.Debug("filtered")
+	for i := 0; i < 10; i++ {
+		log.Warn("dial failed", "attempt", i)
+	}
+
+	if len(ch) != 2 {
+		t.Fatalf("Expected channel to hold 2 records, got %d", len(ch))
+	}
+	rec := <-ch
+	if rec.Message != "dial failed" || rec.Level != slog.LevelWarn {
+		t.Errorf("Unexpected record %+v", rec)
+	}
+	if rec.Attrs != "peer=bob attempt=0" {
+		t.Errorf("Expected attrs 'peer=bob attempt=0', got %q", rec.Attrs)
+	}
+}
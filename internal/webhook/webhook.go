@@ -0,0 +1,223 @@
+// Package webhook notifies other systems about chat events. Each rule from
+// the config file has its own bounded queue and delivery goroutine, so a
+// slow or dead endpoint neither blocks the node nor delays other rules.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"gochat/internal/config"
+	"gochat/internal/util"
+)
+
+// Payloads waiting per rule; more are dropped with a warning
+const queueSize = 100
+
+const (
+    maxAttempts = 5
+    requestTimeout = 10 * time.Second
+)
+
+// Payload is the JSON body POSTed to a webhook URL
+type Payload struct {
+    Event string `json:"event"`
+    Node string `json:"node"` // name of the node sending the webhook
+    From string `json:"from,omitempty"`
+    Text string `json:"text,omitempty"`
+    Match string `json:"match,omitempty"` // mention or keyword that fired
+    Peer string `json:"peer,omitempty"` // join and leave only
+    Time time.Time `json:"time"`
+}
+
+type Dispatcher struct {
+    node string
+    log *slog.Logger
+    client *http.Client
+    hooks []*hook
+    backoff time.Duration // first retry delay, doubled each attempt
+}
+
+type hook struct {
+    url string
+    events map[string]bool
+    mentions []*matcher
+    keywords []*matcher
+    queue chan Payload
+}
+
+type matcher struct {
+    word string
+    re *regexp.Regexp
+}
+
+func newMatcher(word, prefix string) *matcher {
+    return &matcher{
+        word: word,
+        re: regexp.MustCompile(`(?i)(^|\W)` + prefix + regexp.QuoteMeta(word) + `($|\W)`),
+    }
+}
+
+func firstMatch(ms []*matcher, text string) string {
+    for _, m := range ms {
+        if m.re.MatchString(text) {
+            return m.word
+        }
+    }
+    return ""
+}
+
+// New builds a dispatcher for rules on behalf of the node called node
+func New(node string, rules []config.Webhook, log *slog.Logger) *Dispatcher {
+    d := &Dispatcher{
+        node: node,
+        log: util.OrDiscard(log),
+        client: &http.Client{Timeout: requestTimeout},
+        backoff: time.Second,
+    }
+    for _, r := range rules {
+        h := &hook{
+            url: r.URL,
+            events: make(map[string]bool),
+            queue: make(chan Payload, queueSize),
+        }
+        for _, e := range r.Events {
+            h.events[e] = true
+        }
+        mentions := r.Mentions
+        if len(mentions) == 0 {
+            mentions = []string{node}
+        }
+        for _, m := range mentions {
+            h.mentions = append(h.mentions, newMatcher(m, "@"))
+        }
+        for _, k := range r.Keywords {
+            h.keywords = append(h.keywords, newMatcher(k, ""))
+        }
+        d.hooks = append(d.hooks, h)
+    }
+    return d
+}
+
+// Run delivers queued payloads until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+    var wg sync.WaitGroup
+    for _, h := range d.hooks {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            d.deliverLoop(ctx, h)
+        }()
+    }
+    wg.Wait()
+}
+
+// Message checks a chat line against every rule. It never blocks.
+func (d *Dispatcher) Message(from, text string) {
+    for _, h := range d.hooks {
+        p := Payload{Node: d.node, From: from, Text: text, Time: time.Now()}
+        if h.events[config.EventMention] {
+            if m := firstMatch(h.mentions, text); m != "" {
+                p.Event, p.Match = config.EventMention, m
+                d.enqueue(h, p)
+                continue
+            }
+        }
+        if h.events[config.EventKeyword] {
+            if m := firstMatch(h.keywords, text); m != "" {
+                p.Event, p.Match = config.EventKeyword, m
+                d.enqueue(h, p)
+            }
+        }
+    }
+}
+
+// Peer reports a peer joining or leaving. It never blocks.
+func (d *Dispatcher) Peer(name string, joined bool) {
+    event := config.EventLeave
+    if joined {
+        event = config.EventJoin
+    }
+    for _, h := range d.hooks {
+        if h.events[event] {
+            d.enqueue(h, Payload{Event: event, Node: d.node, Peer: name, Time: time.Now()})
+        }
+    }
+}
+
+func (d *Dispatcher) enqueue(h *hook, p Payload) {
+    select {
+    case h.queue <- p:
+    default:
+        d.log.Warn("webhook queue full, dropping event", "url", h.url, "event", p.Event)
+    }
+}
+
+func (d *Dispatcher) deliverLoop(ctx context.Context, h *hook) {
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case p := <-h.queue:
+            d.deliver(ctx, h.url, p)
+        }
+    }
+}
+
+// deliver POSTs p, retrying with exponential backoff on network errors,
+// 429 and 5xx responses
+func (d *Dispatcher) deliver(ctx context.Context, url string, p Payload) {
+    body, err := json.Marshal(p)
+    if err != nil {
+        d.log.Error("failed to encode webhook payload", "err", err)
+        return
+    }
+
+    delay := d.backoff
+    for attempt := 1; ; attempt++ {
+        retry, err := d.post(ctx, url, body)
+        if err == nil {
+            d.log.Debug("webhook delivered", "url", url, "event", p.Event)
+            return
+        }
+        if !retry || attempt == maxAttempts {
+            d.log.Error("webhook delivery failed", "url", url, "event", p.Event, "attempts", attempt, "err", err)
+            return
+        }
+        d.log.Warn("webhook delivery failed, retrying", "url", url, "event", p.Event, "in", delay, "err", err)
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(delay):
+        }
+        delay *= 2
+    }
+}
+
+func (d *Dispatcher) post(ctx context.Context, url string, body []byte) (retry bool, err error) {
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+    if err != nil {
+        return false, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "gochat-webhook")
+
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return ctx.Err() == nil, err
+    }
+    resp.Body.Close()
+    if resp.StatusCode/100 == 2 {
+        return false, nil
+    }
+    retry = resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+    return retry, fmt.Errorf("endpoint returned %s", strings.TrimSpace(resp.Status))
+}
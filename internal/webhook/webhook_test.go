@@ -0,0 +1,144 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gochat/internal/config"
+)
+
+// endpoint records payloads, failing the first failures requests with 503
+func endpoint(t *testing.T, failures int32) (*httptest.Server, <-chan Payload, *atomic.Int32) {
+	t.Helper()
+	got := make(chan Payload, 10)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var p Payload
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			t.Errorf("Bad payload: %v", err)
+		}
+		got <- p
+	}))
+	t.Cleanup(srv.Close)
+	return srv, got, &calls
+}
+
+func startDispatcher(t *testing.T, rules []config.Webhook) *Dispatcher {
+	t.Helper()
+	d := New("alice", rules, nil)
+	d.backoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return d
+}
+
+func next(t *testing.T, ch <-chan Payload) Payload {
+	t.Helper()
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for webhook")
+		return Payload{}
+	}
+}
+
+func TestWebhookRules(t *testing.T) {
+	srv, got, _ := endpoint(t, 0)
+	d := startDispatcher(t, []config.Webhook{{
+		URL:      srv.URL,
+		Events:   []string{"mention", "keyword", "join"},
+		Keywords: []string{"deploy"},
+	}})
+
+	d.Message("bob", "no match here, alice")
+	d.Message("bob", "hey @Alice, lunch?")
+	d.Message("bob", "redeploying is not a keyword")
+	d.Message("bob", "starting the Deploy now")
+	d.Peer("carol", true)
+	d.Peer("carol", false)
+
+	p := next(t, got)
+	if p.Event != "mention" || p.From != "bob" || p.Match != "alice" || p.Node != "alice" {
+		t.Errorf("Unexpected mention payload %+v", p)
+	}
+	p = next(t, got)
+	if p.Event != "keyword" || p.Match != "deploy" || p.Text != "starting the Deploy now" {
+		t.Errorf("Unexpected keyword payload %+v", p)
+	}
+	p = next(t, got)
+	if p.Event != "join" || p.Peer != "carol" {
+		t.Errorf("Unexpected join payload %+v", p)
+	}
+	select {
+	case p := <-got:
+		t.Errorf("Unexpected payload %+v", p)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	srv, got, calls := endpoint(t, 2)
+	d := startDispatcher(t, []config.Webhook{{URL: srv.URL, Events: []string{"join"}}})
+
+	d.Peer("bob", true)
+	if p := next(t, got); p.Peer != "bob" {
+		t.Errorf("Unexpected payload %+v", p)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+}
+
+func TestWebhookGivesUpOnClientError(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+	d := New("alice", []config.Webhook{{URL: srv.URL, Events: []string{"join"}}}, nil)
+	d.backoff = time.Millisecond
+
+	d.deliver(context.Background(), srv.URL, Payload{Event: "join"})
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected a single attempt for a 404, got %d", n)
+	}
+}
+
+func TestWebhookQueueNeverBlocks(t *testing.T) {
+	// Nothing is delivering, so the queue fills up and the rest is dropped
+	d := New("alice", []config.Webhook{{URL: "http://127.0.0.1:1", Events: []string{"join"}}}, nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < queueSize*3; i++ {
+			d.Peer("bob", true)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Peer blocked on a full queue")
+	}
+	if n := len(d.hooks[0].queue); n != queueSize {
+		t.Errorf("Expected a full queue of %d, got %d", queueSize, n)
+	}
+}
@@ -0,0 +1,394 @@
+// Package bot is for writing automated gochat peers: relays, announcers,
+// command responders. A Bot is a full node in the mesh. It listens and
+// dials like the TUI does, and calls your handlers for every message,
+// join, leave and command it sees.
+//
+//    b := bot.New("helper")
+//    b.SetDataDir("/var/lib/helper")
+//    b.Command("ping", func(m *bot.Message, args []string) {
+//        m.Reply("pong")
+//    })
+//    b.Connect("10.0.0.7:9000")
+//    b.Run(ctx)
+//
+// Handlers run one at a time on the bot's event loop, so they can share
+// state without locking. A slow handler delays the ones after it.
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"gochat/internal/chat"
+	"gochat/internal/netx"
+	"gochat/internal/tui"
+	"gochat/internal/util"
+)
+
+// DefaultPrefix marks a message as a command, as in "!help"
+const DefaultPrefix = "!"
+
+// Delay between attempts to reach a peer given to Connect, doubling up to
+// maxRedial
+const (
+    minRedial = time.Second
+    maxRedial = 30 * time.Second
+)
+
+// How long messages wait for peers that are away once the bot has a data
+// directory, as for gochat itself
+const offlineTTL = 72 * time.Hour
+
+var ErrNotRunning = errors.New("bot is not running")
+
+// Message is a chat line received from a peer
+type Message struct {
+    ID string
+    From string
+    Text string
+    Room string // empty for the default room
+    ReplyTo string // ID of the message this one answers, if any
+    bot *Bot
+}
+
+// Reply answers m in its room, addressed to its sender. Frontends show the
+// answer threaded under m.
+func (m *Message) Reply(text string) error {
+    text = strings.TrimSpace(text)
+    if text == "" {
+        return nil
+    }
+    env := chat.NewChat(m.bot.name, m.From+": "+text)
+    env.Room = m.Room
+    env.ReplyTo = m.ID
+    chat.Broadcast(m.bot.room, env)
+    return nil
+}
+
+type MessageHandler func(m *Message)
+
+// CommandHandler gets the words after the command name as args
+type CommandHandler func(m *Message, args []string)
+
+// PeerHandler gets the name of the peer that joined or left
+type PeerHandler func(name string)
+
+type Bot struct {
+    name string
+    nodeID string
+    prefix string
+    log *slog.Logger
+    room *chat.ChatRoom
+    messages chan tui.Message
+    peerEvents chan chat.PeerEvent
+
+    onMessage []MessageHandler
+    onJoin []PeerHandler
+    onLeave []PeerHandler
+    commands map[string]CommandHandler
+
+    port int
+    listen bool
+    ln net.Listener
+    peers []string
+
+    mu sync.Mutex
+    ctx context.Context
+    wg sync.WaitGroup
+}
+
+// New creates a bot that appears in the room as name
+func New(name string) *Bot {
+    b := &Bot{
+        name: name,
+        prefix: DefaultPrefix,
+        log: util.Discard(),
+        room: chat.NewRoom(),
+        messages: make(chan tui.Message, 256),
+        peerEvents: make(chan chat.PeerEvent, 64),
+        commands: make(map[string]CommandHandler),
+    }
+    b.room.SetTUIMessageChannel(b.messages)
+    b.room.SetPeerEventChannel(b.peerEvents)
+    return b
+}
+
+func (b *Bot) Name() string {
+    return b.name
+}
+
+// SetLogger sets where connection errors and dropped events are logged.
+// Nothing is logged by default.
+func (b *Bot) SetLogger(l *slog.Logger) {
+    b.log = util.OrDiscard(l)
+    b.room.SetLogger(b.log)
+}
+
+// SetCommandPrefix changes the prefix that marks commands, "!" by default
+func (b *Bot) SetCommandPrefix(prefix string) {
+    b.prefix = prefix
+}
+
+// SetDataDir keeps the bot's key pair, the peers it has met and messages
+// waiting for them in dir, so peers know the bot by node ID across
+// restarts and nothing sent while one of them is away is lost. Without it
+// the bot connects with no identity, which peers may refuse. Call it
+// before Start.
+func (b *Bot) SetDataDir(dir string) error {
+    id, err := chat.LoadIdentity(filepath.Join(dir, "node_key"))
+    if err != nil {
+        return fmt.Errorf("failed to load node key: %w", err)
+    }
+    b.room.SetIdentity(id)
+    if err := b.room.SetOfflineStore(dir, offlineTTL); err != nil {
+        return fmt.Errorf("failed to load offline messages: %w", err)
+    }
+    b.nodeID = id.ID
+    return nil
+}
+
+// NodeID is the ID peers know the bot by, or empty without a data
+// directory
+func (b *Bot) NodeID() string {
+    return b.nodeID
+}
+
+// SetDownloadDir sets where files sent to the bot and accepted are saved
+func (b *Bot) SetDownloadDir(dir string) {
+    b.room.SetDownloadDir(dir)
+}
+
+// OnMessage registers a handler for every chat line, commands included
+func (b *Bot) OnMessage(fn MessageHandler) {
+    b.onMessage = append(b.onMessage, fn)
+}
+
+func (b *Bot) OnJoin(fn PeerHandler) {
+    b.onJoin = append(b.onJoin, fn)
+}
+
+func (b *Bot) OnLeave(fn PeerHandler) {
+    b.onLeave = append(b.onLeave, fn)
+}
+
+// Command registers fn for messages like "!name arg1 arg2"
+func (b *Bot) Command(name string, fn CommandHandler) {
+    b.commands[name] = fn
+}
+
+// Commands lists the registered command names
+func (b *Bot) Commands() []string {
+    names := make([]string, 0, len(b.commands))
+    for name := range b.commands {
+        names = append(names, name)
+    }
+    slices.Sort(names)
+    return names
+}
+
+// Listen makes the bot accept connections on port once it runs. Port 0
+// picks a free port.
+func (b *Bot) Listen(port int) {
+    b.port = port
+    b.listen = true
+}
+
+// Addr is the address the bot listens on, or nil if it does not
+func (b *Bot) Addr() net.Addr {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.ln == nil {
+        return nil
+    }
+    return b.ln.Addr()
+}
+
+// Connect adds a peer the bot keeps connected to while it runs, redialing
+// whenever the connection drops
+func (b *Bot) Connect(addr string) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.peers = append(b.peers, addr)
+    if b.ctx != nil {
+        b.wg.Add(1)
+        go b.keepConnected(b.ctx, addr)
+    }
+}
+
+// Start brings the bot up and returns; it runs until ctx is cancelled.
+// Register handlers before calling it.
+func (b *Bot) Start(ctx context.Context) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.ctx != nil {
+        return fmt.Errorf("bot %s is already running", b.name)
+    }
+
+    if b.listen {
+        ln, err := netx.Listen(b.port, b.log)
+        if err != nil {
+            return fmt.Errorf("failed to listen on port %d: %w", b.port, err)
+        }
+        b.ln = ln
+        b.wg.Add(1)
+        go netx.AcceptConnections(ctx, ln, b.name, &b.wg, b.room, b.log)
+    }
+    b.ctx = ctx
+    for _, addr := range b.peers {
+        b.wg.Add(1)
+        go b.keepConnected(ctx, addr)
+    }
+    b.wg.Add(1)
+    go b.loop(ctx)
+    return nil
+}
+
+// Wait blocks until a started bot has stopped and disconnected
+func (b *Bot) Wait() {
+    b.wg.Wait()
+    b.room.Shutdown()
+}
+
+// Run starts the bot and blocks until ctx is cancelled
+func (b *Bot) Run(ctx context.Context) error {
+    if err := b.Start(ctx); err != nil {
+        return err
+    }
+    b.Wait()
+    return nil
+}
+
+// Say broadcasts text to every connected peer
+func (b *Bot) Say(text string) error {
+    text = strings.TrimSpace(text)
+    if text == "" {
+        return nil
+    }
+    chat.Broadcast(b.room, chat.NewChat(b.name, text))
+    return nil
+}
+
+// SendFile offers the file at path to the named peer
+func (b *Bot) SendFile(peer, path string) error {
+    return b.room.SendFile(peer, path)
+}
+
+// Peers lists the names of the connected peers
+func (b *Bot) Peers() []string {
+    var names []string
+    for _, p := range b.room.ListPeers() {
+        names = append(names, p.Name)
+    }
+    return names
+}
+
+// Serve runs the peer protocol on an already established connection,
+// such as one end of a net.Pipe. It returns when the connection closes.
+func (b *Bot) Serve(conn net.Conn) error {
+    b.mu.Lock()
+    ctx := b.ctx
+    b.mu.Unlock()
+    if ctx == nil {
+        return ErrNotRunning
+    }
+    chat.PeerHandler(ctx, conn, b.name, b.room)
+    return nil
+}
+
+// Link connects two running bots in memory, without a network. It is
+// meant for tests: a mesh of bots can be built from Links alone.
+func Link(a, b *Bot) error {
+    ca, cb := net.Pipe()
+    if err := a.serveInBackground(ca); err != nil {
+        return err
+    }
+    if err := b.serveInBackground(cb); err != nil {
+        ca.Close()
+        return err
+    }
+    return nil
+}
+
+func (b *Bot) serveInBackground(conn net.Conn) error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.ctx == nil {
+        return ErrNotRunning
+    }
+    b.wg.Add(1)
+    go func() {
+        defer b.wg.Done()
+        chat.PeerHandler(b.ctx, conn, b.name, b.room)
+    }()
+    return nil
+}
+
+func (b *Bot) keepConnected(ctx context.Context, addr string) {
+    defer b.wg.Done()
+    delay := minRedial
+    for {
+        conn, err := netx.Dail(addr, b.log)
+        if err == nil {
+            delay = minRedial
+            chat.PeerHandler(ctx, conn, b.name, b.room)
+            conn.Close()
+        } else {
+            b.log.Warn("failed to connect to peer", "addr", addr, "err", err)
+        }
+
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(delay):
+        }
+        delay = min(delay*2, maxRedial)
+    }
+}
+
+// loop calls the handlers for each event in turn
+func (b *Bot) loop(ctx context.Context) {
+    defer b.wg.Done()
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case msg := <-b.messages:
+            if msg.Kind != tui.KindText || msg.From == "System" {
+                continue
+            }
+            b.dispatch(&Message{ID: msg.ID, From: msg.From, Text: msg.Text, Room: msg.Room, ReplyTo: msg.ReplyTo, bot: b})
+        case ev := <-b.peerEvents:
+            handlers := b.onLeave
+            if ev.Joined {
+                handlers = b.onJoin
+            }
+            for _, fn := range handlers {
+                fn(ev.Name)
+            }
+        }
+    }
+}
+
+func (b *Bot) dispatch(m *Message) {
+    for _, fn := range b.onMessage {
+        fn(m)
+    }
+    if !strings.HasPrefix(m.Text, b.prefix) {
+        return
+    }
+    fields := strings.Fields(strings.TrimPrefix(m.Text, b.prefix))
+    if len(fields) == 0 {
+        return
+    }
+    if fn, ok := b.commands[fields[0]]; ok {
+        fn(m, fields[1:])
+    }
+}
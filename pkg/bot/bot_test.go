@@ -0,0 +1,147 @@
+package bot
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startBot runs b until the test ends
+func startBot(t *testing.T, b *Bot) context.CancelFunc {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		b.Wait()
+	})
+	return cancel
+}
+
+func receive(t *testing.T, ch <-chan string) string {
+	t.Helper()
+	select {
+	case s := <-ch:
+		return s
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timeout waiting for event")
+		return ""
+	}
+}
+
+func TestBotCommands(t *testing.T) {
+	helper := New("helper")
+	helper.Command("echo", func(m *Message, args []string) {
+		m.Reply(strings.Join(args, " "))
+	})
+	helper.Command("help", func(m *Message, args []string) {
+		m.Reply("commands: " + strings.Join(helper.Commands(), ", "))
+	})
+
+	alice := New("alice")
+	heard := make(chan string, 10)
+	alice.OnMessage(func(m *Message) {
+		heard <- m.From + "> " + m.Text
+	})
+	joined := make(chan string, 10)
+	alice.OnJoin(func(name string) { joined <- name })
+
+	startBot(t, helper)
+	startBot(t, alice)
+	if err := Link(alice, helper); err != nil {
+		t.Fatal(err)
+	}
+	if name := receive(t, joined); name != "helper" {
+		t.Fatalf("Expected helper to join, got %q", name)
+	}
+
+	alice.Say("!echo hello   there")
+	if got := receive(t, heard); got != "helper> alice: hello there" {
+		t.Errorf("Unexpected reply %q", got)
+	}
+	alice.Say("!help")
+	if got := receive(t, heard); got != "helper> alice: commands: echo, help" {
+		t.Errorf("Unexpected reply %q", got)
+	}
+
+	// Unknown commands and plain chat get no reply
+	alice.Say("!nothing")
+	alice.Say("just chatting")
+	select {
+	case got := <-heard:
+		t.Errorf("Unexpected reply %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBotMeshJoinLeave(t *testing.T) {
+	a, b, c := New("a"), New("b"), New("c")
+	events := make(chan string, 10)
+	b.OnJoin(func(name string) { events <- "join " + name })
+	b.OnLeave(func(name string) { events <- "leave " + name })
+
+	startBot(t, a)
+	startBot(t, b)
+	stopC := startBot(t, c)
+	if err := Link(a, b); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, events); got != "join a" {
+		t.Fatalf("Expected a to join, got %q", got)
+	}
+	if err := Link(b, c); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, events); got != "join c" {
+		t.Fatalf("Expected c to join, got %q", got)
+	}
+	if peers := b.Peers(); len(peers) != 2 {
+		t.Errorf("Expected b to have 2 peers, got %v", peers)
+	}
+
+	stopC()
+	if got := receive(t, events); got != "leave c" {
+		t.Errorf("Expected c to leave, got %q", got)
+	}
+}
+
+func TestBotDataDir(t *testing.T) {
+	dir := t.TempDir()
+	a, b := New("a"), New("b")
+	if err := a.SetDataDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if len(a.NodeID()) != 32 || b.NodeID() != "" {
+		t.Errorf("Expected only a to have a node ID, got %q and %q", a.NodeID(), b.NodeID())
+	}
+	// Only a node with an identity of its own checks its peers'
+	if err := b.SetDataDir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	events := make(chan string, 10)
+	b.OnJoin(func(name string) { events <- name })
+	startBot(t, a)
+	startBot(t, b)
+	if err := Link(a, b); err != nil {
+		t.Fatal(err)
+	}
+	receive(t, events)
+	if p := b.room.FindPeerByName("a"); p == nil || p.NodeID != a.NodeID() {
+		t.Errorf("Expected b to know a by node ID %s, got %+v", a.NodeID(), p)
+	}
+
+	// The same directory gives the same identity
+	again := New("a")
+	if err := again.SetDataDir(dir); err != nil || again.NodeID() != a.NodeID() {
+		t.Errorf("Expected node ID %s kept, got %q, %v", a.NodeID(), again.NodeID(), err)
+	}
+}
+
+func TestLinkNeedsRunningBots(t *testing.T) {
+	if err := Link(New("a"), New("b")); err != ErrNotRunning {
+		t.Errorf("Expected ErrNotRunning, got %v", err)
+	}
+}
@@ -0,0 +1,35 @@
+package bot_test
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+
+	"gochat/pkg/bot"
+)
+
+// A bot that answers !help and greets everyone who joins
+func Example() {
+	b := bot.New("helpbot")
+	if err := b.SetDataDir("helpbot-data"); err != nil {
+		log.Fatal(err)
+	}
+	b.Command("help", func(m *bot.Message, args []string) {
+		m.Reply("I know: !" + strings.Join(b.Commands(), ", !"))
+	})
+	b.Command("roll", func(m *bot.Message, args []string) {
+		m.Reply("4 (chosen by fair dice roll)")
+	})
+	b.OnJoin(func(name string) {
+		b.Say("Welcome " + name + ", try !help")
+	})
+
+	b.Listen(9100)
+	b.Connect("127.0.0.1:9000")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	b.Run(ctx)
+}